@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+// handleApprovals lists approvals across executions, by default the pending ones.
+func handleApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := workflow.ApprovalStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = workflow.ApprovalPending
+	}
+
+	approvals, err := workflowManager.ListApprovals(workflow.ApprovalFilter{Status: status})
+	if err != nil {
+		logger.Error("Failed to list approvals: %v", err)
+		http.Error(w, "Failed to list approvals", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approvals)
+}
+
+func getExecutionApprovals(w http.ResponseWriter, r *http.Request, id int) {
+	if _, err := workflowManager.GetExecution(id); err != nil {
+		if errors.Is(err, workflow.ErrExecutionNotFound) {
+			http.Error(w, "Execution not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get execution: %v", err)
+		http.Error(w, "Failed to get execution", http.StatusInternalServerError)
+		return
+	}
+
+	approvals, err := workflowManager.ListApprovals(workflow.ApprovalFilter{ExecutionID: id})
+	if err != nil {
+		logger.Error("Failed to list approvals: %v", err)
+		http.Error(w, "Failed to list approvals", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approvals)
+}
+
+// decideApproval approves or rejects the pending approval of an execution as the user
+// the request's token belongs to.
+func decideApproval(w http.ResponseWriter, r *http.Request, id int, approve bool) {
+	tokenInfo, ok := TokenInfoFromContext(r.Context())
+	if !ok || tokenInfo.UserID == 0 {
+		http.Error(w, "Approvals must be decided with a user token", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	approval, err := workflowManager.DecideApproval(id, tokenInfo.UserID, approve, request.Comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, workflow.ErrExecutionNotFound):
+			http.Error(w, "Execution not found", http.StatusNotFound)
+		case errors.Is(err, workflow.ErrNoPendingApproval):
+			http.Error(w, "Execution is not waiting for approval", http.StatusConflict)
+		case errors.Is(err, workflow.ErrNotApprover):
+			http.Error(w, "You are not an approver of this step", http.StatusForbidden)
+		default:
+			logger.Error("Failed to decide approval: %v", err)
+			http.Error(w, "Failed to decide approval", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approval)
+}
@@ -206,6 +206,25 @@ func generateRandomToken(length int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// isAdmin reports whether the token of a request belongs to the admin user, the user
+// named by ADMIN_USERNAME.
+func isAdmin(r *http.Request) (bool, error) {
+	tokenInfo, ok := TokenInfoFromContext(r.Context())
+	if !ok || tokenInfo.UserID == 0 {
+		return false, nil
+	}
+
+	var username string
+	err := dbHandler.DB.QueryRow("SELECT username FROM users WHERE id = $1", tokenInfo.UserID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+	return username == getEnvOrDefault("ADMIN_USERNAME", "admin"), nil
+}
+
 func handleGenerateAdminToken(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
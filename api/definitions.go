@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+// maxDefinitionBody bounds the size of an imported workflow definition.
+const maxDefinitionBody = 4 << 20
+
+// handleImportWorkflow creates or updates a workflow from a YAML or JSON definition. With
+// ?dry_run=true the definition is only validated.
+func handleImportWorkflow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDefinitionBody+1))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxDefinitionBody {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	def, err := workflow.ParseDefinition(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(def)
+		return
+	}
+
+	result, err := workflowManager.ImportDefinition(def)
+	if err != nil {
+		if writeParameterError(w, err) {
+			return
+		}
+		if errors.Is(err, workflow.ErrAmbiguousWorkflow) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if writeLifecycleError(w, err) {
+			return
+		}
+		logger.Error("Failed to import workflow %q: %v", def.Name, err)
+		http.Error(w, "Failed to import workflow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// exportWorkflow returns the definition of a workflow, as YAML unless ?format=json.
+func exportWorkflow(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "yaml"
+	}
+	if format != "yaml" && format != "json" {
+		http.Error(w, "Invalid format (use yaml or json)", http.StatusBadRequest)
+		return
+	}
+
+	def, err := workflowManager.ExportDefinition(id)
+	if err != nil {
+		if errors.Is(err, workflow.ErrWorkflowNotFound) {
+			http.Error(w, "Workflow not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to export workflow: %v", err)
+		http.Error(w, "Failed to export workflow", http.StatusInternalServerError)
+		return
+	}
+
+	encoded, err := workflow.EncodeDefinition(def, format)
+	if err != nil {
+		logger.Error("Failed to encode workflow definition: %v", err)
+		http.Error(w, "Failed to export workflow", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("workflow-%d.%s", id, format)))
+	w.Write(encoded)
+}
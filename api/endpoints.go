@@ -11,6 +11,27 @@ func RegisterEndpoints() {
 	http.HandleFunc("/api/workflows", tokenAuthMiddleware(handleWorkflows))
 	http.HandleFunc("/api/workflows/", tokenAuthMiddleware(handleWorkflowByID))
 	http.HandleFunc("/api/workflows/schedule", tokenAuthMiddleware(handleScheduleWorkflow))
+	http.HandleFunc("/api/workflows/import", tokenAuthMiddleware(handleImportWorkflow))
+
+	http.HandleFunc("/api/executions", tokenAuthMiddleware(handleExecutions))
+	http.HandleFunc("/api/executions/", tokenAuthMiddleware(handleExecutionByID))
+	http.HandleFunc("/api/approvals", tokenAuthMiddleware(handleApprovals))
+	http.HandleFunc("/api/retention", tokenAuthMiddleware(handleRetention))
+	http.HandleFunc("/api/retention/run", tokenAuthMiddleware(handleRetentionRun))
+	http.HandleFunc("/api/secrets", tokenAuthMiddleware(handleSecrets))
+	http.HandleFunc("/api/secrets/", tokenAuthMiddleware(handleSecretByID))
+	http.HandleFunc("/api/jobs", tokenAuthMiddleware(handleJobs))
+	http.HandleFunc("/api/jobs/", tokenAuthMiddleware(handleJobByID))
+	http.HandleFunc("/api/jobs/replay", tokenAuthMiddleware(handleReplayJobs))
+	http.HandleFunc("/api/jobs/discard", tokenAuthMiddleware(handleDiscardJobs))
+	http.HandleFunc("/api/queues", tokenAuthMiddleware(handleQueues))
+
+	http.HandleFunc("/api/netbox/requests", tokenAuthMiddleware(handleNetboxRequests))
+	http.HandleFunc("/api/netbox/requests/", tokenAuthMiddleware(handleNetboxRequestByID))
+	http.HandleFunc("/api/netbox/queue", tokenAuthMiddleware(handleNetboxQueue))
+
+	// NetBox authenticates webhooks with their signature instead of a token.
+	http.HandleFunc("/api/webhooks/netbox", handleNetboxWebhook)
 
 	http.HandleFunc("/api/policies", tokenAuthMiddleware(handlePolicies))
 	http.HandleFunc("/api/policies/", tokenAuthMiddleware(handlePolicyByID))
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+func handleExecutions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseExecutionFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	executions, err := workflowManager.ListExecutions(filter)
+	if err != nil {
+		logger.Error("Failed to list executions: %v", err)
+		http.Error(w, "Failed to list executions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(executions)
+}
+
+func parseExecutionFilter(r *http.Request) (workflow.ExecutionFilter, error) {
+	query := r.URL.Query()
+	filter := workflow.ExecutionFilter{
+		Status: workflow.ExecutionStatus(query.Get("status")),
+	}
+
+	intParams := map[string]*int{
+		"workflow_id":         &filter.WorkflowID,
+		"parent_execution_id": &filter.ParentExecutionID,
+		"limit":               &filter.Limit,
+		"offset":              &filter.Offset,
+	}
+	for name, target := range intParams {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return filter, errors.New("Invalid " + name)
+			}
+			*target = n
+		}
+	}
+
+	timeParams := map[string]*time.Time{
+		"from": &filter.From,
+		"to":   &filter.To,
+	}
+	for name, target := range timeParams {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, errors.New("Invalid " + name + " format. Use RFC3339 format (e.g., 2025-01-01T12:00:00Z)")
+			}
+			*target = t
+		}
+	}
+
+	return filter, nil
+}
+
+func handleExecutionByID(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path[len("/api/executions/"):], "/")
+	parts := strings.Split(path, "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid execution ID", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "cancel":
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			cancelExecution(w, r, id)
+		case "attempts":
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			getExecutionAttempts(w, r, id)
+		case "steps":
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			getExecutionSteps(w, r, id)
+		case "approvals":
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			getExecutionApprovals(w, r, id)
+		case "approve", "reject":
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			decideApproval(w, r, id, parts[1] == "approve")
+		case "logs":
+			handleExecutionLogs(w, r, id)
+		case "resume":
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			resumeExecution(w, r, id)
+		case "tree":
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			getExecutionTree(w, r, id)
+		case "promote":
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			promoteExecution(w, r, id)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getExecution(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getExecution(w http.ResponseWriter, r *http.Request, id int) {
+	execution, err := workflowManager.GetExecution(id)
+	if err != nil {
+		if errors.Is(err, workflow.ErrExecutionNotFound) {
+			http.Error(w, "Execution not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get execution: %v", err)
+		http.Error(w, "Failed to get execution", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(execution)
+}
+
+func cancelExecution(w http.ResponseWriter, r *http.Request, id int) {
+	execution, err := workflowManager.CancelExecution(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, workflow.ErrExecutionNotFound):
+			http.Error(w, "Execution not found", http.StatusNotFound)
+		case errors.Is(err, workflow.ErrExecutionFinished):
+			http.Error(w, "Execution already finished", http.StatusConflict)
+		default:
+			logger.Error("Failed to cancel execution: %v", err)
+			http.Error(w, "Failed to cancel execution", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if execution.Status == workflow.ExecutionRunning {
+		// The worker running the execution finishes the cancellation asynchronously.
+		status = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(execution)
+}
+
+func getExecutionAttempts(w http.ResponseWriter, r *http.Request, id int) {
+	attempts, err := workflowManager.ListAttempts(id)
+	if err != nil {
+		if errors.Is(err, workflow.ErrExecutionNotFound) {
+			http.Error(w, "Execution not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to list execution attempts: %v", err)
+		http.Error(w, "Failed to list execution attempts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
+
+func getExecutionSteps(w http.ResponseWriter, r *http.Request, id int) {
+	if _, err := workflowManager.GetExecution(id); err != nil {
+		if errors.Is(err, workflow.ErrExecutionNotFound) {
+			http.Error(w, "Execution not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get execution: %v", err)
+		http.Error(w, "Failed to get execution", http.StatusInternalServerError)
+		return
+	}
+
+	steps, err := workflowManager.ListExecutionSteps(id)
+	if err != nil {
+		logger.Error("Failed to list execution steps: %v", err)
+		http.Error(w, "Failed to list execution steps", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(steps)
+}
+
+func getExecutionTree(w http.ResponseWriter, r *http.Request, id int) {
+	tree, err := workflowManager.GetExecutionTree(id)
+	if err != nil {
+		if errors.Is(err, workflow.ErrExecutionNotFound) {
+			http.Error(w, "Execution not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get execution tree: %v", err)
+		http.Error(w, "Failed to get execution tree", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+func resumeExecution(w http.ResponseWriter, r *http.Request, id int) {
+	execution, err := workflowManager.ResumeExecution(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, workflow.ErrExecutionNotFound):
+			http.Error(w, "Execution not found", http.StatusNotFound)
+		case errors.Is(err, workflow.ErrExecutionNotResumable):
+			http.Error(w, "Only failed or cancelled executions that are not applying a plan can be resumed", http.StatusConflict)
+		default:
+			logger.Error("Failed to resume execution: %v", err)
+			http.Error(w, "Failed to resume execution", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(execution)
+}
+
+// promoteExecution turns a completed dry run into an execution that applies its plan.
+func promoteExecution(w http.ResponseWriter, r *http.Request, id int) {
+	userID := 0
+	if tokenInfo, ok := TokenInfoFromContext(r.Context()); ok {
+		userID = tokenInfo.UserID
+	}
+	execution, err := workflowManager.PromotePlan(id, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, workflow.ErrExecutionNotFound):
+			http.Error(w, "Execution not found", http.StatusNotFound)
+		case errors.Is(err, workflow.ErrNotAPlan), errors.Is(err, workflow.ErrPlanNotCompleted),
+			errors.Is(err, workflow.ErrPlanAlreadyPromoted), errors.Is(err, workflow.ErrPlanHasSecrets):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, workflow.ErrNotApprover):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			logger.Error("Failed to promote execution: %v", err)
+			http.Error(w, "Failed to promote execution: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(execution)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/queue"
+)
+
+var jobQueue *queue.Queue
+
+func SetJobQueue(q *queue.Queue) {
+	jobQueue = q
+}
+
+// deadJobsRequest selects dead jobs of a queue to replay or discard. Without IDs, all
+// dead jobs of the queue are selected.
+type deadJobsRequest struct {
+	QueueName string `json:"queue_name"`
+	IDs       []int  `json:"ids"`
+}
+
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := queue.JobFilter{
+		QueueName: query.Get("queue_name"),
+		Status:    queue.JobStatus(query.Get("status")),
+	}
+	intParams := map[string]*int{
+		"limit":  &filter.Limit,
+		"offset": &filter.Offset,
+	}
+	for name, target := range intParams {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*target = n
+		}
+	}
+
+	jobs, err := jobQueue.ListJobs(filter)
+	if err != nil {
+		logger.Error("Failed to list jobs: %v", err)
+		http.Error(w, "Failed to list jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func handleJobByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(strings.Trim(r.URL.Path[len("/api/jobs/"):], "/"))
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := jobQueue.GetJob(id)
+	if err != nil {
+		if errors.Is(err, queue.ErrJobNotFound) {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get job: %v", err)
+		http.Error(w, "Failed to get job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleReplayJobs queues dead jobs again with a fresh set of attempts.
+func handleReplayJobs(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeDeadJobsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	replayed, err := jobQueue.Replay(request.QueueName, request.IDs)
+	if err != nil {
+		logger.Error("Failed to replay jobs: %v", err)
+		http.Error(w, "Failed to replay jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"replayed": replayed,
+	})
+}
+
+// handleDiscardJobs deletes dead jobs and their attempt history.
+func handleDiscardJobs(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeDeadJobsRequest(w, r)
+	if !ok {
+		return
+	}
+
+	discarded, err := jobQueue.Discard(request.QueueName, request.IDs)
+	if err != nil {
+		logger.Error("Failed to discard jobs: %v", err)
+		http.Error(w, "Failed to discard jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"discarded": discarded,
+	})
+}
+
+func decodeDeadJobsRequest(w http.ResponseWriter, r *http.Request) (deadJobsRequest, bool) {
+	var request deadJobsRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return request, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return request, false
+	}
+	if request.QueueName == "" {
+		http.Error(w, "queue_name is required", http.StatusBadRequest)
+		return request, false
+	}
+	return request, true
+}
+
+// handleQueues returns the number of jobs per status of every queue.
+func handleQueues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := jobQueue.Stats()
+	if err != nil {
+		logger.Error("Failed to count jobs: %v", err)
+		http.Error(w, "Failed to count jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
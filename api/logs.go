@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+const (
+	logPageSize          = 500
+	logPollInterval      = time.Second
+	logHeartbeatInterval = 15 * time.Second
+)
+
+// handleExecutionLogs returns the log lines of an execution after an offset. Clients that
+// accept text/event-stream get the lines as Server-Sent Events and keep receiving new
+// ones until the execution finishes; the event ID is the line's seq, so a reconnecting
+// EventSource resumes through Last-Event-ID.
+func handleExecutionLogs(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+
+	if _, err := workflowManager.GetExecution(id); err != nil {
+		if errors.Is(err, workflow.ErrExecutionNotFound) {
+			http.Error(w, "Execution not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get execution: %v", err)
+		http.Error(w, "Failed to get execution", http.StatusInternalServerError)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		streamExecutionLogs(w, r, id, offset)
+		return
+	}
+
+	limit := logPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > logPageSize {
+			http.Error(w, fmt.Sprintf("Invalid limit (1-%d)", logPageSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	lines, err := workflowManager.ListExecutionLogs(id, offset, limit)
+	if err != nil {
+		logger.Error("Failed to list execution logs: %v", err)
+		http.Error(w, "Failed to list execution logs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lines)
+}
+
+func streamExecutionLogs(w http.ResponseWriter, r *http.Request, id, offset int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	poll := time.NewTicker(logPollInterval)
+	defer poll.Stop()
+	lastWrite := time.Now()
+
+	for {
+		// The status is read before the lines so that no line written before the
+		// execution finished can be missed.
+		execution, err := workflowManager.GetExecution(id)
+		if err != nil {
+			logger.Error("Failed to get execution %d while streaming logs: %v", id, err)
+			return
+		}
+
+		lines, err := workflowManager.ListExecutionLogs(id, offset, logPageSize)
+		if err != nil {
+			logger.Error("Failed to list logs of execution %d: %v", id, err)
+			return
+		}
+
+		for _, line := range lines {
+			data, err := json.Marshal(line)
+			if err != nil {
+				logger.Error("Failed to encode log line: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", line.Seq, data)
+			offset = line.Seq
+		}
+
+		if len(lines) == 0 && isFinished(execution.Status) {
+			fmt.Fprintf(w, "event: end\ndata: {\"status\":%q}\n\n", execution.Status)
+			flusher.Flush()
+			return
+		}
+
+		if len(lines) > 0 {
+			lastWrite = time.Now()
+		} else if time.Since(lastWrite) >= logHeartbeatInterval {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			lastWrite = time.Now()
+		}
+		flusher.Flush()
+
+		if len(lines) == logPageSize {
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-poll.C:
+		}
+	}
+}
+
+func isFinished(status workflow.ExecutionStatus) bool {
+	switch status {
+	case workflow.ExecutionCompleted, workflow.ExecutionFailed, workflow.ExecutionCancelled:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/netbox"
+	"github.com/holonet/core/queue"
+)
+
+var gatekeeper *netbox.Gatekeeper
+
+// maxIdempotencyKeyLength leaves room for the caller in the stored key.
+const maxIdempotencyKeyLength = 200
+
+func SetGatekeeper(g *netbox.Gatekeeper) {
+	gatekeeper = g
+}
+
+// netboxRequestBody is a NetBox request to queue. Priority defaults to interactive, since
+// requests through the API usually come from a user waiting for them.
+type netboxRequestBody struct {
+	Method   string               `json:"method"`
+	Endpoint string               `json:"endpoint"`
+	Body     json.RawMessage      `json:"body"`
+	Priority queue.NetboxPriority `json:"priority"`
+}
+
+// netboxRequestView adds the response of a completed request, which is left out of
+// queue.NetboxQueuedRequest since NetBox may answer with an empty body.
+type netboxRequestView struct {
+	*queue.NetboxQueuedRequest
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+func newNetboxRequestView(request *queue.NetboxQueuedRequest) netboxRequestView {
+	view := netboxRequestView{NetboxQueuedRequest: request}
+	if len(request.Response) > 0 && json.Valid(request.Response) {
+		view.Response = request.Response
+	}
+	return view
+}
+
+// handleNetboxRequests queues a NetBox request and answers with its ID right away.
+func handleNetboxRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if gatekeeper == nil {
+		http.Error(w, "NetBox integration is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var request netboxRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.Method = strings.ToUpper(request.Method)
+	switch request.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		http.Error(w, "method must be GET, POST, PUT, PATCH or DELETE", http.StatusBadRequest)
+		return
+	}
+	request.Endpoint = strings.TrimPrefix(request.Endpoint, "/")
+	if request.Endpoint == "" {
+		http.Error(w, "endpoint is required", http.StatusBadRequest)
+		return
+	}
+
+	if request.Priority == "" {
+		request.Priority = queue.NetboxPriorityInteractive
+	}
+	if err := queue.ValidateNetboxPriority(request.Priority); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Requests of the same token owner take turns with those of other users and workflows.
+	options := queue.NetboxRequestOptions{Priority: request.Priority, Caller: netboxCaller(r)}
+	// Idempotency keys are kept per caller, so that users can't see each other's requests.
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		options.IdempotencyKey = options.Caller + ":" + key
+	}
+
+	var body interface{}
+	if len(request.Body) > 0 && string(request.Body) != "null" {
+		body = request.Body
+	}
+
+	queued, err := gatekeeper.SubmitRequest(options, request.Method, request.Endpoint, body)
+	if err != nil {
+		if errors.Is(err, queue.ErrIdempotencyKeyReused) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.Error("Failed to submit NetBox request: %v", err)
+		http.Error(w, "Failed to submit NetBox request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newNetboxRequestView(queued))
+}
+
+// netboxCaller names the token owner of a request as the caller of the NetBox requests
+// it queues.
+func netboxCaller(r *http.Request) string {
+	if tokenInfo, ok := TokenInfoFromContext(r.Context()); ok {
+		return fmt.Sprintf("user:%d", tokenInfo.UserID)
+	}
+	return ""
+}
+
+// handleNetboxRequestByID returns a queued request of the token owner. The admin user can
+// look up any request.
+func handleNetboxRequestByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if gatekeeper == nil {
+		http.Error(w, "NetBox integration is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	id, err := strconv.Atoi(strings.Trim(r.URL.Path[len("/api/netbox/requests/"):], "/"))
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	request, err := gatekeeper.GetQueuedRequest(id)
+	if err != nil {
+		if errors.Is(err, queue.ErrNetboxRequestNotFound) {
+			http.Error(w, "NetBox request not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get NetBox request: %v", err)
+		http.Error(w, "Failed to get NetBox request", http.StatusInternalServerError)
+		return
+	}
+	if caller := netboxCaller(r); caller == "" || request.Caller != caller {
+		admin, err := isAdmin(r)
+		if err != nil {
+			logger.Error("Failed to check admin user: %v", err)
+			http.Error(w, "Failed to get NetBox request", http.StatusInternalServerError)
+			return
+		}
+		if !admin {
+			http.Error(w, "NetBox request not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newNetboxRequestView(request))
+}
+
+// handleNetboxQueue returns how many requests wait in the NetBox queue, per caller, and
+// how long the oldest one has been waiting.
+func handleNetboxQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if gatekeeper == nil {
+		http.Error(w, "NetBox integration is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	stats, err := gatekeeper.QueueStats()
+	if err != nil {
+		logger.Error("Failed to get NetBox queue stats: %v", err)
+		http.Error(w, "Failed to get NetBox queue stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
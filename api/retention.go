@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+var retainer *workflow.Retainer
+
+func SetRetainer(r *workflow.Retainer) {
+	retainer = r
+}
+
+// handleRetention returns the retention stats of all workflows.
+func handleRetention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := retainer.Stats()
+	if err != nil {
+		logger.Error("Failed to get retention stats: %v", err)
+		http.Error(w, "Failed to get retention stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleRetentionRun applies the retention right away instead of waiting for the next
+// hourly run.
+func handleRetentionRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	run, err := retainer.RunOnce(r.Context())
+	if err != nil {
+		if errors.Is(err, workflow.ErrRetentionBusy) {
+			http.Error(w, "A retention run is already in progress", http.StatusConflict)
+			return
+		}
+		logger.Error("Failed to apply retention: %v", err)
+		if run == nil {
+			http.Error(w, "Failed to apply retention", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(run)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+func archiveWorkflow(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeWorkflowArchive(w, r, id)
+}
+
+// writeWorkflowArchive archives a workflow and answers with the archive result.
+func writeWorkflowArchive(w http.ResponseWriter, r *http.Request, id int) {
+	archive, err := retainer.ArchiveWorkflow(r.Context(), id)
+	if err != nil {
+		if writeLifecycleError(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, workflow.ErrWorkflowNotFound):
+			http.Error(w, "Workflow not found", http.StatusNotFound)
+		case errors.Is(err, workflow.ErrWorkflowHasExecutions):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			logger.Error("Failed to archive workflow: %v", err)
+			http.Error(w, "Failed to archive workflow", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archive)
+}
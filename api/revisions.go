@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+func handleWorkflowRevisions(w http.ResponseWriter, r *http.Request, workflowID int, rest []string) {
+	if len(rest) == 0 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		getRevisions(w, r, workflowID)
+		return
+	}
+
+	if rest[0] == "diff" && len(rest) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		diffRevisions(w, r, workflowID)
+		return
+	}
+
+	revision, err := strconv.Atoi(rest[0])
+	if err != nil || len(rest) > 2 {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	if len(rest) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		getRevision(w, r, workflowID, revision)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch rest[1] {
+	case "rollback":
+		wf, err := workflowManager.RollbackWorkflow(workflowID, revision)
+		writeRevisionChange(w, wf, err, "roll back workflow")
+	case "promote":
+		wf, err := workflowManager.PromoteRevision(workflowID, revision)
+		writeRevisionChange(w, wf, err, "promote revision")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeRevisionError(w http.ResponseWriter, err error, action string) {
+	if writeLifecycleError(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, workflow.ErrWorkflowNotFound):
+		http.Error(w, "Workflow not found", http.StatusNotFound)
+	case errors.Is(err, workflow.ErrRevisionNotFound):
+		http.Error(w, "Revision not found", http.StatusNotFound)
+	default:
+		logger.Error("Failed to %s: %v", action, err)
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+	}
+}
+
+func writeRevisionChange(w http.ResponseWriter, wf *workflow.Workflow, err error, action string) {
+	if err != nil {
+		writeRevisionError(w, err, action)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wf)
+}
+
+func getRevisions(w http.ResponseWriter, r *http.Request, workflowID int) {
+	revisions, err := workflowManager.ListRevisions(workflowID)
+	if err != nil {
+		writeRevisionError(w, err, "list revisions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+func getRevision(w http.ResponseWriter, r *http.Request, workflowID, revision int) {
+	result, err := workflowManager.GetRevision(workflowID, revision)
+	if err != nil {
+		writeRevisionError(w, err, "get revision")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func diffRevisions(w http.ResponseWriter, r *http.Request, workflowID int) {
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from revision", http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to revision", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := workflowManager.DiffRevisions(workflowID, from, to)
+	if err != nil {
+		writeRevisionError(w, err, "diff revisions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from": from,
+		"to":   to,
+		"diff": diff,
+	})
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+type scheduleRequest struct {
+	CronExpression  string                   `json:"cron_expression"`
+	Timezone        string                   `json:"timezone"`
+	Parameters      json.RawMessage          `json:"parameters"`
+	MissedRunPolicy workflow.MissedRunPolicy `json:"missed_run_policy"`
+	Enabled         *bool                    `json:"enabled"`
+}
+
+func (req *scheduleRequest) toSchedule(workflowID int) *workflow.WorkflowSchedule {
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	return &workflow.WorkflowSchedule{
+		WorkflowID:      workflowID,
+		CronExpression:  req.CronExpression,
+		Timezone:        req.Timezone,
+		Parameters:      req.Parameters,
+		MissedRunPolicy: req.MissedRunPolicy,
+		Enabled:         enabled,
+	}
+}
+
+func handleWorkflowSchedules(w http.ResponseWriter, r *http.Request, workflowID int, rest []string) {
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			getSchedules(w, r, workflowID)
+		case http.MethodPost:
+			createSchedule(w, r, workflowID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	scheduleID, err := strconv.Atoi(rest[0])
+	if err != nil || len(rest) > 1 {
+		http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getSchedule(w, r, workflowID, scheduleID)
+	case http.MethodPut:
+		updateSchedule(w, r, workflowID, scheduleID)
+	case http.MethodDelete:
+		deleteSchedule(w, r, workflowID, scheduleID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getSchedules(w http.ResponseWriter, r *http.Request, workflowID int) {
+	schedules, err := workflowManager.ListSchedules(workflowID)
+	if err != nil {
+		logger.Error("Failed to list schedules: %v", err)
+		http.Error(w, "Failed to list schedules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+func createSchedule(w http.ResponseWriter, r *http.Request, workflowID int) {
+	var request scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	schedule := request.toSchedule(workflowID)
+	if err := schedule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := workflowManager.CreateSchedule(schedule); err != nil {
+		if writeParameterError(w, err) {
+			return
+		}
+		logger.Error("Failed to create schedule: %v", err)
+		http.Error(w, "Failed to create schedule: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func getSchedule(w http.ResponseWriter, r *http.Request, workflowID, scheduleID int) {
+	schedule, err := workflowManager.GetSchedule(workflowID, scheduleID)
+	if err != nil {
+		if errors.Is(err, workflow.ErrScheduleNotFound) {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get schedule: %v", err)
+		http.Error(w, "Failed to get schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func updateSchedule(w http.ResponseWriter, r *http.Request, workflowID, scheduleID int) {
+	var request scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	schedule := request.toSchedule(workflowID)
+	schedule.ID = scheduleID
+	if err := schedule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := workflowManager.UpdateSchedule(schedule); err != nil {
+		if writeParameterError(w, err) {
+			return
+		}
+		if errors.Is(err, workflow.ErrScheduleNotFound) || errors.Is(err, workflow.ErrWorkflowNotFound) {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to update schedule: %v", err)
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func deleteSchedule(w http.ResponseWriter, r *http.Request, workflowID, scheduleID int) {
+	if err := workflowManager.DeleteSchedule(workflowID, scheduleID); err != nil {
+		if errors.Is(err, workflow.ErrScheduleNotFound) {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to delete schedule: %v", err)
+		http.Error(w, "Failed to delete schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Schedule deleted successfully",
+	})
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+var secretStore *workflow.SecretStore
+
+func SetSecretStore(store *workflow.SecretStore) {
+	secretStore = store
+}
+
+// secretRequest holds the fields of a secret. Value is only set when the secret is
+// created or its value changes; it is never returned.
+type secretRequest struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Value       *string `json:"value"`
+	WorkflowIDs []int   `json:"workflow_ids"`
+}
+
+// secretUpdateRequest holds the fields of a secret to change; fields that are left out
+// keep their value.
+type secretUpdateRequest struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Value       *string `json:"value"`
+	WorkflowIDs *[]int  `json:"workflow_ids"`
+}
+
+func handleSecrets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getSecrets(w, r)
+	case http.MethodPost:
+		createSecret(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleSecretByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.Trim(r.URL.Path[len("/api/secrets/"):], "/"))
+	if err != nil {
+		http.Error(w, "Invalid secret ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getSecret(w, r, id)
+	case http.MethodPut:
+		updateSecret(w, r, id)
+	case http.MethodDelete:
+		deleteSecret(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getSecrets(w http.ResponseWriter, r *http.Request) {
+	secrets, err := secretStore.ListSecrets()
+	if err != nil {
+		logger.Error("Failed to list secrets: %v", err)
+		http.Error(w, "Failed to list secrets", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secrets)
+}
+
+// createSecret stores a new secret. Only the admin can create a secret that workflows
+// may read.
+func createSecret(w http.ResponseWriter, r *http.Request) {
+	var request secretRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Value == nil {
+		http.Error(w, "value is required", http.StatusBadRequest)
+		return
+	}
+	if len(request.WorkflowIDs) > 0 && !requireAdmin(w, r, "Only the admin user can give workflows access to a secret") {
+		return
+	}
+
+	secret := &workflow.Secret{
+		Name:        request.Name,
+		Description: request.Description,
+		WorkflowIDs: request.WorkflowIDs,
+	}
+	if err := secret.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := secretStore.CreateSecret(secret, *request.Value); err != nil {
+		if errors.Is(err, workflow.ErrSecretExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, workflow.ErrSecretsDisabled) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		logger.Error("Failed to create secret: %v", err)
+		http.Error(w, "Failed to create secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(secret)
+}
+
+func getSecret(w http.ResponseWriter, r *http.Request, id int) {
+	secret, err := secretStore.GetSecret(id)
+	if err != nil {
+		if errors.Is(err, workflow.ErrSecretNotFound) {
+			http.Error(w, "Secret not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get secret: %v", err)
+		http.Error(w, "Failed to get secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secret)
+}
+
+// updateSecret changes the fields of a secret that the request has. The name cannot be
+// changed, and only the admin can change the value or which workflows may read the
+// secret.
+func updateSecret(w http.ResponseWriter, r *http.Request, id int) {
+	var request secretUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Value != nil && !requireAdmin(w, r, "Only the admin user can change the value of a secret") {
+		return
+	}
+	if request.WorkflowIDs != nil && !requireAdmin(w, r, "Only the admin user can change the workflows of a secret") {
+		return
+	}
+
+	secret, err := secretStore.GetSecret(id)
+	if err != nil {
+		if errors.Is(err, workflow.ErrSecretNotFound) {
+			http.Error(w, "Secret not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get secret: %v", err)
+		http.Error(w, "Failed to get secret", http.StatusInternalServerError)
+		return
+	}
+	if request.Name != "" && request.Name != secret.Name {
+		http.Error(w, "Secrets cannot be renamed", http.StatusBadRequest)
+		return
+	}
+
+	if request.Description != nil {
+		secret.Description = *request.Description
+	}
+	if request.WorkflowIDs != nil {
+		secret.WorkflowIDs = *request.WorkflowIDs
+	}
+	if err := secret.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := secretStore.UpdateSecret(secret, request.Value); err != nil {
+		if errors.Is(err, workflow.ErrSecretNotFound) {
+			http.Error(w, "Secret not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, workflow.ErrSecretsDisabled) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		logger.Error("Failed to update secret: %v", err)
+		http.Error(w, "Failed to update secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secret)
+}
+
+func deleteSecret(w http.ResponseWriter, r *http.Request, id int) {
+	if err := secretStore.DeleteSecret(id); err != nil {
+		if errors.Is(err, workflow.ErrSecretNotFound) {
+			http.Error(w, "Secret not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to delete secret: %v", err)
+		http.Error(w, "Failed to delete secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Secret deleted successfully",
+	})
+}
+
+// requireAdmin answers 403 Forbidden with message unless the request comes from the
+// admin user, and reports whether it did.
+func requireAdmin(w http.ResponseWriter, r *http.Request, message string) bool {
+	admin, err := isAdmin(r)
+	if err != nil {
+		logger.Error("Failed to check admin user: %v", err)
+		http.Error(w, "Failed to check admin user", http.StatusInternalServerError)
+		return false
+	}
+	if !admin {
+		http.Error(w, message, http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecretChangesRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"create with workflows", http.MethodPost, "/api/secrets", `{"name": "switch_password", "value": "s3cret", "workflow_ids": [1]}`},
+		{"change value", http.MethodPut, "/api/secrets/3", `{"value": "n3w-s3cret"}`},
+		{"change workflows", http.MethodPut, "/api/secrets/3", `{"workflow_ids": [1, 4]}`},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		r = r.WithContext(contextWithTokenInfo(r.Context(), &TokenInfo{ID: 7}))
+		w := httptest.NewRecorder()
+
+		if tt.method == http.MethodPost {
+			handleSecrets(w, r)
+		} else {
+			handleSecretByID(w, r)
+		}
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected %d for a non-admin token, got %d", tt.name, http.StatusForbidden, w.Code)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+type triggerRequest struct {
+	Name       string                 `json:"name"`
+	ObjectType string                 `json:"object_type"`
+	Actions    []string               `json:"actions"`
+	Filter     map[string]interface{} `json:"filter"`
+	Enabled    *bool                  `json:"enabled"`
+}
+
+func (req *triggerRequest) toTrigger(workflowID int) *workflow.WorkflowTrigger {
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	return &workflow.WorkflowTrigger{
+		WorkflowID: workflowID,
+		Name:       req.Name,
+		ObjectType: req.ObjectType,
+		Actions:    req.Actions,
+		Filter:     req.Filter,
+		Enabled:    enabled,
+	}
+}
+
+func handleWorkflowTriggers(w http.ResponseWriter, r *http.Request, workflowID int, rest []string) {
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			getTriggers(w, r, workflowID)
+		case http.MethodPost:
+			createTrigger(w, r, workflowID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	triggerID, err := strconv.Atoi(rest[0])
+	if err != nil || len(rest) > 1 {
+		http.Error(w, "Invalid trigger ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getTrigger(w, r, workflowID, triggerID)
+	case http.MethodPut:
+		updateTrigger(w, r, workflowID, triggerID)
+	case http.MethodDelete:
+		deleteTrigger(w, r, workflowID, triggerID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getTriggers(w http.ResponseWriter, r *http.Request, workflowID int) {
+	triggers, err := workflowManager.ListTriggers(workflowID)
+	if err != nil {
+		logger.Error("Failed to list triggers: %v", err)
+		http.Error(w, "Failed to list triggers", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(triggers)
+}
+
+func createTrigger(w http.ResponseWriter, r *http.Request, workflowID int) {
+	var request triggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	trigger := request.toTrigger(workflowID)
+	if err := trigger.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := workflowManager.CreateTrigger(trigger); err != nil {
+		if errors.Is(err, workflow.ErrWorkflowNotFound) {
+			http.Error(w, "Workflow not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to create trigger: %v", err)
+		http.Error(w, "Failed to create trigger", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(trigger)
+}
+
+func getTrigger(w http.ResponseWriter, r *http.Request, workflowID, triggerID int) {
+	trigger, err := workflowManager.GetTrigger(workflowID, triggerID)
+	if err != nil {
+		if errors.Is(err, workflow.ErrTriggerNotFound) {
+			http.Error(w, "Trigger not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get trigger: %v", err)
+		http.Error(w, "Failed to get trigger", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trigger)
+}
+
+func updateTrigger(w http.ResponseWriter, r *http.Request, workflowID, triggerID int) {
+	var request triggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	trigger := request.toTrigger(workflowID)
+	trigger.ID = triggerID
+	if err := trigger.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := workflowManager.UpdateTrigger(trigger); err != nil {
+		if errors.Is(err, workflow.ErrTriggerNotFound) {
+			http.Error(w, "Trigger not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to update trigger: %v", err)
+		http.Error(w, "Failed to update trigger", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trigger)
+}
+
+func deleteTrigger(w http.ResponseWriter, r *http.Request, workflowID, triggerID int) {
+	if err := workflowManager.DeleteTrigger(workflowID, triggerID); err != nil {
+		if errors.Is(err, workflow.ErrTriggerNotFound) {
+			http.Error(w, "Trigger not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to delete trigger: %v", err)
+		http.Error(w, "Failed to delete trigger", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Trigger deleted successfully",
+	})
+}
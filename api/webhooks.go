@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/workflow"
+)
+
+// maxWebhookBody bounds the size of an accepted webhook payload.
+const maxWebhookBody = 1 << 20
+
+// handleNetboxWebhook receives NetBox webhooks and starts the workflows whose triggers
+// match the event. Instead of a token, requests are authenticated by the X-Hook-Signature
+// header NetBox computes with the webhook's secret, which must be configured here as
+// NETBOX_WEBHOOK_SECRET.
+func handleNetboxWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := os.Getenv("NETBOX_WEBHOOK_SECRET")
+	if secret == "" {
+		logger.Warn("Rejected NetBox webhook: NETBOX_WEBHOOK_SECRET is not set")
+		http.Error(w, "Webhooks are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebhookBody {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !validWebhookSignature(secret, body, r.Header.Get("X-Hook-Signature")) {
+		logger.Warn("Rejected NetBox webhook from %s: invalid signature", r.RemoteAddr)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	delivery, err := workflowManager.HandleWebhookEvent(body)
+	if err != nil {
+		if errors.Is(err, workflow.ErrDuplicateDelivery) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"duplicate": true,
+			})
+			return
+		}
+		if errors.Is(err, workflow.ErrInvalidWebhook) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Error("Failed to handle NetBox webhook: %v", err)
+		http.Error(w, "Failed to handle webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -2,11 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/holonet/core/logger"
+	"github.com/holonet/core/queue"
 	"github.com/holonet/core/workflow"
 )
 
@@ -28,18 +31,41 @@ func handleWorkflows(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleWorkflowByID(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Path[len("/api/workflows/"):]
-	id, err := strconv.Atoi(idStr)
+	path := strings.Trim(r.URL.Path[len("/api/workflows/"):], "/")
+	parts := strings.Split(path, "/")
+	id, err := strconv.Atoi(parts[0])
 	if err != nil {
 		http.Error(w, "Invalid workflow ID", http.StatusBadRequest)
 		return
 	}
 
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "schedules":
+			handleWorkflowSchedules(w, r, id, parts[2:])
+		case "revisions":
+			handleWorkflowRevisions(w, r, id, parts[2:])
+		case "schema":
+			getWorkflowSchema(w, r, id)
+		case "triggers":
+			handleWorkflowTriggers(w, r, id, parts[2:])
+		case "export":
+			exportWorkflow(w, r, id)
+		case "archive":
+			archiveWorkflow(w, r, id)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		getWorkflow(w, r, id)
 	case http.MethodPut:
-		updateWorkflow(w, r, id)
+		updateWorkflow(w, r, id, false)
+	case http.MethodPatch:
+		updateWorkflow(w, r, id, true)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -55,6 +81,7 @@ func handleScheduleWorkflow(w http.ResponseWriter, r *http.Request) {
 		WorkflowID  int             `json:"workflow_id"`
 		Parameters  json.RawMessage `json:"parameters"`
 		ScheduledAt string          `json:"scheduled_at,omitempty"`
+		DryRun      bool            `json:"dry_run,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -74,8 +101,15 @@ func handleScheduleWorkflow(w http.ResponseWriter, r *http.Request) {
 		scheduledAt = time.Now()
 	}
 
-	execution, err := workflowManager.ScheduleWorkflow(request.WorkflowID, request.Parameters, scheduledAt)
+	schedule := workflowManager.ScheduleWorkflow
+	if request.DryRun {
+		schedule = workflowManager.ScheduleDryRun
+	}
+	execution, err := schedule(request.WorkflowID, request.Parameters, scheduledAt)
 	if err != nil {
+		if writeParameterError(w, err) {
+			return
+		}
 		logger.Error("Failed to schedule workflow: %v", err)
 		http.Error(w, "Failed to schedule workflow: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -99,9 +133,12 @@ func getWorkflows(w http.ResponseWriter, r *http.Request) {
 
 func createWorkflow(w http.ResponseWriter, r *http.Request) {
 	var request struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		Code        string `json:"code"`
+		Name            string          `json:"name"`
+		Description     string          `json:"description"`
+		Code            string          `json:"code"`
+		Steps           []workflow.Step `json:"steps"`
+		ParameterSchema json.RawMessage `json:"parameter_schema"`
+		policyRequest
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -109,8 +146,34 @@ func createWorkflow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	workflow, err := workflowManager.CreateWorkflow(request.Name, request.Description, request.Code)
-	if err != nil {
+	wf := &workflow.Workflow{
+		Name:            request.Name,
+		Description:     request.Description,
+		Code:            request.Code,
+		Steps:           request.Steps,
+		ParameterSchema: request.ParameterSchema,
+	}
+	if len(wf.ParameterSchema) > 0 {
+		if _, err := workflow.ParseSchema(wf.ParameterSchema); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(wf.Steps) > 0 {
+		if err := workflow.ValidateWorkflow(wf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if request.policyRequest != (policyRequest{}) {
+		wf.ExecutionPolicy = workflow.DefaultExecutionPolicy()
+		if err := request.apply(&wf.ExecutionPolicy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := workflowManager.InsertWorkflow(wf); err != nil {
 		logger.Error("Failed to create workflow: %v", err)
 		http.Error(w, "Failed to create workflow", http.StatusInternalServerError)
 		return
@@ -118,12 +181,16 @@ func createWorkflow(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(workflow)
+	json.NewEncoder(w).Encode(wf)
 }
 
 func getWorkflow(w http.ResponseWriter, r *http.Request, id int) {
 	wf, err := workflowManager.GetWorkflow(id)
 	if err != nil {
+		if errors.Is(err, workflow.ErrWorkflowNotFound) {
+			http.Error(w, "Workflow not found", http.StatusNotFound)
+			return
+		}
 		logger.Error("Failed to get workflow: %v", err)
 		http.Error(w, "Failed to get workflow", http.StatusInternalServerError)
 		return
@@ -133,32 +200,101 @@ func getWorkflow(w http.ResponseWriter, r *http.Request, id int) {
 	json.NewEncoder(w).Encode(wf)
 }
 
-func updateWorkflow(w http.ResponseWriter, r *http.Request, id int) {
-	var request struct {
-		Name        string                  `json:"name"`
-		Description string                  `json:"description"`
-		Code        string                  `json:"code"`
-		Status      workflow.WorkflowStatus `json:"status"`
-	}
+// workflowRequest holds the fields of a workflow update. Fields left out keep their
+// current value.
+type workflowRequest struct {
+	Name            *string                  `json:"name"`
+	Description     *string                  `json:"description"`
+	Code            *string                  `json:"code"`
+	Status          *workflow.WorkflowStatus `json:"status"`
+	Steps           *[]workflow.Step         `json:"steps"`
+	ParameterSchema *json.RawMessage         `json:"parameter_schema"`
+	policyRequest
+}
 
+// updateWorkflow handles PUT, which requires name and code, and PATCH, which changes only
+// the fields in the request. Setting the status to archived archives the workflow and
+// cannot be combined with other changes.
+func updateWorkflow(w http.ResponseWriter, r *http.Request, id int, partial bool) {
+	var request workflowRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !partial && (request.Name == nil || request.Code == nil) {
+		http.Error(w, "name and code are required, use PATCH to change single fields", http.StatusBadRequest)
+		return
+	}
+	if request.Status != nil {
+		switch *request.Status {
+		case workflow.StatusDraft, workflow.StatusActive, workflow.StatusInactive, workflow.StatusArchived:
+		default:
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+	}
 
 	wf, err := workflowManager.GetWorkflow(id)
 	if err != nil {
+		if errors.Is(err, workflow.ErrWorkflowNotFound) {
+			http.Error(w, "Workflow not found", http.StatusNotFound)
+			return
+		}
 		logger.Error("Failed to get workflow: %v", err)
 		http.Error(w, "Failed to get workflow", http.StatusInternalServerError)
 		return
 	}
 
-	wf.Name = request.Name
-	wf.Description = request.Description
-	wf.Code = request.Code
-	wf.Status = request.Status
+	if request.Status != nil && *request.Status == workflow.StatusArchived && wf.Status != workflow.StatusArchived {
+		changes := request
+		changes.Status = nil
+		if changes != (workflowRequest{}) {
+			http.Error(w, "Archiving a workflow cannot be combined with other changes", http.StatusBadRequest)
+			return
+		}
+		writeWorkflowArchive(w, r, id)
+		return
+	}
+
+	if request.Name != nil {
+		wf.Name = *request.Name
+	}
+	if request.Description != nil {
+		wf.Description = *request.Description
+	}
+	if request.Code != nil {
+		wf.Code = *request.Code
+	}
+	if request.Status != nil {
+		wf.Status = *request.Status
+	}
+	if request.Steps != nil {
+		wf.Steps = *request.Steps
+	}
+	if request.ParameterSchema != nil {
+		wf.ParameterSchema = *request.ParameterSchema
+	}
+	if err := request.apply(&wf.ExecutionPolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(wf.ParameterSchema) > 0 {
+		if _, err := workflow.ParseSchema(wf.ParameterSchema); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(wf.Steps) > 0 {
+		if err := workflow.ValidateWorkflow(wf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	if err := workflowManager.UpdateWorkflow(wf); err != nil {
+		if writeLifecycleError(w, err) {
+			return
+		}
 		logger.Error("Failed to update workflow: %v", err)
 		http.Error(w, "Failed to update workflow", http.StatusInternalServerError)
 		return
@@ -167,3 +303,107 @@ func updateWorkflow(w http.ResponseWriter, r *http.Request, id int) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(wf)
 }
+
+// getWorkflowSchema returns the parameter schema of a workflow so that clients can render
+// a form for it. Workflows without a schema accept any object.
+func getWorkflowSchema(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	wf, err := workflowManager.GetWorkflow(id)
+	if err != nil {
+		if errors.Is(err, workflow.ErrWorkflowNotFound) {
+			http.Error(w, "Workflow not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("Failed to get workflow: %v", err)
+		http.Error(w, "Failed to get workflow", http.StatusInternalServerError)
+		return
+	}
+
+	schema := wf.ParameterSchema
+	if len(schema) == 0 {
+		schema = json.RawMessage(`{"type":"object"}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(schema)
+}
+
+// writeParameterError answers with 400 and the field-level errors if err is a parameter
+// validation error, and reports whether it did.
+func writeParameterError(w http.ResponseWriter, err error) bool {
+	var validationErr *workflow.ParameterValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "Invalid parameters",
+		"fields": validationErr.Errors,
+	})
+	return true
+}
+
+// writeLifecycleError answers with 409 if err is a status change the workflow does not
+// allow and with 400 if the workflow cannot be activated, and reports whether it did.
+func writeLifecycleError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, workflow.ErrWorkflowArchived), errors.Is(err, workflow.ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, workflow.ErrActivationFailed):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		return false
+	}
+	return true
+}
+
+// policyRequest holds the optional execution policy fields of a workflow request. Fields
+// left out keep their current value.
+type policyRequest struct {
+	MaxRuntimeSeconds *int                      `json:"max_runtime_seconds"`
+	MaxAttempts       *int                      `json:"max_attempts"`
+	BackoffStrategy   *workflow.BackoffStrategy `json:"backoff_strategy"`
+	BackoffSeconds    *int                      `json:"backoff_seconds"`
+	MaxConcurrency    *int                      `json:"max_concurrency"`
+	ConcurrencyKey    *string                   `json:"concurrency_key"`
+	RetentionDays     *int                      `json:"retention_days"`
+	RetentionAction   *workflow.RetentionAction `json:"retention_action"`
+	NetboxPriority    *queue.NetboxPriority     `json:"netbox_priority"`
+}
+
+func (req policyRequest) apply(policy *workflow.ExecutionPolicy) error {
+	if req.MaxRuntimeSeconds != nil {
+		policy.MaxRuntimeSeconds = *req.MaxRuntimeSeconds
+	}
+	if req.MaxAttempts != nil {
+		policy.MaxAttempts = *req.MaxAttempts
+	}
+	if req.BackoffStrategy != nil {
+		policy.BackoffStrategy = *req.BackoffStrategy
+	}
+	if req.BackoffSeconds != nil {
+		policy.BackoffSeconds = *req.BackoffSeconds
+	}
+	if req.MaxConcurrency != nil {
+		policy.MaxConcurrency = *req.MaxConcurrency
+	}
+	if req.ConcurrencyKey != nil {
+		policy.ConcurrencyKey = *req.ConcurrencyKey
+	}
+	if req.RetentionDays != nil {
+		policy.RetentionDays = *req.RetentionDays
+	}
+	if req.RetentionAction != nil {
+		policy.RetentionAction = *req.RetentionAction
+	}
+	if req.NetboxPriority != nil {
+		policy.NetboxPriority = *req.NetboxPriority
+	}
+	return policy.Validate()
+}
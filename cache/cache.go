@@ -2,10 +2,14 @@ package cache
 
 import (
 	"context"
+	"time"
 )
 
 type CacheClient interface {
 	Ping(ctx context.Context) error
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
 	Options() *ValkeyOptions
 	Close() error
 }
@@ -1,8 +1,11 @@
 package cache
 
 import (
+	"bufio"
 	"context"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -58,3 +61,78 @@ func TestNewCacheClient(t *testing.T) {
 		t.Fatal("Options.Addr is empty")
 	}
 }
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{":42\r\n", int64(42)},
+		{"$5\r\nhello\r\n", "hello"},
+		{"$-1\r\n", nil},
+		{"$0\r\n\r\n", ""},
+	}
+
+	for _, tt := range tests {
+		reply, err := readReply(bufio.NewReader(strings.NewReader(tt.input)))
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tt.input, err)
+			continue
+		}
+		if reply != tt.expected {
+			t.Errorf("Expected %v for %q, got %v", tt.expected, tt.input, reply)
+		}
+	}
+
+	reply, err := readReply(bufio.NewReader(strings.NewReader("*2\r\n$1\r\na\r\n:1\r\n")))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 || items[0] != "a" || items[1] != int64(1) {
+		t.Errorf("Unexpected array reply: %v", reply)
+	}
+
+	if _, err := readReply(bufio.NewReader(strings.NewReader("-ERR wrong type\r\n"))); err == nil {
+		t.Error("Expected error reply to return an error")
+	}
+}
+
+func TestValkeyReconnectsAfterIOError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	// The first connection answers with half a reply and stalls, the second one properly.
+	replies := []string{"$5\r\nhe", "+PONG\r\n"}
+	go func() {
+		for _, reply := range replies {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Read(make([]byte, 1024))
+			conn.Write([]byte(reply))
+		}
+	}()
+
+	client := &ValkeyCacheClient{addr: ln.Addr().String()}
+	if err := client.connect(); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, err := client.Get(ctx, "key"); err == nil {
+		t.Fatal("Expected a partial reply to fail")
+	}
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Errorf("Expected the next command to reconnect, got %v", err)
+	}
+}
@@ -1,11 +1,17 @@
 package cache
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,6 +20,9 @@ type ValkeyCacheClient struct {
 	password string
 	db       int
 	conn     net.Conn
+	reader   *bufio.Reader
+	// mu serializes commands, since replies are read from the shared connection.
+	mu sync.Mutex
 }
 
 func NewValkeyCacheClient() (*ValkeyCacheClient, error) {
@@ -50,25 +59,28 @@ func NewValkeyCacheClient() (*ValkeyCacheClient, error) {
 	return nil, fmt.Errorf("failed to connect to Redis at %s after %d attempts", addr, maxRetries)
 }
 
+// commandTimeout bounds a command, including connecting again, since commands hold mu.
+const commandTimeout = 5 * time.Second
+
+// connect dials the server; the caller holds mu, except while the client is created.
 func (c *ValkeyCacheClient) connect() error {
-	var err error
-	c.conn, err = net.DialTimeout("tcp", c.addr, 5*time.Second)
+	conn, err := net.DialTimeout("tcp", c.addr, commandTimeout)
 	if err != nil {
 		return fmt.Errorf("failed to connect to Redis: %w", err)
 	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
 
 	if c.password != "" {
-		_, err = c.executeCommand(fmt.Sprintf("AUTH %s\r\n", c.password))
-		if err != nil {
-			c.conn.Close()
+		if _, err := c.command(context.Background(), "AUTH", c.password); err != nil {
+			c.reset()
 			return fmt.Errorf("failed to authenticate: %w", err)
 		}
 	}
 
 	if c.db != 0 {
-		_, err = c.executeCommand(fmt.Sprintf("SELECT %d\r\n", c.db))
-		if err != nil {
-			c.conn.Close()
+		if _, err := c.command(context.Background(), "SELECT", strconv.Itoa(c.db)); err != nil {
+			c.reset()
 			return fmt.Errorf("failed to select database: %w", err)
 		}
 	}
@@ -76,46 +88,168 @@ func (c *ValkeyCacheClient) connect() error {
 	return nil
 }
 
-func (c *ValkeyCacheClient) executeCommand(cmd string) (string, error) {
-	if c.conn == nil {
-		return "", fmt.Errorf("not connected to Redis")
+// reset closes a connection that may be out of sync with the server. The next command
+// connects again.
+func (c *ValkeyCacheClient) reset() {
+	if c.conn != nil {
+		c.conn.Close()
 	}
-	err := c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c.conn = nil
+	c.reader = nil
+}
+
+func (c *ValkeyCacheClient) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, "PING")
+	return err
+}
+
+// Get returns the value stored at key. The boolean is false if the key does not exist.
+func (c *ValkeyCacheClient) Get(ctx context.Context, key string) (string, bool, error) {
+	reply, err := c.do(ctx, "GET", key)
 	if err != nil {
-		return "", fmt.Errorf("failed to set deadline: %w", err)
+		return "", false, err
 	}
-	_, err = c.conn.Write([]byte(cmd))
-	if err != nil {
-		return "", fmt.Errorf("failed to send command: %w", err)
+	if reply == nil {
+		return "", false, nil
 	}
-	buf := make([]byte, 1024)
-	n, err := c.conn.Read(buf)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+	value, ok := reply.(string)
+	if !ok {
+		return "", false, fmt.Errorf("unexpected reply to GET: %v", reply)
 	}
-	return string(buf[:n]), nil
+	return value, true, nil
 }
 
-func (c *ValkeyCacheClient) Ping(ctx context.Context) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+// Set stores value at key. A ttl of zero keeps the key until it is overwritten or deleted.
+func (c *ValkeyCacheClient) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.do(ctx, args...)
+	return err
+}
+
+func (c *ValkeyCacheClient) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, "DEL", key)
+	return err
+}
+
+// do sends a command in the RESP protocol and returns the parsed reply: a string, an
+// int64, nil for a missing value, or a []interface{} for arrays. A lost connection is
+// dialed again.
+func (c *ValkeyCacheClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return c.command(ctx, args...)
+}
+
+// command sends a command on the connection; the caller holds mu. It waits for the reply
+// until the deadline of ctx, but at most commandTimeout. Any error but an error reply
+// leaves unread data on the connection, so the connection is closed.
+func (c *ValkeyCacheClient) command(ctx context.Context, args ...string) (interface{}, error) {
+	deadline := time.Now().Add(commandTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
 	}
-	resultCh := make(chan error, 1)
-	go func() {
-		_, err := c.executeCommand("PING\r\n")
-		resultCh <- err
-	}()
-	select {
-	case err := <-resultCh:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		c.reset()
+		return nil, fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(cmd.String())); err != nil {
+		c.reset()
+		return nil, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	reply, err := readReply(c.reader)
+	var replyErr *replyError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.reset()
+	}
+	return reply, err
+}
+
+// replyError is an error reply of the server, such as a command on a key of the wrong
+// type.
+type replyError struct {
+	Message string
+}
+
+func (e *replyError) Error() string {
+	return "redis error: " + e.Message
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty response")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, &replyError{Message: line[1:]}
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", line[1:])
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		return string(buf[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %q", line[1:])
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		// Error replies within an array are kept as items, so that the whole array is read.
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				var replyErr *replyError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = replyErr
+			}
+		}
+		return items, nil
 	}
+	return nil, fmt.Errorf("unexpected response %q", line)
 }
 
 func (c *ValkeyCacheClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.conn != nil {
 		return c.conn.Close()
 	}
@@ -11,6 +11,7 @@ import (
 	_ "github.com/holonet/core/database/tables"
 	"github.com/holonet/core/logger"
 	"github.com/holonet/core/netbox"
+	"github.com/holonet/core/queue"
 	"github.com/holonet/core/web"
 	"github.com/holonet/core/workflow"
 )
@@ -48,9 +49,18 @@ func main() {
 	}
 	workflowManager := workflow.NewWorkflowManager(dbHandler.DB)
 	workflowExecutor := workflow.NewExecutor(workflowManager)
+	workflowExecutor.SetCache(cacheClient)
+	workflowScheduler := workflow.NewScheduler(workflowManager)
+	workflowRetainer := workflow.NewRetainer(workflowManager)
+	workflowSecrets := workflow.NewSecretStore(workflowManager)
+	workflowExecutor.SetSecrets(workflowSecrets)
+	jobQueue := queue.New(dbHandler.DB)
 
 	api.SetDBHandler(dbHandler)
 	api.SetWorkflowManager(workflowManager)
+	api.SetRetainer(workflowRetainer)
+	api.SetSecretStore(workflowSecrets)
+	api.SetJobQueue(jobQueue)
 	users.SetDBHandler(dbHandler.DB)
 
 	api.RegisterEndpoints()
@@ -58,6 +68,9 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go workflowExecutor.StartExecutionLoop(ctx)
+	go workflowScheduler.StartSchedulerLoop(ctx)
+	go workflowRetainer.StartRetentionLoop(ctx)
+	go jobQueue.StartWorkers(ctx)
 
 	go web.StartServer(":3000")
 
@@ -69,8 +82,12 @@ func main() {
 		logger.Info("NetBox client initialized successfully.")
 
 		// Initialize the gatekeeper for API request management
-		gatekeeper := netbox.NewGatekeeper(netboxClient)
+		gatekeeper := netbox.NewGatekeeper(netboxClient, dbHandler.DB)
 		logger.Info("NetBox gatekeeper initialized successfully.")
+		api.SetGatekeeper(gatekeeper)
+
+		// Let workflow code reach NetBox through the gatekeeper
+		workflowExecutor.SetNetbox(gatekeeper)
 
 		// Initialize NetBox authentication
 		if err := netbox.InitNetboxAuth(netboxClient, gatekeeper, dbHandler.DB); err != nil {
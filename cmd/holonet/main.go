@@ -0,0 +1,309 @@
+// Command holonet manages workflow definitions kept in files, so that they can live in
+// git and be synced into a holonet server.
+//
+//	holonet validate FILE|DIR...
+//	holonet import [-dry-run] FILE|DIR...
+//	holonet export [-format yaml|json] [-o DIR] (-all | ID...)
+//
+// The server and token are taken from -server and -token, or HOLONET_URL and
+// HOLONET_TOKEN.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/workflow"
+)
+
+const usage = `Usage: holonet [-server URL] [-token TOKEN] <command> [arguments]
+
+Commands:
+  validate FILE|DIR...                           check definitions without a server
+  import [-dry-run] FILE|DIR...                  create or update workflows from definitions
+  export [-format yaml|json] [-o DIR] -all|ID... write workflow definitions
+`
+
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func main() {
+	flags := flag.NewFlagSet("holonet", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	server := flags.String("server", envOr("HOLONET_URL", "http://localhost:3000"), "holonet server URL")
+	token := flags.String("token", os.Getenv("HOLONET_TOKEN"), "API token")
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c := &client{
+		server: strings.TrimRight(*server, "/"),
+		token:  *token,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	var err error
+	switch command, args := flags.Arg(0), flags.Args()[1:]; command {
+	case "validate":
+		err = runValidate(args)
+	case "import":
+		err = c.runImport(args)
+	case "export":
+		err = c.runExport(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// definitionFiles expands directories to the YAML and JSON files directly inside them.
+func definitionFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files given")
+	}
+
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch filepath.Ext(entry.Name()) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+func runValidate(args []string) error {
+	files, err := definitionFiles(args)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			_, err = workflow.ParseDefinition(data)
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", file, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: ok\n", file)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d definitions are invalid", failed, len(files))
+	}
+	return nil
+}
+
+func (c *client) runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only validate the definitions on the server")
+	flags.Parse(args)
+
+	files, err := definitionFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	// Validate everything locally first so that a broken file does not leave the
+	// server half synced.
+	bodies := make([][]byte, len(files))
+	for i, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if _, err := workflow.ParseDefinition(data); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		bodies[i] = data
+	}
+
+	path := "/api/workflows/import"
+	if *dryRun {
+		path += "?dry_run=true"
+	}
+	for i, file := range files {
+		response, err := c.do(http.MethodPost, path, bodies[i])
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if *dryRun {
+			fmt.Printf("%s: ok\n", file)
+			continue
+		}
+
+		var result workflow.ImportResult
+		if err := json.Unmarshal(response, &result); err != nil {
+			return fmt.Errorf("%s: failed to decode response: %w", file, err)
+		}
+		fmt.Printf("%s: %s\n", file, describeImport(&result))
+	}
+	return nil
+}
+
+func describeImport(result *workflow.ImportResult) string {
+	action := "unchanged"
+	switch {
+	case result.Created:
+		action = "created"
+	case result.NewRevision:
+		action = fmt.Sprintf("updated to revision %d", result.Workflow.Revision)
+	}
+	summary := fmt.Sprintf("workflow %d %q %s", result.Workflow.ID, result.Workflow.Name, action)
+	if n := result.SchedulesCreated + result.SchedulesDeleted; n > 0 {
+		summary += fmt.Sprintf(", schedules +%d -%d", result.SchedulesCreated, result.SchedulesDeleted)
+	}
+	if n := result.TriggersCreated + result.TriggersDeleted; n > 0 {
+		summary += fmt.Sprintf(", triggers +%d -%d", result.TriggersCreated, result.TriggersDeleted)
+	}
+	return summary
+}
+
+func (c *client) runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "yaml", "yaml or json")
+	dir := flags.String("o", "", "directory to write <name>.<format> files to (default stdout)")
+	all := flags.Bool("all", false, "export every workflow")
+	flags.Parse(args)
+
+	type target struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	targets := []target{}
+	if *all {
+		response, err := c.do(http.MethodGet, "/api/workflows", nil)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(response, &targets); err != nil {
+			return fmt.Errorf("failed to decode workflows: %w", err)
+		}
+	}
+	for _, arg := range flags.Args() {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid workflow ID %q", arg)
+		}
+		targets = append(targets, target{ID: id})
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no workflows given")
+	}
+	if *dir == "" && len(targets) > 1 {
+		return fmt.Errorf("exporting more than one workflow needs -o")
+	}
+
+	for _, t := range targets {
+		data, err := c.do(http.MethodGet, fmt.Sprintf("/api/workflows/%d/export?format=%s", t.ID, *format), nil)
+		if err != nil {
+			return fmt.Errorf("workflow %d: %w", t.ID, err)
+		}
+		if *dir == "" {
+			os.Stdout.Write(data)
+			continue
+		}
+
+		def, err := workflow.ParseDefinition(data)
+		if err != nil {
+			return fmt.Errorf("workflow %d: %w", t.ID, err)
+		}
+		file := filepath.Join(*dir, fileName(def.Name)+"."+*format)
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("workflow %d: %s\n", t.ID, file)
+	}
+	return nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// fileName turns a workflow name into a file name, e.g. "Provision interface" into
+// "provision-interface".
+func fileName(name string) string {
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" {
+		return "workflow"
+	}
+	return name
+}
+
+func (c *client) do(method, path string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/yaml")
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if response.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s (%s)", strings.TrimSpace(string(data)), response.Status)
+	}
+	return data, nil
+}
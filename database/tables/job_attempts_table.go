@@ -0,0 +1,23 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var jobAttemptsTable = database.TableMigration{
+	Name: "job_attempts",
+	Columns: map[string]string{
+		"id":          "SERIAL PRIMARY KEY",
+		"job_id":      "INTEGER NOT NULL REFERENCES jobs(id) ON DELETE CASCADE",
+		"attempt":     "INTEGER NOT NULL",
+		"replay":      "INTEGER NOT NULL DEFAULT 0",
+		"worker_id":   "VARCHAR(255) NOT NULL",
+		"status":      "VARCHAR(20) NOT NULL",
+		"error":       "TEXT",
+		"started_at":  "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"finished_at": "TIMESTAMP",
+	},
+	Priority: 3,
+}
+
+func init() {
+	database.RegisterTable(jobAttemptsTable)
+}
@@ -0,0 +1,33 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var netboxRequestsTable = database.TableMigration{
+	Name: "netbox_requests",
+	Columns: map[string]string{
+		"id":              "SERIAL PRIMARY KEY",
+		"method":          "VARCHAR(10) NOT NULL",
+		"endpoint":        "TEXT NOT NULL",
+		"body":            "JSONB",
+		"priority":        "INTEGER NOT NULL DEFAULT 1",
+		"caller":          "VARCHAR(255) NOT NULL DEFAULT 'holonet'",
+		"idempotency_key": "VARCHAR(255) UNIQUE",
+		"status":          "VARCHAR(20) NOT NULL",
+		"attempts":        "INTEGER NOT NULL DEFAULT 0",
+		"max_attempts":    "INTEGER NOT NULL DEFAULT 5",
+		"retry_at":        "TIMESTAMP NOT NULL",
+		"response":        "BYTEA",
+		"error":           "TEXT",
+		"locked_by":       "VARCHAR(255)",
+		"locked_at":       "TIMESTAMP",
+		"sent_at":         "TIMESTAMP",
+		"created_at":      "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"updated_at":      "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"completed_at":    "TIMESTAMP",
+	},
+	Priority: 2,
+}
+
+func init() {
+	database.RegisterTable(netboxRequestsTable)
+}
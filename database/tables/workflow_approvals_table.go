@@ -0,0 +1,28 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var workflowApprovalsTable = database.TableMigration{
+	Name: "workflow_approvals",
+	Columns: map[string]string{
+		"id":           "SERIAL PRIMARY KEY",
+		"execution_id": "INTEGER NOT NULL REFERENCES workflow_executions(id) ON DELETE CASCADE",
+		"step":         "VARCHAR(255) NOT NULL",
+		"attempt":      "INTEGER NOT NULL DEFAULT 0",
+		"status":       "VARCHAR(20) NOT NULL",
+		"approvers":    "JSONB",
+		"message":      "TEXT",
+		"input":        "JSONB",
+		"expires_at":   "TIMESTAMP",
+		"decided_by":   "INTEGER",
+		"comment":      "TEXT",
+		"decided_at":   "TIMESTAMP",
+		"created_at":   "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"updated_at":   "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	Priority: 7,
+}
+
+func init() {
+	database.RegisterTable(workflowApprovalsTable)
+}
@@ -0,0 +1,21 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var workflowExecutionLogsTable = database.TableMigration{
+	Name: "workflow_execution_logs",
+	Columns: map[string]string{
+		"id":           "SERIAL PRIMARY KEY",
+		"execution_id": "INTEGER NOT NULL REFERENCES workflow_executions(id) ON DELETE CASCADE",
+		"seq":          "INTEGER NOT NULL",
+		"attempt":      "INTEGER NOT NULL DEFAULT 0",
+		"stream":       "VARCHAR(20) NOT NULL",
+		"message":      "TEXT NOT NULL",
+		"created_at":   "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	Priority: 7,
+}
+
+func init() {
+	database.RegisterTable(workflowExecutionLogsTable)
+}
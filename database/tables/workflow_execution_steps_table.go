@@ -0,0 +1,25 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var workflowExecutionStepsTable = database.TableMigration{
+	Name: "workflow_execution_steps",
+	Columns: map[string]string{
+		"id":            "SERIAL PRIMARY KEY",
+		"execution_id":  "INTEGER NOT NULL REFERENCES workflow_executions(id) ON DELETE CASCADE",
+		"name":          "VARCHAR(255) NOT NULL",
+		"status":        "VARCHAR(50) NOT NULL",
+		"input":         "JSONB",
+		"output":        "JSONB",
+		"error_message": "TEXT",
+		"started_at":    "TIMESTAMP",
+		"completed_at":  "TIMESTAMP",
+		"created_at":    "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"updated_at":    "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	Priority: 7,
+}
+
+func init() {
+	database.RegisterTable(workflowExecutionStepsTable)
+}
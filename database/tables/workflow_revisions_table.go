@@ -0,0 +1,20 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var workflowRevisionsTable = database.TableMigration{
+	Name: "workflow_revisions",
+	Columns: map[string]string{
+		"id":          "SERIAL PRIMARY KEY",
+		"workflow_id": "INTEGER NOT NULL REFERENCES workflows(id) ON DELETE CASCADE",
+		"revision":    "INTEGER NOT NULL",
+		"code":        "TEXT NOT NULL",
+		"steps":       "JSONB",
+		"created_at":  "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	Priority: 6,
+}
+
+func init() {
+	database.RegisterTable(workflowRevisionsTable)
+}
@@ -0,0 +1,25 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var workflowSchedulesTable = database.TableMigration{
+	Name: "workflow_schedules",
+	Columns: map[string]string{
+		"id":                "SERIAL PRIMARY KEY",
+		"workflow_id":       "INTEGER NOT NULL REFERENCES workflows(id) ON DELETE CASCADE",
+		"cron_expression":   "VARCHAR(255) NOT NULL",
+		"timezone":          "VARCHAR(64) NOT NULL DEFAULT 'UTC'",
+		"parameters":        "JSONB",
+		"missed_run_policy": "VARCHAR(20) NOT NULL DEFAULT 'skip'",
+		"enabled":           "BOOLEAN NOT NULL DEFAULT TRUE",
+		"next_run_at":       "TIMESTAMP",
+		"last_scheduled_at": "TIMESTAMP",
+		"created_at":        "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"updated_at":        "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	Priority: 7,
+}
+
+func init() {
+	database.RegisterTable(workflowSchedulesTable)
+}
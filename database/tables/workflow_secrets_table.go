@@ -0,0 +1,21 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var workflowSecretsTable = database.TableMigration{
+	Name: "workflow_secrets",
+	Columns: map[string]string{
+		"id":           "SERIAL PRIMARY KEY",
+		"name":         "VARCHAR(255) NOT NULL UNIQUE",
+		"description":  "TEXT",
+		"value":        "TEXT NOT NULL",
+		"workflow_ids": "JSONB NOT NULL DEFAULT '[]'",
+		"created_at":   "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"updated_at":   "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	Priority: 5,
+}
+
+func init() {
+	database.RegisterTable(workflowSecretsTable)
+}
@@ -0,0 +1,37 @@
+package tables
+
+import "github.com/holonet/core/database"
+
+var workflowTriggersTable = database.TableMigration{
+	Name: "workflow_triggers",
+	Columns: map[string]string{
+		"id":          "SERIAL PRIMARY KEY",
+		"workflow_id": "INTEGER NOT NULL REFERENCES workflows(id) ON DELETE CASCADE",
+		"name":        "VARCHAR(255)",
+		"object_type": "VARCHAR(255) NOT NULL",
+		"actions":     "JSONB",
+		"filter":      "JSONB",
+		"enabled":     "BOOLEAN NOT NULL DEFAULT TRUE",
+		"created_at":  "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"updated_at":  "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	Priority: 6,
+}
+
+var webhookDeliveriesTable = database.TableMigration{
+	Name: "webhook_deliveries",
+	Columns: map[string]string{
+		"id":            "SERIAL PRIMARY KEY",
+		"dedup_key":     "VARCHAR(64) NOT NULL UNIQUE",
+		"event":         "VARCHAR(20) NOT NULL",
+		"object_type":   "VARCHAR(255) NOT NULL",
+		"execution_ids": "JSONB",
+		"received_at":   "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	Priority: 6,
+}
+
+func init() {
+	database.RegisterTable(workflowTriggersTable)
+	database.RegisterTable(webhookDeliveriesTable)
+}
@@ -5,39 +5,82 @@ import "github.com/holonet/core/database"
 var workflowsTable = database.TableMigration{
 	Name: "workflows",
 	Columns: map[string]string{
-		"id":          "SERIAL PRIMARY KEY",
-		"name":        "VARCHAR(255) NOT NULL",
-		"description": "TEXT",
-		"code":        "TEXT NOT NULL",
-		"status":      "VARCHAR(50) NOT NULL",
-		"created_at":  "TIMESTAMP NOT NULL DEFAULT NOW()",
-		"updated_at":  "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"id":                  "SERIAL PRIMARY KEY",
+		"name":                "VARCHAR(255) NOT NULL",
+		"description":         "TEXT",
+		"code":                "TEXT NOT NULL",
+		"steps":               "JSONB",
+		"parameter_schema":    "JSONB",
+		"status":              "VARCHAR(50) NOT NULL",
+		"revision":            "INTEGER NOT NULL DEFAULT 0",
+		"active_revision":     "INTEGER NOT NULL DEFAULT 0",
+		"max_runtime_seconds": "INTEGER NOT NULL DEFAULT 3600",
+		"max_attempts":        "INTEGER NOT NULL DEFAULT 1",
+		"backoff_strategy":    "VARCHAR(20) NOT NULL DEFAULT 'exponential'",
+		"backoff_seconds":     "INTEGER NOT NULL DEFAULT 30",
+		"max_concurrency":     "INTEGER NOT NULL DEFAULT 0",
+		"concurrency_key":     "VARCHAR(255)",
+		"retention_days":      "INTEGER NOT NULL DEFAULT 0",
+		"retention_action":    "VARCHAR(20)",
+		"netbox_priority":     "VARCHAR(20)",
+		"created_at":          "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"updated_at":          "TIMESTAMP NOT NULL DEFAULT NOW()",
 	},
 	Priority: 5,
 }
 
 var workflowExecutionsTable = database.TableMigration{
 	Name: "workflow_executions",
+	Columns: map[string]string{
+		"id":                  "SERIAL PRIMARY KEY",
+		"workflow_id":         "INTEGER NOT NULL",
+		"status":              "VARCHAR(50) NOT NULL",
+		"parameters":          "JSONB",
+		"result":              "JSONB",
+		"error_message":       "TEXT",
+		"scheduled_at":        "TIMESTAMP NOT NULL",
+		"started_at":          "TIMESTAMP",
+		"completed_at":        "TIMESTAMP",
+		"locked_by":           "VARCHAR(255)",
+		"lease_expires_at":    "TIMESTAMP",
+		"schedule_id":         "INTEGER",
+		"cancel_requested_at": "TIMESTAMP",
+		"attempt":             "INTEGER NOT NULL DEFAULT 1",
+		"revision":            "INTEGER",
+		"compensations":       "JSONB",
+		"concurrency_key":     "VARCHAR(255)",
+		"mode":                "VARCHAR(20) NOT NULL DEFAULT 'run'",
+		"plan_execution_id":   "INTEGER",
+		"parent_execution_id": "INTEGER",
+		"parent_step":         "VARCHAR(255)",
+		"parent_attempt":      "INTEGER",
+		"created_at":          "TIMESTAMP NOT NULL DEFAULT NOW()",
+		"updated_at":          "TIMESTAMP NOT NULL DEFAULT NOW()",
+	},
+	ForeignKeys: map[string]string{
+		"workflow_id": "REFERENCES workflows(id)",
+	},
+	Priority: 6,
+}
+
+var workflowExecutionAttemptsTable = database.TableMigration{
+	Name: "workflow_execution_attempts",
 	Columns: map[string]string{
 		"id":            "SERIAL PRIMARY KEY",
-		"workflow_id":   "INTEGER NOT NULL",
+		"execution_id":  "INTEGER NOT NULL REFERENCES workflow_executions(id) ON DELETE CASCADE",
+		"attempt":       "INTEGER NOT NULL",
 		"status":        "VARCHAR(50) NOT NULL",
-		"parameters":    "JSONB",
-		"result":        "JSONB",
 		"error_message": "TEXT",
-		"scheduled_at":  "TIMESTAMP NOT NULL",
+		"worker_id":     "VARCHAR(255)",
 		"started_at":    "TIMESTAMP",
-		"completed_at":  "TIMESTAMP",
+		"completed_at":  "TIMESTAMP NOT NULL DEFAULT NOW()",
 		"created_at":    "TIMESTAMP NOT NULL DEFAULT NOW()",
-		"updated_at":    "TIMESTAMP NOT NULL DEFAULT NOW()",
-	},
-	ForeignKeys: map[string]string{
-		"workflow_id": "REFERENCES workflows(id)",
 	},
-	Priority: 6,
+	Priority: 7,
 }
 
 func init() {
 	database.RegisterTable(workflowsTable)
 	database.RegisterTable(workflowExecutionsTable)
+	database.RegisterTable(workflowExecutionAttemptsTable)
 }
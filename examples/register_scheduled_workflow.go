@@ -29,16 +29,6 @@ func main() {
 		log.Fatalf("Failed to register housekeeping workflow: %v", err)
 	}
 
-	// Schedule the workflow to run daily at midnight
-	scheduledTime := time.Now().Add(24 * time.Hour)
-	scheduledTime = time.Date(
-		scheduledTime.Year(),
-		scheduledTime.Month(),
-		scheduledTime.Day(),
-		0, 0, 0, 0,
-		scheduledTime.Location(),
-	)
-
 	// Create parameters for the workflow
 	parameters := map[string]interface{}{
 		"max_age_days": 30,
@@ -49,20 +39,23 @@ func main() {
 		log.Fatalf("Failed to marshal parameters: %v", err)
 	}
 
-	// Schedule the workflow
-	execution, err := workflowManager.ScheduleWorkflow(
-		housekeepingWorkflow.ID,
-		parametersJSON,
-		scheduledTime,
-	)
-	if err != nil {
+	// Schedule the workflow to run daily at midnight
+	schedule := &workflow.WorkflowSchedule{
+		WorkflowID:      housekeepingWorkflow.ID,
+		CronExpression:  "0 0 * * *",
+		Timezone:        "UTC",
+		Parameters:      parametersJSON,
+		MissedRunPolicy: workflow.MissedRunSkip,
+		Enabled:         true,
+	}
+	if err := workflowManager.CreateSchedule(schedule); err != nil {
 		log.Fatalf("Failed to schedule workflow: %v", err)
 	}
 
 	fmt.Printf("Housekeeping workflow scheduled successfully!\n")
 	fmt.Printf("Workflow ID: %d\n", housekeepingWorkflow.ID)
-	fmt.Printf("Execution ID: %d\n", execution.ID)
-	fmt.Printf("Scheduled time: %s\n", scheduledTime.Format(time.RFC3339))
+	fmt.Printf("Schedule ID: %d\n", schedule.ID)
+	fmt.Printf("Next run: %s\n", schedule.NextRunAt.Format(time.RFC3339))
 }
 
 // registerHousekeepingWorkflow registers the housekeeping workflow
@@ -3,3 +3,7 @@ module github.com/holonet/core
 go 1.23.4
 
 require github.com/lib/pq v1.10.9
+
+require github.com/traefik/yaegi v0.16.1
+
+require gopkg.in/yaml.v3 v3.0.1
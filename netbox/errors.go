@@ -0,0 +1,109 @@
+package netbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// APIError is an answer of NetBox with an error status.
+type APIError struct {
+	Method     string
+	Endpoint   string
+	StatusCode int
+	// Body is the answer of NetBox; for 400 Bad Request it holds the validation errors.
+	Body []byte
+	// RetryAfter is the wait NetBox asked for in the Retry-After header, or 0.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, string(e.Body))
+}
+
+// ValidationErrors returns the errors of a 400 Bad Request answer by field, such as
+// {"name": ["This field is required."]}, or nil for other answers.
+func (e *APIError) ValidationErrors() map[string]interface{} {
+	if e.StatusCode != http.StatusBadRequest {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(e.Body, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// Retriable reports whether sending the request again may succeed. NetBox handles a
+// request in a single transaction, but a 5xx from a proxy in front of it says nothing
+// about whether a POST was applied, so only 429 and 503 answers, which NetBox sends
+// before it handles the request, are retried for POST.
+func (e *APIError) Retriable() bool {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests, e.StatusCode == http.StatusServiceUnavailable:
+		return true
+	case e.StatusCode >= 500:
+		return idempotent(e.Method)
+	}
+	return false
+}
+
+func (e *APIError) RetryDelay() time.Duration {
+	return e.RetryAfter
+}
+
+// NetworkError is a request that got no answer from NetBox, such as a timeout.
+type NetworkError struct {
+	Method   string
+	Endpoint string
+	Err      error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("request failed: %v", e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the request timed out.
+func (e *NetworkError) Timeout() bool {
+	var netErr net.Error
+	return errors.As(e.Err, &netErr) && netErr.Timeout()
+}
+
+// Retriable reports whether sending the request again may succeed. A POST that timed out
+// may have been applied, so it is only retried when NetBox refused the connection.
+func (e *NetworkError) Retriable() bool {
+	return errors.Is(e.Err, syscall.ECONNREFUSED) || idempotent(e.Method)
+}
+
+func (e *NetworkError) RetryDelay() time.Duration {
+	return 0
+}
+
+// idempotent reports whether sending a request twice has the same effect as sending it
+// once.
+func idempotent(method string) bool {
+	return method != http.MethodPost
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package netbox
+
+import (
+	"errors"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/holonet/core/queue"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorsRetriable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retriable bool
+	}{
+		{"validation error", &APIError{Method: http.MethodPost, StatusCode: 400}, false},
+		{"not found", &APIError{Method: http.MethodGet, StatusCode: 404}, false},
+		{"rate limited", &APIError{Method: http.MethodPost, StatusCode: 429}, true},
+		{"maintenance", &APIError{Method: http.MethodPost, StatusCode: 503}, true},
+		{"server error on GET", &APIError{Method: http.MethodGet, StatusCode: 500}, true},
+		{"server error on POST", &APIError{Method: http.MethodPost, StatusCode: 502}, false},
+		{"timeout on PATCH", &NetworkError{Method: http.MethodPatch, Err: timeoutError{}}, true},
+		{"timeout on POST", &NetworkError{Method: http.MethodPost, Err: timeoutError{}}, false},
+		{"refused POST", &NetworkError{Method: http.MethodPost, Err: syscall.ECONNREFUSED}, true},
+		{"other error", errors.New("failed to marshal request body"), false},
+	}
+
+	for _, tt := range tests {
+		if retriable := queue.IsRetriable(tt.err); retriable != tt.retriable {
+			t.Errorf("%s: expected retriable %v, got %v", tt.name, tt.retriable, retriable)
+		}
+	}
+
+	if !(&NetworkError{Err: timeoutError{}}).Timeout() {
+		t.Errorf("Expected a timeout")
+	}
+}
+
+func TestAPIErrorValidationErrors(t *testing.T) {
+	err := &APIError{StatusCode: 400, Body: []byte(`{"name": ["This field is required."]}`)}
+	fields := err.ValidationErrors()
+	if _, ok := fields["name"]; !ok {
+		t.Errorf("Expected a validation error for name, got %v", fields)
+	}
+
+	err = &APIError{StatusCode: 500, Body: []byte(`{"detail": "oops"}`)}
+	if fields := err.ValidationErrors(); fields != nil {
+		t.Errorf("Expected no validation errors for a 500, got %v", fields)
+	}
+	if err.Error() != `API request failed with status 500: {"detail": "oops"}` {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if delay := parseRetryAfter("30"); delay != 30*time.Second {
+		t.Errorf("Expected 30s, got %v", delay)
+	}
+	if delay := parseRetryAfter(""); delay != 0 {
+		t.Errorf("Expected 0 without header, got %v", delay)
+	}
+	if delay := parseRetryAfter("soon"); delay != 0 {
+		t.Errorf("Expected 0 for an invalid header, got %v", delay)
+	}
+	at := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if delay := parseRetryAfter(at); delay <= 0 || delay > time.Minute {
+		t.Errorf("Expected up to a minute for %s, got %v", at, delay)
+	}
+}
@@ -2,6 +2,7 @@ package netbox
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -40,7 +41,7 @@ type RateLimiter struct {
 	mutex             sync.Mutex
 }
 
-func NewGatekeeper(client *Client) *Gatekeeper {
+func NewGatekeeper(client *Client, db *sql.DB) *Gatekeeper {
 	gk := &Gatekeeper{
 		client: client,
 		rateLimiter: &RateLimiter{
@@ -52,7 +53,7 @@ func NewGatekeeper(client *Client) *Gatekeeper {
 		cacheExpiry:  5 * time.Minute,
 	}
 
-	gk.netboxQueue = queue.NewNetboxQueue(gk)
+	gk.netboxQueue = queue.NewNetboxQueue(gk, db)
 
 	return gk
 }
@@ -71,7 +72,18 @@ func (g *Gatekeeper) SetCacheExpiry(duration time.Duration) {
 	g.cacheExpiry = duration
 }
 
+// Request sends a request on behalf of holonet itself, with normal priority.
 func (g *Gatekeeper) Request(method, endpoint string, body interface{}) ([]byte, error) {
+	return g.RequestWithOptions(queue.NetboxRequestOptions{}, method, endpoint, body)
+}
+
+// RequestWithOptions sends a request right away when the rate limit allows it and no
+// queued requests are waiting. Otherwise it queues the request with the given priority
+// and caller and blocks until it was sent. A request sent right away that fails with a
+// retriable error is queued for retry. Requests with an idempotency key are always
+// queued, so that the key is recorded before the request is sent. Errors from NetBox
+// are an *APIError or a *NetworkError.
+func (g *Gatekeeper) RequestWithOptions(options queue.NetboxRequestOptions, method, endpoint string, body interface{}) ([]byte, error) {
 	cacheKey := fmt.Sprintf("%s:%s", method, endpoint)
 	if method == http.MethodGet && g.cacheEnabled {
 		g.cacheMutex.RLock()
@@ -84,11 +96,30 @@ func (g *Gatekeeper) Request(method, endpoint string, body interface{}) ([]byte,
 		g.cacheMutex.RUnlock()
 	}
 
-	if !g.checkRateLimit() {
-		return g.netboxQueue.QueueRequest(method, endpoint, body)
+	if options.IdempotencyKey != "" || g.netboxQueue.Busy() || !g.checkRateLimit() {
+		return g.netboxQueue.QueueRequest(options, method, endpoint, body)
 	}
 
-	return g.executeRequestDirect(method, endpoint, body)
+	data, err := g.executeRequestDirect(method, endpoint, body)
+	if err != nil && queue.IsRetriable(err) {
+		return g.netboxQueue.RetryRequest(options, method, endpoint, body, err)
+	}
+	return data, err
+}
+
+// SubmitRequest queues a request without waiting for it. It is sent through the queue
+// even when the rate limit would allow it right away; poll GetQueuedRequest for the
+// outcome.
+func (g *Gatekeeper) SubmitRequest(options queue.NetboxRequestOptions, method, endpoint string, body interface{}) (*queue.NetboxQueuedRequest, error) {
+	return g.netboxQueue.SubmitRequest(options, method, endpoint, body)
+}
+
+func (g *Gatekeeper) GetQueuedRequest(id int) (*queue.NetboxQueuedRequest, error) {
+	return g.netboxQueue.GetRequest(id)
+}
+
+func (g *Gatekeeper) QueueStats() (*queue.NetboxQueueStats, error) {
+	return g.netboxQueue.Stats()
 }
 
 func (g *Gatekeeper) executeRequestDirect(method, endpoint string, body interface{}) ([]byte, error) {
@@ -122,18 +153,24 @@ func (g *Gatekeeper) executeRequestDirect(method, endpoint string, body interfac
 
 	resp, err := g.client.Client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %v", err)
+		return nil, &NetworkError{Method: method, Endpoint: endpoint, Err: err}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		errorBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(errorBody))
+		return nil, &APIError{
+			Method:     method,
+			Endpoint:   endpoint,
+			StatusCode: resp.StatusCode,
+			Body:       errorBody,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
 	}
 
 	responseData, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, &NetworkError{Method: method, Endpoint: endpoint, Err: fmt.Errorf("failed to read response body: %w", err)}
 	}
 
 	if method == http.MethodGet && g.cacheEnabled {
@@ -166,6 +203,28 @@ func (g *Gatekeeper) checkRateLimit() bool {
 	return true
 }
 
+// ReserveRequest takes a request from the rate limit budget for the queue. When the
+// budget is used up, it returns the time until the budget is renewed.
+func (g *Gatekeeper) ReserveRequest() time.Duration {
+	if g.checkRateLimit() {
+		return 0
+	}
+
+	g.rateLimiter.mutex.Lock()
+	defer g.rateLimiter.mutex.Unlock()
+	return max(time.Until(g.rateLimiter.resetTime), time.Millisecond)
+}
+
+// ReleaseRequest gives back a request reserved with ReserveRequest that was not sent.
+// Nothing is given back once the budget it was taken from has been renewed.
+func (g *Gatekeeper) ReleaseRequest() {
+	g.rateLimiter.mutex.Lock()
+	defer g.rateLimiter.mutex.Unlock()
+	if time.Now().Before(g.rateLimiter.resetTime) && g.rateLimiter.requestCount > 0 {
+		g.rateLimiter.requestCount--
+	}
+}
+
 func (g *Gatekeeper) ClearCache() {
 	g.cacheMutex.Lock()
 	g.cache = make(map[string]CachedResponse)
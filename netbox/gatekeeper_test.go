@@ -0,0 +1,35 @@
+package netbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReleaseRequest(t *testing.T) {
+	g := &Gatekeeper{rateLimiter: &RateLimiter{requestsPerMinute: 1, resetTime: time.Now().Add(time.Minute)}}
+
+	if wait := g.ReserveRequest(); wait != 0 {
+		t.Fatalf("Expected a request to be reserved, got a wait of %v", wait)
+	}
+	if wait := g.ReserveRequest(); wait == 0 {
+		t.Fatalf("Expected the budget to be used up")
+	}
+
+	g.ReleaseRequest()
+	if wait := g.ReserveRequest(); wait != 0 {
+		t.Errorf("Expected a released request to be reserved again, got a wait of %v", wait)
+	}
+
+	g.ReleaseRequest()
+	g.ReleaseRequest()
+	if g.rateLimiter.requestCount != 0 {
+		t.Errorf("Expected releasing more than was reserved to stop at 0, got %d", g.rateLimiter.requestCount)
+	}
+
+	g.ReserveRequest()
+	g.rateLimiter.resetTime = time.Now().Add(-time.Second)
+	g.ReleaseRequest()
+	if g.rateLimiter.requestCount != 1 {
+		t.Errorf("Expected nothing to be given back to a renewed budget, got %d", g.rateLimiter.requestCount)
+	}
+}
@@ -0,0 +1,276 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/holonet/core/logger"
+)
+
+type AttemptStatus string
+
+const (
+	AttemptRunning   AttemptStatus = "running"
+	AttemptCompleted AttemptStatus = "completed"
+	AttemptFailed    AttemptStatus = "failed"
+	// AttemptLost attempts ended because their worker stopped sending heartbeats.
+	AttemptLost AttemptStatus = "lost"
+)
+
+const (
+	defaultJobListLimit = 50
+	maxJobListLimit     = 500
+)
+
+// JobAttempt is a row of the job_attempts table, one claim of a job by a worker. Replay
+// is the number of replays of the job before the attempt.
+type JobAttempt struct {
+	ID         int           `json:"id"`
+	JobID      int           `json:"job_id"`
+	Attempt    int           `json:"attempt"`
+	Replay     int           `json:"replay"`
+	WorkerID   string        `json:"worker_id"`
+	Status     AttemptStatus `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+}
+
+// JobFilter selects the jobs returned by ListJobs. Empty fields match every job.
+type JobFilter struct {
+	QueueName string
+	Status    JobStatus
+	Limit     int
+	Offset    int
+}
+
+// QueueStats counts the jobs of one queue per status.
+type QueueStats struct {
+	QueueName string            `json:"queue_name"`
+	Jobs      map[JobStatus]int `json:"jobs"`
+}
+
+// finishAttempt ends the latest attempt of a job with status and, for failures, the error.
+func finishAttempt(tx *sql.Tx, jobID int, status AttemptStatus, errMsg string) error {
+	query := `
+		UPDATE job_attempts
+		SET status = $1, error = NULLIF($2, ''), finished_at = NOW()
+		WHERE id = (SELECT MAX(id) FROM job_attempts WHERE job_id = $3)
+	`
+	if _, err := tx.Exec(query, status, errMsg, jobID); err != nil {
+		return fmt.Errorf("failed to record job attempt: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue) jobAttempts(jobID int) ([]*JobAttempt, error) {
+	query := `
+		SELECT id, job_id, attempt, replay, worker_id, status, error, started_at, finished_at
+		FROM job_attempts
+		WHERE job_id = $1
+		ORDER BY id
+	`
+	rows, err := q.db.Query(query, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []*JobAttempt{}
+	for rows.Next() {
+		attempt := &JobAttempt{}
+		var errMsg sql.NullString
+		var finishedAt sql.NullTime
+		err := rows.Scan(&attempt.ID, &attempt.JobID, &attempt.Attempt, &attempt.Replay, &attempt.WorkerID, &attempt.Status,
+			&errMsg, &attempt.StartedAt, &finishedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job attempt: %w", err)
+		}
+		attempt.Error = errMsg.String
+		if finishedAt.Valid {
+			attempt.FinishedAt = &finishedAt.Time
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// ListJobs returns the jobs matching filter, most recently updated first.
+func (q *Queue) ListJobs(filter JobFilter) ([]*Job, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultJobListLimit
+	}
+	if limit > maxJobListLimit {
+		limit = maxJobListLimit
+	}
+
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE ($1 = '' OR queue_name = $1) AND ($2 = '' OR status = $2)
+		ORDER BY updated_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+	rows, err := q.db.Query(query, filter.QueueName, filter.Status, limit, filter.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Stats counts the jobs of every queue per status.
+func (q *Queue) Stats() ([]*QueueStats, error) {
+	rows, err := q.db.Query(`SELECT queue_name, status, COUNT(*) FROM jobs GROUP BY queue_name, status ORDER BY queue_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count jobs: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []*QueueStats{}
+	for rows.Next() {
+		var queueName string
+		var status JobStatus
+		var count int
+		if err := rows.Scan(&queueName, &status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan job count: %w", err)
+		}
+		if len(stats) == 0 || stats[len(stats)-1].QueueName != queueName {
+			stats = append(stats, &QueueStats{QueueName: queueName, Jobs: map[JobStatus]int{}})
+		}
+		stats[len(stats)-1].Jobs[status] = count
+	}
+	return stats, rows.Err()
+}
+
+// Replay queues dead jobs of a queue again with a fresh set of attempts. Without ids it
+// replays every dead job of the queue. The attempt history and last error are kept, and
+// later attempts continue the numbering of the history. It returns the IDs of the
+// replayed jobs; ids that are not dead jobs of the queue are skipped.
+func (q *Queue) Replay(queueName string, ids []int) ([]int, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	jobs, err := lockDeadJobs(tx, queueName, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay jobs: %w", err)
+	}
+
+	query := `
+		UPDATE jobs
+		SET status = $1, replays = $2, replayed_attempts = $3, available_at = NOW(), updated_at = NOW()
+		WHERE id = $4
+	`
+	replayed := []int{}
+	for _, job := range jobs {
+		job.replay()
+		if _, err := tx.Exec(query, job.Status, job.Replays, job.ReplayedAttempts, job.ID); err != nil {
+			return nil, fmt.Errorf("failed to replay job %d: %w", job.ID, err)
+		}
+		replayed = append(replayed, job.ID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if len(replayed) > 0 {
+		logger.Info("Replayed %d dead job(s) on queue %s", len(replayed), queueName)
+		q.notify()
+	}
+	return replayed, nil
+}
+
+// Discard deletes dead jobs of a queue together with their attempt history. Without ids
+// it discards every dead job of the queue. It returns the IDs of the discarded jobs.
+func (q *Queue) Discard(queueName string, ids []int) ([]int, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	jobs, err := lockDeadJobs(tx, queueName, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discard jobs: %w", err)
+	}
+
+	discarded := []int{}
+	for _, job := range jobs {
+		discarded = append(discarded, job.ID)
+	}
+	if len(discarded) > 0 {
+		if _, err := tx.Exec(`DELETE FROM jobs WHERE id = ANY($1)`, pq.Array(discarded)); err != nil {
+			return nil, fmt.Errorf("failed to discard jobs: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if len(discarded) > 0 {
+		logger.Info("Discarded %d dead job(s) on queue %s", len(discarded), queueName)
+	}
+	return discarded, nil
+}
+
+// replay gives a dead job a fresh set of attempts. Its attempt count keeps counting from
+// where it died, and its last error is kept until the next attempt ends.
+func (j *Job) replay() {
+	j.Status = JobPending
+	j.Replays++
+	j.ReplayedAttempts = j.Attempts
+}
+
+// lockDeadJobs locks and returns the jobs that Replay and Discard act on: the dead jobs
+// of queueName, limited to ids unless ids is empty.
+func lockDeadJobs(tx *sql.Tx, queueName string, ids []int) ([]*Job, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE queue_name = $1 AND status = $2 AND ($3::int[] IS NULL OR id = ANY($3))
+		ORDER BY id
+		FOR UPDATE
+	`
+	rows, err := tx.Query(query, queueName, JobDead, jobIDs(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// jobIDs turns a list of job IDs into a query argument that is NULL when the list is
+// empty, so that the query matches every job.
+func jobIDs(ids []int) interface{} {
+	if len(ids) == 0 {
+		return nil
+	}
+	return pq.Array(ids)
+}
@@ -0,0 +1,240 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+
+	"github.com/holonet/core/database"
+	_ "github.com/holonet/core/database/tables"
+)
+
+func TestJobIDs(t *testing.T) {
+	if arg := jobIDs(nil); arg != nil {
+		t.Errorf("Expected nil for no IDs, got %v", arg)
+	}
+	if arg := jobIDs([]int{}); arg != nil {
+		t.Errorf("Expected nil for an empty list, got %v", arg)
+	}
+
+	arg, ok := jobIDs([]int{4, 2}).(pq.GenericArray)
+	if !ok {
+		t.Fatalf("Expected a pq array, got %T", jobIDs([]int{4, 2}))
+	}
+	value, err := arg.Value()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if value != "{4,2}" {
+		t.Errorf("Expected {4,2}, got %v", value)
+	}
+}
+
+func TestJobFail(t *testing.T) {
+	job := &Job{ID: 1, Status: JobRunning, Attempts: 1, MaxAttempts: 3}
+	if delay := job.fail("timeout"); delay != RetryDelay(1) {
+		t.Errorf("Expected a retry delay of %s, got %s", RetryDelay(1), delay)
+	}
+	if job.Status != JobPending {
+		t.Errorf("Expected a job with attempts left to be pending, got %s", job.Status)
+	}
+
+	job.Status = JobRunning
+	job.Attempts = 3
+	if delay := job.fail("refused"); delay != 0 {
+		t.Errorf("Expected no retry delay for a dead job, got %s", delay)
+	}
+	if job.Status != JobDead {
+		t.Errorf("Expected the job to be dead after %d attempts, got %s", job.MaxAttempts, job.Status)
+	}
+	if job.Attempts != 3 {
+		t.Errorf("Expected the attempt count to be kept, got %d", job.Attempts)
+	}
+	if job.LastError != "refused" {
+		t.Errorf("Expected last error refused, got %q", job.LastError)
+	}
+}
+
+func TestJobReplay(t *testing.T) {
+	job := &Job{ID: 1, Status: JobRunning, Attempts: 2, MaxAttempts: 2}
+	job.fail("refused")
+	job.replay()
+
+	if job.Status != JobPending {
+		t.Errorf("Expected a replayed job to be pending, got %s", job.Status)
+	}
+	if job.Attempts != 2 || job.Replays != 1 || job.ReplayedAttempts != 2 {
+		t.Errorf("Expected 2 attempts with 1 replay after attempt 2, got %d attempts with %d replays after attempt %d",
+			job.Attempts, job.Replays, job.ReplayedAttempts)
+	}
+	if job.LastError != "refused" {
+		t.Errorf("Expected the last error to be kept, got %q", job.LastError)
+	}
+
+	job.Status = JobRunning
+	job.Attempts = 3
+	if job.fail("refused"); job.Status != JobPending {
+		t.Errorf("Expected the first attempt after a replay to be retried, got %s", job.Status)
+	}
+	job.Status = JobRunning
+	job.Attempts = 4
+	if job.fail("refused"); job.Status != JobDead {
+		t.Errorf("Expected the job to be dead after %d attempts since its replay, got %s", job.MaxAttempts, job.Status)
+	}
+}
+
+// testQueue returns a queue on the database of TEST_DATABASE_URL with empty job tables.
+// Tests that need one are skipped without it.
+func testQueue(t *testing.T) *Queue {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := (&database.DBHandler{DB: db}).MigrateTables(); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	if _, err := db.Exec(`TRUNCATE jobs, job_attempts RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatalf("Failed to empty job tables: %v", err)
+	}
+	return New(db)
+}
+
+// runAndFail claims the job, which must be the only due job of its queue, and fails it.
+// It makes a job that is retried due right away.
+func runAndFail(t *testing.T, q *Queue, job *Job) {
+	t.Helper()
+	claimed, err := q.Claim([]string{job.QueueName}, 1)
+	if err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != job.ID {
+		t.Fatalf("Expected to claim job %d, got %d job(s)", job.ID, len(claimed))
+	}
+	if err := q.Fail(claimed[0], errors.New("refused")); err != nil {
+		t.Fatalf("Failed to fail job: %v", err)
+	}
+	if _, err := q.db.Exec(`UPDATE jobs SET available_at = NOW() WHERE id = $1`, job.ID); err != nil {
+		t.Fatalf("Failed to make job due: %v", err)
+	}
+}
+
+func getJob(t *testing.T, q *Queue, id int) *Job {
+	t.Helper()
+	job, err := q.GetJob(id)
+	if err != nil {
+		t.Fatalf("Failed to get job %d: %v", id, err)
+	}
+	return job
+}
+
+func TestReplayKeepsHistory(t *testing.T) {
+	q := testQueue(t)
+	job, err := q.Enqueue("netbox", map[string]int{"device": 5}, JobOptions{MaxAttempts: 2})
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	runAndFail(t, q, job)
+	runAndFail(t, q, job)
+	dead := getJob(t, q, job.ID)
+	if dead.Status != JobDead || dead.Attempts != 2 || dead.LastError != "refused" {
+		t.Fatalf("Expected a dead job after 2 attempts with its last error, got %s after %d: %q",
+			dead.Status, dead.Attempts, dead.LastError)
+	}
+
+	replayed, err := q.Replay("netbox", nil)
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if len(replayed) != 1 || replayed[0] != job.ID {
+		t.Fatalf("Expected job %d to be replayed, got %v", job.ID, replayed)
+	}
+	pending := getJob(t, q, job.ID)
+	if pending.Status != JobPending || pending.LastError != "refused" || len(pending.History) != 2 {
+		t.Errorf("Expected a pending job with its last error and 2 attempts of history, got %s, %q, %d",
+			pending.Status, pending.LastError, len(pending.History))
+	}
+
+	runAndFail(t, q, job)
+	if retried := getJob(t, q, job.ID); retried.Status != JobPending {
+		t.Errorf("Expected a replayed job to get %d attempts, got %s after 1", job.MaxAttempts, retried.Status)
+	}
+	runAndFail(t, q, job)
+
+	final := getJob(t, q, job.ID)
+	if final.Status != JobDead {
+		t.Errorf("Expected the job to be dead again, got %s", final.Status)
+	}
+	history := []string{}
+	for _, attempt := range final.History {
+		history = append(history, fmt.Sprintf("%d/%d", attempt.Attempt, attempt.Replay))
+	}
+	if got := strings.Join(history, " "); got != "1/0 2/0 3/1 4/1" {
+		t.Errorf("Expected attempts 1/0 2/0 3/1 4/1 (attempt/replay), got %s", got)
+	}
+}
+
+func TestReplayAndDiscardOnlyDeadJobsOfQueue(t *testing.T) {
+	q := testQueue(t)
+	enqueue := func(queueName string) *Job {
+		job, err := q.Enqueue(queueName, map[string]int{}, JobOptions{})
+		if err != nil {
+			t.Fatalf("Failed to enqueue job: %v", err)
+		}
+		return job
+	}
+
+	dead := enqueue("netbox")
+	runAndFail(t, q, dead)
+	otherQueue := enqueue("mail")
+	runAndFail(t, q, otherQueue)
+	running := enqueue("netbox")
+	if claimed, err := q.Claim([]string{"netbox"}, 1); err != nil || len(claimed) != 1 {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	pending := enqueue("netbox")
+	ids := []int{dead.ID, otherQueue.ID, running.ID, pending.ID}
+
+	discarded, err := q.Discard("netbox", ids[1:])
+	if err != nil {
+		t.Fatalf("Failed to discard: %v", err)
+	}
+	if len(discarded) != 0 {
+		t.Errorf("Expected no jobs to be discarded, got %v", discarded)
+	}
+
+	replayed, err := q.Replay("netbox", ids)
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if len(replayed) != 1 || replayed[0] != dead.ID {
+		t.Errorf("Expected only job %d to be replayed, got %v", dead.ID, replayed)
+	}
+	for id, status := range map[int]JobStatus{otherQueue.ID: JobDead, running.ID: JobRunning, pending.ID: JobPending} {
+		if job := getJob(t, q, id); job.Status != status {
+			t.Errorf("Expected job %d to stay %s, got %s", id, status, job.Status)
+		}
+	}
+
+	discarded, err = q.Discard("mail", nil)
+	if err != nil {
+		t.Fatalf("Failed to discard: %v", err)
+	}
+	if len(discarded) != 1 || discarded[0] != otherQueue.ID {
+		t.Errorf("Expected job %d to be discarded, got %v", otherQueue.ID, discarded)
+	}
+	if _, err := q.GetJob(otherQueue.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected a discarded job to be gone, got %v", err)
+	}
+}
@@ -1,20 +1,145 @@
 package queue
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/holonet/core/logger"
 )
 
+type NetboxRequestStatus string
+
+const (
+	NetboxRequestQueued    NetboxRequestStatus = "queued"
+	NetboxRequestRunning   NetboxRequestStatus = "running"
+	NetboxRequestCompleted NetboxRequestStatus = "completed"
+	NetboxRequestFailed    NetboxRequestStatus = "failed"
+)
+
+// NetboxPriority is the class of a queued NetBox request. Due requests of a higher class
+// are always sent first; within a class, callers take turns.
+type NetboxPriority string
+
+const (
+	NetboxPriorityInteractive NetboxPriority = "interactive"
+	NetboxPriorityNormal      NetboxPriority = "normal"
+	NetboxPriorityBulk        NetboxPriority = "bulk"
+)
+
+// netboxPriorityRanks orders the priority classes in the priority column.
+var netboxPriorityRanks = map[NetboxPriority]int{
+	NetboxPriorityBulk:        0,
+	NetboxPriorityNormal:      1,
+	NetboxPriorityInteractive: 2,
+}
+
+// ValidateNetboxPriority checks a priority class. The empty class stands for normal.
+func ValidateNetboxPriority(priority NetboxPriority) error {
+	if _, ok := netboxPriorityRanks[priority]; priority != "" && !ok {
+		return fmt.Errorf("invalid NetBox priority %q (use interactive, normal or bulk)", priority)
+	}
+	return nil
+}
+
+func netboxPriorityOfRank(rank int) NetboxPriority {
+	for priority, r := range netboxPriorityRanks {
+		if r == rank {
+			return priority
+		}
+	}
+	return NetboxPriorityNormal
+}
+
+// defaultNetboxCaller is the caller of requests that holonet sends on its own behalf.
+const defaultNetboxCaller = "holonet"
+
+// NetboxRequestOptions say on whose behalf a request is queued. Caller names the user or
+// workflow, such as "workflow:12"; callers of the same priority class take turns.
+type NetboxRequestOptions struct {
+	Priority NetboxPriority
+	Caller   string
+	// IdempotencyKey makes queueing the same request again return the request that was
+	// queued first, as long as it is kept, instead of sending it twice.
+	IdempotencyKey string
+}
+
+func (o *NetboxRequestOptions) applyDefaults() {
+	if o.Priority == "" {
+		o.Priority = NetboxPriorityNormal
+	}
+	if o.Caller == "" {
+		o.Caller = defaultNetboxCaller
+	}
+}
+
+const (
+	netboxMaxAttempts = 5
+	// netboxPollInterval bounds how long the queue sleeps, so that it also picks up
+	// requests submitted to other instances.
+	netboxPollInterval = 5 * time.Second
+	// netboxRequestLease is how long a request may run before it is considered lost with
+	// its instance. It is well above the timeout of the NetBox client.
+	netboxRequestLease = 2 * time.Minute
+	// netboxRequestRetention is how long finished requests can still be polled.
+	netboxRequestRetention = 24 * time.Hour
+	netboxPurgeInterval    = time.Hour
+	// netboxWaitInterval is how often QueueRequest checks for requests that another
+	// instance processed.
+	netboxWaitInterval = 5 * time.Second
+)
+
+var (
+	ErrNetboxRequestNotFound = errors.New("netbox request not found")
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was used for another request")
+)
+
+// NetboxQueuedRequest is a row of the netbox_requests table. Attempts counts the times
+// the request was sent to NetBox, including the one that is running.
 type NetboxQueuedRequest struct {
-	Method      string
-	Endpoint    string
-	Body        interface{}
-	RetryAt     time.Time
-	Attempts    int
-	MaxAttempts int
-	Result      chan NetboxQueueResult
+	ID          int                 `json:"id"`
+	Method      string              `json:"method"`
+	Endpoint    string              `json:"endpoint"`
+	Body        json.RawMessage     `json:"body,omitempty"`
+	Priority    NetboxPriority      `json:"priority"`
+	Caller      string              `json:"caller"`
+	Status      NetboxRequestStatus `json:"status"`
+	RetryAt     time.Time           `json:"retry_at"`
+	Attempts    int                 `json:"attempts"`
+	MaxAttempts int                 `json:"max_attempts"`
+	// Response is the body NetBox answered a completed request with.
+	Response    []byte     `json:"-"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// matches reports whether the request is for method and endpoint with the JSON body.
+// Bodies are compared by value, since the stored body is reformatted by the database.
+func (r *NetboxQueuedRequest) matches(method, endpoint string, body []byte) bool {
+	if r.Method != method || r.Endpoint != endpoint {
+		return false
+	}
+	if len(r.Body) == 0 || len(body) == 0 {
+		return len(r.Body) == len(body)
+	}
+	var stored, requested interface{}
+	if json.Unmarshal(r.Body, &stored) != nil || json.Unmarshal(body, &requested) != nil {
+		return false
+	}
+	return reflect.DeepEqual(stored, requested)
+}
+
+// Finished reports whether the request completed or failed for good.
+func (r *NetboxQueuedRequest) Finished() bool {
+	return r.Status == NetboxRequestCompleted || r.Status == NetboxRequestFailed
 }
 
 type NetboxQueueResult struct {
@@ -24,20 +149,48 @@ type NetboxQueueResult struct {
 
 type NetboxRequestExecutor interface {
 	ExecuteRequest(method, endpoint string, body interface{}) ([]byte, error)
+	// ReserveRequest takes a request from the rate limit budget. When the budget is used
+	// up, it returns how long to wait before trying again.
+	ReserveRequest() time.Duration
+	// ReleaseRequest gives back a reserved request that was not sent.
+	ReleaseRequest()
 }
 
+// NetboxQueue sends NetBox requests that could not be sent right away. Requests are kept
+// in the netbox_requests table, so they survive restarts and are picked up by any
+// instance.
 type NetboxQueue struct {
-	executor     NetboxRequestExecutor
-	queue        []*NetboxQueuedRequest
-	queueMutex   sync.Mutex
-	queueRunning bool
+	executor NetboxRequestExecutor
+	db       *sql.DB
+	workerID string
+
+	// waiters holds the channels of the QueueRequest calls of this instance by request ID.
+	waiters      map[int]chan NetboxQueueResult
+	waitersMutex sync.Mutex
+
+	// busy is set while due requests are waiting, so that new requests queue behind them.
+	busy atomic.Bool
+	// wake makes the queue claim again as soon as a request is queued.
+	wake chan struct{}
 }
 
-func NewNetboxQueue(executor NetboxRequestExecutor) *NetboxQueue {
+func NewNetboxQueue(executor NetboxRequestExecutor, db *sql.DB) *NetboxQueue {
 	nq := &NetboxQueue{
-		executor:     executor,
-		queue:        make([]*NetboxQueuedRequest, 0),
-		queueRunning: false,
+		executor: executor,
+		db:       db,
+		workerID: newWorkerID(),
+		waiters:  make(map[int]chan NetboxQueueResult),
+		wake:     make(chan struct{}, 1),
+	}
+
+	var pending int
+	err := db.QueryRow(`SELECT COUNT(*) FROM netbox_requests WHERE status IN ($1, $2)`,
+		NetboxRequestQueued, NetboxRequestRunning).Scan(&pending)
+	if err != nil {
+		logger.Error("Failed to count queued NetBox requests: %v", err)
+	} else if pending > 0 {
+		logger.Info("Replaying %d queued NetBox request(s)", pending)
+		nq.busy.Store(true)
 	}
 
 	go nq.processQueue()
@@ -45,91 +198,510 @@ func NewNetboxQueue(executor NetboxRequestExecutor) *NetboxQueue {
 	return nq
 }
 
-func (nq *NetboxQueue) QueueRequest(method, endpoint string, body interface{}) ([]byte, error) {
+// Busy reports whether requests are waiting to be sent. New requests should then be
+// queued, even when the rate limit would allow them, so that they don't jump the queue.
+func (nq *NetboxQueue) Busy() bool {
+	return nq.busy.Load()
+}
+
+// QueueRequest queues a request and blocks until it was sent.
+func (nq *NetboxQueue) QueueRequest(options NetboxRequestOptions, method, endpoint string, body interface{}) ([]byte, error) {
 	logger.Debug("Queueing NetBox request due to rate limiting: %s %s", method, endpoint)
 
-	resultChan := make(chan NetboxQueueResult, 1)
+	request, err := nq.enqueue(options, method, endpoint, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	return nq.wait(request)
+}
+
+// RetryRequest queues a request that failed with a retriable error when it was sent
+// directly. That attempt is counted and the request is sent again after a backoff; it
+// blocks until the request was sent.
+func (nq *NetboxQueue) RetryRequest(options NetboxRequestOptions, method, endpoint string, body interface{}, sendErr error) ([]byte, error) {
+	logger.Debug("Queueing NetBox request for retry: %s %s: %v", method, endpoint, sendErr)
+
+	request, err := nq.enqueue(options, method, endpoint, body, sendErr)
+	if err != nil {
+		return nil, err
+	}
+	return nq.wait(request)
+}
 
-	request := &NetboxQueuedRequest{
-		Method:      method,
-		Endpoint:    endpoint,
-		Body:        body,
-		RetryAt:     time.Now().Add(5 * time.Second),
-		Attempts:    0,
-		MaxAttempts: 5,
-		Result:      resultChan,
+// SubmitRequest queues a request and returns right away. The result can be polled with
+// GetRequest using the ID of the returned request.
+func (nq *NetboxQueue) SubmitRequest(options NetboxRequestOptions, method, endpoint string, body interface{}) (*NetboxQueuedRequest, error) {
+	request, err := nq.enqueue(options, method, endpoint, body, nil)
+	if err != nil {
+		return nil, err
 	}
+	logger.Debug("Submitted NetBox request %d: %s %s", request.ID, method, endpoint)
+	return request, nil
+}
 
-	nq.queueMutex.Lock()
-	nq.queue = append(nq.queue, request)
-	nq.queueMutex.Unlock()
+const netboxRequestColumns = `id, method, endpoint, body, priority, caller, status, retry_at, attempts, max_attempts,
+	response, error, created_at, updated_at, completed_at`
 
-	result := <-resultChan
-	close(resultChan)
+// enqueue adds a request to the queue, or returns the request queued earlier with the
+// same idempotency key. A request whose first attempt failed with sendErr is due after
+// the backoff of that attempt.
+func (nq *NetboxQueue) enqueue(options NetboxRequestOptions, method, endpoint string, body interface{}, sendErr error) (*NetboxQueuedRequest, error) {
+	options.applyDefaults()
+	if err := ValidateNetboxPriority(options.Priority); err != nil {
+		return nil, err
+	}
 
-	return result.Data, result.Error
+	// A request without body keeps a NULL body, so that it is sent without one again.
+	var data interface{}
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		data = encoded
+	}
+
+	attempts := 0
+	var delay time.Duration
+	var lastError sql.NullString
+	if sendErr != nil {
+		attempts = 1
+		delay = NetboxRetryDelay(attempts, sendErr)
+		lastError = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+
+	query := `
+		INSERT INTO netbox_requests (method, endpoint, body, priority, caller, idempotency_key, status, retry_at,
+		                             attempts, max_attempts, error, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW() + $8 * INTERVAL '1 millisecond', $9, $10, $11, NOW(), NOW())
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING ` + netboxRequestColumns
+
+	request, err := scanNetboxRequest(nq.db.QueryRow(query, method, endpoint, data, netboxPriorityRanks[options.Priority],
+		options.Caller, nullString(options.IdempotencyKey), NetboxRequestQueued, delay.Milliseconds(), attempts,
+		netboxMaxAttempts, lastError))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nq.requestByIdempotencyKey(options.IdempotencyKey, method, endpoint, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue NetBox request: %w", err)
+	}
+
+	nq.busy.Store(true)
+	nq.notify()
+	return request, nil
 }
 
-func (nq *NetboxQueue) processQueue() {
-	nq.queueMutex.Lock()
-	nq.queueRunning = true
-	nq.queueMutex.Unlock()
+func (nq *NetboxQueue) notify() {
+	select {
+	case nq.wake <- struct{}{}:
+	default:
+	}
+}
+
+// requestByIdempotencyKey returns the request queued with an idempotency key, which must
+// be the same request: the same method, endpoint and body.
+func (nq *NetboxQueue) requestByIdempotencyKey(key, method, endpoint string, body interface{}) (*NetboxQueuedRequest, error) {
+	query := `SELECT ` + netboxRequestColumns + ` FROM netbox_requests WHERE idempotency_key = $1`
+	request, err := scanNetboxRequest(nq.db.QueryRow(query, key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get NetBox request by idempotency key: %w", err)
+	}
+	encoded, _ := body.([]byte)
+	if !request.matches(method, endpoint, encoded) {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, key)
+	}
 
-	ticker := time.NewTicker(1 * time.Second)
+	logger.Debug("NetBox request %s %s was already queued as request %d", method, endpoint, request.ID)
+	return request, nil
+}
+
+// GetRequest returns a queued request with its outcome once it is finished.
+func (nq *NetboxQueue) GetRequest(id int) (*NetboxQueuedRequest, error) {
+	request, err := scanNetboxRequest(nq.db.QueryRow(`SELECT `+netboxRequestColumns+` FROM netbox_requests WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrNetboxRequestNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get NetBox request: %w", err)
+	}
+	return request, nil
+}
+
+// wait blocks until a request is finished. Requests sent by this instance are reported
+// right away; the others are found by polling.
+func (nq *NetboxQueue) wait(request *NetboxQueuedRequest) ([]byte, error) {
+	resultChan := make(chan NetboxQueueResult, 1)
+	nq.waitersMutex.Lock()
+	nq.waiters[request.ID] = resultChan
+	nq.waitersMutex.Unlock()
+
+	defer func() {
+		nq.waitersMutex.Lock()
+		delete(nq.waiters, request.ID)
+		nq.waitersMutex.Unlock()
+	}()
+
+	// A request queued earlier with the same idempotency key may have finished already.
+	current := request
+	ticker := time.NewTicker(netboxWaitInterval)
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
-
-		nq.queueMutex.Lock()
-		if len(nq.queue) == 0 {
-			nq.queueMutex.Unlock()
-			continue
+		if current.Finished() {
+			if current.Status == NetboxRequestFailed {
+				return nil, errors.New(current.Error)
+			}
+			return current.Response, nil
 		}
 
-		now := time.Now()
-		var nextRequest *NetboxQueuedRequest
-		var index int
+		select {
+		case result := <-resultChan:
+			return result.Data, result.Error
+		case <-ticker.C:
+			var err error
+			if current, err = nq.GetRequest(request.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
 
-		for i, req := range nq.queue {
-			if now.After(req.RetryAt) {
-				nextRequest = req
-				index = i
-				break
+// processQueue sends queued requests one after another, as fast as the rate limit
+// budget allows, and sleeps while no request is due.
+func (nq *NetboxQueue) processQueue() {
+	var lastPurge, lastRecovery time.Time
+	for {
+		if time.Since(lastPurge) >= netboxPurgeInterval {
+			nq.purgeFinished()
+			lastPurge = time.Now()
+		}
+		if time.Since(lastRecovery) >= netboxPollInterval {
+			if err := nq.recoverExpired(); err != nil {
+				logger.Error("Failed to recover expired NetBox requests: %v", err)
 			}
+			lastRecovery = time.Now()
+		}
+
+		if wait := nq.untilNextDue(); wait > 0 {
+			nq.busy.Store(false)
+			nq.sleep(wait)
+			continue
+		}
+		nq.busy.Store(true)
+
+		// The budget is reserved before a request is claimed, so that a claimed request is
+		// sent right away instead of outliving its lease while it waits. It is given back
+		// when no request is claimed.
+		for wait := nq.executor.ReserveRequest(); wait > 0; wait = nq.executor.ReserveRequest() {
+			logger.Debug("NetBox rate limit reached, waiting %v to send queued requests", wait.Round(time.Second))
+			time.Sleep(wait)
 		}
 
+		nextRequest, err := nq.claimNext()
+		if err != nil {
+			nq.executor.ReleaseRequest()
+			logger.Error("Failed to claim queued NetBox request: %v", err)
+			nq.sleep(netboxPollInterval)
+			continue
+		}
 		if nextRequest == nil {
-			nq.queueMutex.Unlock()
+			// Another instance claimed the due request first.
+			nq.executor.ReleaseRequest()
 			continue
 		}
 
-		nq.queue = append(nq.queue[:index], nq.queue[index+1:]...)
-		nq.queueMutex.Unlock()
+		var body interface{}
+		if nextRequest.Body != nil {
+			body = nextRequest.Body
+		}
+		data, err := nq.executor.ExecuteRequest(nextRequest.Method, nextRequest.Endpoint, body)
+		nq.finish(nextRequest, data, err)
+	}
+}
+
+// sleep waits for d or until a request is queued on this instance.
+func (nq *NetboxQueue) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-nq.wake:
+	}
+}
+
+// untilNextDue returns how long until the next queued request is due, at most
+// netboxPollInterval. It returns 0 when a request is due.
+func (nq *NetboxQueue) untilNextDue() time.Duration {
+	var wait sql.NullFloat64
+	query := `SELECT EXTRACT(EPOCH FROM MIN(retry_at) - NOW()) FROM netbox_requests WHERE status = $1`
+	if err := nq.db.QueryRow(query, NetboxRequestQueued).Scan(&wait); err != nil {
+		logger.Error("Failed to get next queued NetBox request: %v", err)
+		return netboxPollInterval
+	}
+	if !wait.Valid {
+		return netboxPollInterval
+	}
+	return min(max(time.Duration(wait.Float64*float64(time.Second)), 0), netboxPollInterval)
+}
 
-		data, err := nq.executor.ExecuteRequest(nextRequest.Method, nextRequest.Endpoint, nextRequest.Body)
+// claimNext locks the next due request for this instance: one of the highest priority
+// class, from the caller that was served least recently. It returns nil if no request is
+// due.
+func (nq *NetboxQueue) claimNext() (*NetboxQueuedRequest, error) {
+	query := `
+		UPDATE netbox_requests
+		SET status = $1, locked_by = $2, locked_at = NOW(), sent_at = NOW(), attempts = attempts + 1,
+		    updated_at = NOW()
+		WHERE id = (
+			SELECT r.id FROM netbox_requests r
+			WHERE r.status = $3 AND r.retry_at <= NOW()
+			ORDER BY r.priority DESC,
+			         (SELECT MAX(s.sent_at) FROM netbox_requests s WHERE s.caller = r.caller) NULLS FIRST,
+			         r.retry_at, r.id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + netboxRequestColumns
 
-		if err != nil && nextRequest.Attempts < nextRequest.MaxAttempts {
-			nextRequest.Attempts++
-			nextRequest.RetryAt = time.Now().Add(time.Duration(nextRequest.Attempts) * 5 * time.Second)
+	request, err := scanNetboxRequest(nq.db.QueryRow(query, NetboxRequestRunning, nq.workerID, NetboxRequestQueued))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return request, err
+}
 
-			nq.queueMutex.Lock()
-			nq.queue = append(nq.queue, nextRequest)
-			nq.queueMutex.Unlock()
+// expiredOutcome decides what becomes of a request whose instance was lost while it was
+// sent. NetBox may have applied it, so it is only queued again when sending it twice is
+// safe and it has attempts left; otherwise it fails with an unknown outcome.
+func expiredOutcome(request *NetboxQueuedRequest) (NetboxRequestStatus, string) {
+	if request.Method == http.MethodPost {
+		return NetboxRequestFailed, "outcome unknown: the instance sending the request was lost before NetBox answered"
+	}
+	if request.Attempts >= request.MaxAttempts {
+		return NetboxRequestFailed, fmt.Sprintf("the instance sending the request was lost, after %d attempts", request.Attempts)
+	}
+	return NetboxRequestQueued, "the instance sending the request was lost"
+}
 
-			logger.Debug("Requeued NetBox request for retry (attempt %d/%d): %s %s",
-				nextRequest.Attempts, nextRequest.MaxAttempts, nextRequest.Method, nextRequest.Endpoint)
-		} else {
-			nextRequest.Result <- NetboxQueueResult{
-				Data:  data,
-				Error: err,
-			}
+// recoverExpired settles the requests that ran longer than the lease, whose instance was
+// lost while sending them.
+func (nq *NetboxQueue) recoverExpired() error {
+	tx, err := nq.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-			if err != nil {
-				logger.Error("Failed NetBox queued request after %d attempts: %v", nextRequest.Attempts, err)
-			} else {
-				logger.Debug("Successfully processed queued NetBox request: %s %s", nextRequest.Method, nextRequest.Endpoint)
-			}
+	query := `
+		SELECT ` + netboxRequestColumns + `
+		FROM netbox_requests
+		WHERE status = $1 AND locked_at < NOW() - $2 * INTERVAL '1 second'
+		FOR UPDATE SKIP LOCKED
+	`
+	rows, err := tx.Query(query, NetboxRequestRunning, int(netboxRequestLease.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to get expired NetBox requests: %w", err)
+	}
+	expired := []*NetboxQueuedRequest{}
+	for rows.Next() {
+		request, err := scanNetboxRequest(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan expired NetBox request: %w", err)
+		}
+		expired = append(expired, request)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating expired NetBox requests: %w", err)
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	updateQuery := `
+		UPDATE netbox_requests
+		SET status = $1, error = $2, retry_at = NOW() + $3 * INTERVAL '1 millisecond',
+		    completed_at = CASE WHEN $4 THEN NOW() END, locked_by = NULL, locked_at = NULL, updated_at = NOW()
+		WHERE id = $5
+	`
+	for _, request := range expired {
+		status, message := expiredOutcome(request)
+		delay := NetboxRetryDelay(request.Attempts, nil)
+		if _, err := tx.Exec(updateQuery, status, message, delay.Milliseconds(), status == NetboxRequestFailed, request.ID); err != nil {
+			return fmt.Errorf("failed to recover NetBox request %d: %w", request.ID, err)
+		}
+		logger.Warn("NetBox request %d (%s %s) was lost with its instance: %s", request.ID, request.Method, request.Endpoint, status)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit recovered NetBox requests: %w", err)
+	}
+	return nil
+}
+
+// finish records the outcome of a sent request. Failed requests are queued again while
+// they have attempts left.
+func (nq *NetboxQueue) finish(request *NetboxQueuedRequest, data []byte, err error) {
+	if err != nil && IsRetriable(err) && request.Attempts < request.MaxAttempts {
+		query := `
+			UPDATE netbox_requests
+			SET status = $1, retry_at = NOW() + $2 * INTERVAL '1 millisecond', error = $3,
+			    locked_by = NULL, locked_at = NULL, updated_at = NOW()
+			WHERE id = $4 AND locked_by = $5
+		`
+		delay := NetboxRetryDelay(request.Attempts, err)
+		if _, dbErr := nq.db.Exec(query, NetboxRequestQueued, delay.Milliseconds(), err.Error(), request.ID, nq.workerID); dbErr != nil {
+			logger.Error("Failed to requeue NetBox request %d: %v", request.ID, dbErr)
+			return
+		}
+
+		logger.Debug("Requeued NetBox request for retry in %v (attempt %d/%d): %s %s",
+			delay.Round(time.Millisecond), request.Attempts, request.MaxAttempts, request.Method, request.Endpoint)
+		return
+	}
+
+	status := NetboxRequestCompleted
+	var errMsg sql.NullString
+	if err != nil {
+		status = NetboxRequestFailed
+		errMsg = sql.NullString{String: err.Error(), Valid: true}
+		data = nil
+	}
+
+	query := `
+		UPDATE netbox_requests
+		SET status = $1, response = $2, error = $3, completed_at = NOW(), locked_by = NULL, locked_at = NULL,
+		    updated_at = NOW()
+		WHERE id = $4 AND locked_by = $5
+	`
+	if _, dbErr := nq.db.Exec(query, status, data, errMsg, request.ID, nq.workerID); dbErr != nil {
+		logger.Error("Failed to record outcome of NetBox request %d: %v", request.ID, dbErr)
+	}
+
+	nq.waitersMutex.Lock()
+	if resultChan, ok := nq.waiters[request.ID]; ok {
+		resultChan <- NetboxQueueResult{
+			Data:  data,
+			Error: err,
+		}
+	}
+	nq.waitersMutex.Unlock()
+
+	if err != nil {
+		logger.Error("Failed NetBox queued request after %d attempts: %v", request.Attempts, err)
+	} else {
+		logger.Debug("Successfully processed queued NetBox request: %s %s", request.Method, request.Endpoint)
+	}
+}
+
+// NetboxQueueDepth counts the waiting requests of the queue or of one caller. The age is
+// the time the oldest queued request has been waiting.
+type NetboxQueueDepth struct {
+	Caller           string         `json:"caller,omitempty"`
+	Priority         NetboxPriority `json:"priority,omitempty"`
+	Queued           int            `json:"queued"`
+	Running          int            `json:"running"`
+	OldestAgeSeconds int            `json:"oldest_age_seconds"`
+}
+
+// NetboxQueueStats is the depth of the whole queue and of each caller, highest priority
+// class first.
+type NetboxQueueStats struct {
+	NetboxQueueDepth
+	Callers []*NetboxQueueDepth `json:"callers"`
+}
+
+func (nq *NetboxQueue) Stats() (*NetboxQueueStats, error) {
+	query := `
+		SELECT caller, priority,
+		       COUNT(*) FILTER (WHERE status = $1),
+		       COUNT(*) FILTER (WHERE status = $2),
+		       COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at) FILTER (WHERE status = $1)), 0)::INTEGER
+		FROM netbox_requests
+		WHERE status IN ($1, $2)
+		GROUP BY caller, priority
+		ORDER BY priority DESC, caller
+	`
+	rows, err := nq.db.Query(query, NetboxRequestQueued, NetboxRequestRunning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count queued NetBox requests: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &NetboxQueueStats{Callers: []*NetboxQueueDepth{}}
+	for rows.Next() {
+		depth := &NetboxQueueDepth{}
+		var priority int
+		if err := rows.Scan(&depth.Caller, &priority, &depth.Queued, &depth.Running, &depth.OldestAgeSeconds); err != nil {
+			return nil, fmt.Errorf("failed to scan queued NetBox requests: %w", err)
 		}
+		depth.Priority = netboxPriorityOfRank(priority)
+
+		stats.Queued += depth.Queued
+		stats.Running += depth.Running
+		stats.OldestAgeSeconds = max(stats.OldestAgeSeconds, depth.OldestAgeSeconds)
+		stats.Callers = append(stats.Callers, depth)
+	}
+	return stats, rows.Err()
+}
+
+// purgeFinished deletes finished requests that are past the retention.
+func (nq *NetboxQueue) purgeFinished() {
+	query := `
+		DELETE FROM netbox_requests
+		WHERE status IN ($1, $2) AND completed_at < NOW() - $3 * INTERVAL '1 second'
+	`
+	result, err := nq.db.Exec(query, NetboxRequestCompleted, NetboxRequestFailed, int(netboxRequestRetention.Seconds()))
+	if err != nil {
+		logger.Error("Failed to purge finished NetBox requests: %v", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		logger.Debug("Purged %d finished NetBox request(s)", n)
+	}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func scanNetboxRequest(row rowScanner) (*NetboxQueuedRequest, error) {
+	request := &NetboxQueuedRequest{}
+	var body []byte
+	var priority int
+	var errMsg sql.NullString
+	var completedAt sql.NullTime
+
+	err := row.Scan(
+		&request.ID,
+		&request.Method,
+		&request.Endpoint,
+		&body,
+		&priority,
+		&request.Caller,
+		&request.Status,
+		&request.RetryAt,
+		&request.Attempts,
+		&request.MaxAttempts,
+		&request.Response,
+		&errMsg,
+		&request.CreatedAt,
+		&request.UpdatedAt,
+		&completedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		request.Body = json.RawMessage(body)
+	}
+	request.Priority = netboxPriorityOfRank(priority)
+	request.Error = errMsg.String
+	if completedAt.Valid {
+		request.CompletedAt = &completedAt.Time
 	}
+	return request, nil
 }
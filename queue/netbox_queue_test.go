@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeRow []interface{}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	for i, value := range r {
+		switch d := dest[i].(type) {
+		case *int:
+			*d = value.(int)
+		case *string:
+			*d = value.(string)
+		case *NetboxRequestStatus:
+			*d = value.(NetboxRequestStatus)
+		case *[]byte:
+			if value != nil {
+				*d = value.([]byte)
+			}
+		case *time.Time:
+			*d = value.(time.Time)
+		case *sql.NullString:
+			*d = value.(sql.NullString)
+		case *sql.NullTime:
+			*d = value.(sql.NullTime)
+		}
+	}
+	return nil
+}
+
+func TestScanNetboxRequest(t *testing.T) {
+	now := time.Now()
+	row := fakeRow{7, "DELETE", "dcim/devices/1/", nil, 0, "workflow:3", NetboxRequestCompleted, now, 1, 5, nil,
+		sql.NullString{}, now, now, sql.NullTime{Time: now, Valid: true}}
+
+	request, err := scanNetboxRequest(row)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if request.Priority != NetboxPriorityBulk {
+		t.Errorf("Expected priority bulk, got %s", request.Priority)
+	}
+	if request.Body != nil {
+		t.Errorf("Expected no body for a request without one, got %s", request.Body)
+	}
+	if request.CompletedAt == nil || !request.CompletedAt.Equal(now) {
+		t.Errorf("Expected completed_at %v, got %v", now, request.CompletedAt)
+	}
+	if !request.Finished() {
+		t.Errorf("Expected a completed request to be finished")
+	}
+
+	row[3] = []byte(`{"name":"sw1"}`)
+	row[6] = NetboxRequestQueued
+	request, err = scanNetboxRequest(row)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(request.Body) != `{"name":"sw1"}` {
+		t.Errorf("Expected body {\"name\":\"sw1\"}, got %s", request.Body)
+	}
+	if request.Finished() {
+		t.Errorf("Expected a queued request not to be finished")
+	}
+}
+
+func TestExpiredOutcome(t *testing.T) {
+	tests := []struct {
+		method   string
+		attempts int
+		expected NetboxRequestStatus
+	}{
+		{"GET", 1, NetboxRequestQueued},
+		{"PATCH", 4, NetboxRequestQueued},
+		{"DELETE", 5, NetboxRequestFailed},
+		{"POST", 1, NetboxRequestFailed},
+	}
+
+	for _, test := range tests {
+		request := &NetboxQueuedRequest{Method: test.method, Attempts: test.attempts, MaxAttempts: 5}
+		status, message := expiredOutcome(request)
+		if status != test.expected {
+			t.Errorf("Expected %s after %d attempts to be %s, got %s", test.method, test.attempts, test.expected, status)
+		}
+		if message == "" {
+			t.Errorf("Expected an error message for %s", test.method)
+		}
+	}
+
+	if _, message := expiredOutcome(&NetboxQueuedRequest{Method: "POST", Attempts: 1, MaxAttempts: 5}); !strings.HasPrefix(message, "outcome unknown") {
+		t.Errorf("Expected a lost POST to have an unknown outcome, got '%s'", message)
+	}
+}
+
+func TestNetboxPriority(t *testing.T) {
+	for _, priority := range []NetboxPriority{"", NetboxPriorityInteractive, NetboxPriorityNormal, NetboxPriorityBulk} {
+		if err := ValidateNetboxPriority(priority); err != nil {
+			t.Errorf("Expected priority %q to be valid, got %v", priority, err)
+		}
+	}
+	if err := ValidateNetboxPriority("urgent"); err == nil {
+		t.Errorf("Expected an error for an unknown priority")
+	}
+
+	for priority, rank := range netboxPriorityRanks {
+		if got := netboxPriorityOfRank(rank); got != priority {
+			t.Errorf("Expected rank %d to be %s, got %s", rank, priority, got)
+		}
+	}
+	if netboxPriorityRanks[NetboxPriorityInteractive] <= netboxPriorityRanks[NetboxPriorityNormal] ||
+		netboxPriorityRanks[NetboxPriorityNormal] <= netboxPriorityRanks[NetboxPriorityBulk] {
+		t.Errorf("Expected interactive to rank above normal and normal above bulk")
+	}
+
+	options := NetboxRequestOptions{}
+	options.applyDefaults()
+	if options.Priority != NetboxPriorityNormal || options.Caller != defaultNetboxCaller {
+		t.Errorf("Expected defaults normal/%s, got %s/%s", defaultNetboxCaller, options.Priority, options.Caller)
+	}
+}
+
+func TestNetboxRequestMatches(t *testing.T) {
+	request := &NetboxQueuedRequest{Method: "POST", Endpoint: "dcim/sites/", Body: []byte(`{"name": "a", "slug": "a"}`)}
+
+	if !request.matches("POST", "dcim/sites/", []byte(`{"slug":"a","name":"a"}`)) {
+		t.Errorf("Expected the same body in another format to match")
+	}
+	if request.matches("POST", "dcim/sites/", []byte(`{"slug":"b","name":"b"}`)) {
+		t.Errorf("Expected another body not to match")
+	}
+	if request.matches("POST", "dcim/sites/", nil) {
+		t.Errorf("Expected a request without body not to match")
+	}
+	if request.matches("PATCH", "dcim/sites/", []byte(`{"slug":"a","name":"a"}`)) {
+		t.Errorf("Expected another method not to match")
+	}
+
+	empty := &NetboxQueuedRequest{Method: "DELETE", Endpoint: "dcim/sites/1/"}
+	if !empty.matches("DELETE", "dcim/sites/1/", nil) {
+		t.Errorf("Expected requests without body to match")
+	}
+}
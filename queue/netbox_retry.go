@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	// netboxBackoffBase doubles with every failed attempt up to netboxBackoffMax.
+	netboxBackoffBase = 2 * time.Second
+	netboxBackoffMax  = 2 * time.Minute
+	// netboxMaxRetryAfter caps the wait NetBox can ask for with Retry-After.
+	netboxMaxRetryAfter = 10 * time.Minute
+)
+
+// RetriableError is implemented by the errors of a NetboxRequestExecutor. Requests that
+// fail with other errors, or with errors that are not retriable, are not sent again.
+type RetriableError interface {
+	error
+	Retriable() bool
+	// RetryDelay is how long NetBox asked to wait before the next attempt, or 0.
+	RetryDelay() time.Duration
+}
+
+// IsRetriable reports whether a request that failed with err may succeed when it is
+// sent again.
+func IsRetriable(err error) bool {
+	var retriable RetriableError
+	return errors.As(err, &retriable) && retriable.Retriable()
+}
+
+// NetboxRetryDelay returns how long to wait after the given failed attempt (numbered from
+// 1). It honours the wait NetBox asked for; otherwise it backs off exponentially with
+// jitter, so that requests that failed together are not all sent again at once.
+func NetboxRetryDelay(attempt int, err error) time.Duration {
+	var retriable RetriableError
+	if errors.As(err, &retriable) && retriable.RetryDelay() > 0 {
+		return min(retriable.RetryDelay(), netboxMaxRetryAfter)
+	}
+
+	delay := netboxBackoffBase
+	for i := 1; i < attempt && delay < netboxBackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, netboxBackoffMax)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
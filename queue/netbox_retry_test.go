@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type retriableError struct {
+	retryAfter time.Duration
+}
+
+func (e retriableError) Error() string             { return "status 429" }
+func (e retriableError) Retriable() bool           { return true }
+func (e retriableError) RetryDelay() time.Duration { return e.retryAfter }
+
+func TestNetboxRetryDelay(t *testing.T) {
+	err := retriableError{}
+	for attempt, base := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second, 4: 16 * time.Second, 20: 2 * time.Minute} {
+		for i := 0; i < 20; i++ {
+			delay := NetboxRetryDelay(attempt, err)
+			if delay < base/2 || delay > base {
+				t.Errorf("Expected a delay between %v and %v after attempt %d, got %v", base/2, base, attempt, delay)
+			}
+		}
+	}
+
+	if delay := NetboxRetryDelay(1, retriableError{retryAfter: 30 * time.Second}); delay != 30*time.Second {
+		t.Errorf("Expected the Retry-After of 30s, got %v", delay)
+	}
+	if delay := NetboxRetryDelay(1, retriableError{retryAfter: time.Hour}); delay != netboxMaxRetryAfter {
+		t.Errorf("Expected Retry-After to be capped at %v, got %v", netboxMaxRetryAfter, delay)
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	if !IsRetriable(retriableError{}) {
+		t.Errorf("Expected a retriable error to be retriable")
+	}
+	if IsRetriable(errors.New("boom")) {
+		t.Errorf("Expected a plain error not to be retriable")
+	}
+}
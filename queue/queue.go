@@ -1 +1,595 @@
 package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/holonet/core/logger"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	// JobDead jobs have used up their attempts. They stay in the dead-letter state until
+	// they are replayed or discarded.
+	JobDead JobStatus = "dead"
+)
+
+const (
+	defaultJobWorkers     = 4
+	defaultJobMaxAttempts = 1
+	// jobLease is how long a claimed job stays locked without a heartbeat before another
+	// worker may take it over.
+	jobLease          = 60 * time.Second
+	heartbeatInterval = 15 * time.Second
+	pollInterval      = 5 * time.Second
+	// retryBaseDelay doubles with every failed attempt up to retryMaxDelay.
+	retryBaseDelay = 5 * time.Second
+	retryMaxDelay  = 10 * time.Minute
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobLost     = errors.New("job is no longer locked by this worker")
+	ErrNoHandler   = errors.New("no handler registered for queue")
+)
+
+// Job is a row of the jobs table. Attempts counts the claims of the job, including the
+// one that is running, and keeps counting across replays.
+type Job struct {
+	ID          int             `json:"id"`
+	QueueName   string          `json:"queue_name"`
+	Payload     json.RawMessage `json:"payload"`
+	Description string          `json:"description"`
+	Status      JobStatus       `json:"status"`
+	Priority    int             `json:"priority"`
+	AvailableAt time.Time       `json:"available_at"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	// Replays counts how often the job was replayed from the dead-letter state, and
+	// ReplayedAttempts is its attempt count at the last replay. A replay gives the job
+	// MaxAttempts more attempts.
+	Replays          int       `json:"replays"`
+	ReplayedAttempts int       `json:"replayed_attempts"`
+	LastError        string    `json:"last_error,omitempty"`
+	LockedBy         string    `json:"locked_by,omitempty"`
+	LockedAt         time.Time `json:"locked_at"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	// History lists the attempts of the job, oldest first. Only GetJob fills it.
+	History []*JobAttempt `json:"history,omitempty"`
+}
+
+// DecodePayload unmarshals the payload of the job into v.
+func (j *Job) DecodePayload(v interface{}) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return fmt.Errorf("failed to decode payload of job %d: %w", j.ID, err)
+	}
+	return nil
+}
+
+// JobOptions are the optional settings of an enqueued job. Jobs with a higher Priority
+// are claimed first; a zero MaxAttempts runs the job once.
+type JobOptions struct {
+	Description string
+	Priority    int
+	MaxAttempts int
+	// Delay postpones the first attempt.
+	Delay time.Duration
+}
+
+// HandlerFunc processes a job. Returning an error fails the attempt, which is retried
+// until the job's attempts are used up. ctx is cancelled when the worker loses the job.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// Queue is a job queue on the jobs table. Any number of holonet instances can work the
+// same queues, since jobs are claimed with row locks.
+type Queue struct {
+	db       *sql.DB
+	workerID string
+
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+	// registered is closed when the first handler is registered.
+	registered chan struct{}
+
+	// maxWorkers bounds the jobs this instance runs at once; active counts them.
+	maxWorkers int
+	active     atomic.Int32
+	// wake makes the worker loop claim again as soon as a job is enqueued or finished.
+	wake chan struct{}
+}
+
+func New(db *sql.DB) *Queue {
+	return &Queue{
+		db:         db,
+		workerID:   newWorkerID(),
+		handlers:   map[string]HandlerFunc{},
+		registered: make(chan struct{}),
+		maxWorkers: workerPoolSize(),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Register makes the workers of this instance process the jobs of queueName with handler.
+func (q *Queue) Register(queueName string, handler HandlerFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.handlers) == 0 {
+		close(q.registered)
+	}
+	q.handlers[queueName] = handler
+}
+
+func (q *Queue) handler(queueName string) (HandlerFunc, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	handler, ok := q.handlers[queueName]
+	return handler, ok
+}
+
+func (q *Queue) queueNames() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	names := make([]string, 0, len(q.handlers))
+	for name := range q.handlers {
+		names = append(names, name)
+	}
+	return names
+}
+
+const jobColumns = `id, queue_name, payload, description, status, priority, available_at, attempts, max_attempts,
+	replays, replayed_attempts, last_error, locked_by, locked_at, created_at, updated_at`
+
+// Enqueue adds a job with a JSON-encodable payload to a queue.
+func (q *Queue) Enqueue(queueName string, payload interface{}, options JobOptions) (*Job, error) {
+	if queueName == "" {
+		return nil, errors.New("queue name is required")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	maxAttempts := options.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultJobMaxAttempts
+	}
+
+	query := `
+		INSERT INTO jobs (queue_name, payload, description, status, priority, available_at, attempts, max_attempts,
+		                  created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW() + $6 * INTERVAL '1 millisecond', 0, $7, NOW(), NOW())
+		RETURNING ` + jobColumns
+
+	job, err := scanJob(q.db.QueryRow(query, queueName, data, options.Description, JobPending, options.Priority,
+		options.Delay.Milliseconds(), maxAttempts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	logger.Debug("Enqueued job %d on queue %s", job.ID, queueName)
+	q.notify()
+	return job, nil
+}
+
+// GetJob returns a job with its attempt history.
+func (q *Queue) GetJob(id int) (*Job, error) {
+	job, err := scanJob(q.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrJobNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if job.History, err = q.jobAttempts(id); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Claim locks up to limit due jobs of the given queues for this worker, highest priority
+// first, and records the attempt. Jobs locked by other transactions are skipped, so
+// concurrent claims never return the same job.
+func (q *Queue) Claim(queueNames []string, limit int) ([]*Job, error) {
+	if len(queueNames) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	query := `
+		WITH claimed AS (
+			UPDATE jobs
+			SET status = $1, locked_by = $2, locked_at = NOW(), attempts = attempts + 1, updated_at = NOW()
+			WHERE id IN (
+				SELECT id FROM jobs
+				WHERE status = $3 AND queue_name = ANY($4) AND available_at <= NOW()
+				ORDER BY priority DESC, available_at, id
+				LIMIT $5
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING ` + jobColumns + `
+		), recorded AS (
+			INSERT INTO job_attempts (job_id, attempt, replay, worker_id, status, started_at)
+			SELECT id, attempts, replays, locked_by, $6, NOW() FROM claimed
+		)
+		SELECT ` + jobColumns + ` FROM claimed
+		ORDER BY priority DESC, available_at, id
+	`
+
+	rows, err := q.db.Query(query, JobRunning, q.workerID, JobPending, pq.Array(queueNames), limit, AttemptRunning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Heartbeat extends the lock of a running job. It returns ErrJobLost if the job was taken
+// over by another worker after its lock expired.
+func (q *Queue) Heartbeat(job *Job) error {
+	query := `UPDATE jobs SET locked_at = NOW() WHERE id = $1 AND status = $2 AND locked_by = $3`
+	return updateLocked(q.db, job, query, job.ID, JobRunning, q.workerID)
+}
+
+// Complete marks a running job of this worker as completed.
+func (q *Queue) Complete(job *Job) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE jobs
+		SET status = $1, last_error = NULL, locked_by = NULL, locked_at = NULL, updated_at = NOW()
+		WHERE id = $2 AND status = $3 AND locked_by = $4
+	`
+	if err := updateLocked(tx, job, query, JobCompleted, job.ID, JobRunning, q.workerID); err != nil {
+		return err
+	}
+	if err := finishAttempt(tx, job.ID, AttemptCompleted, ""); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	job.Status = JobCompleted
+	return nil
+}
+
+// Fail records a failed attempt of a running job of this worker. The job is retried with
+// exponential backoff while it has attempts left and is moved to the dead-letter state
+// otherwise.
+func (q *Queue) Fail(job *Job, jobErr error) error {
+	failed := *job
+	delay := failed.fail(jobErr.Error())
+
+	query := `
+		UPDATE jobs
+		SET status = $1, last_error = $2, available_at = NOW() + $3 * INTERVAL '1 millisecond', locked_by = NULL,
+		    locked_at = NULL, updated_at = NOW()
+		WHERE id = $4 AND status = $5 AND locked_by = $6
+	`
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateLocked(tx, job, query, failed.Status, failed.LastError, delay.Milliseconds(), job.ID, JobRunning, q.workerID); err != nil {
+		return err
+	}
+	if err := finishAttempt(tx, job.ID, AttemptFailed, jobErr.Error()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	*job = failed
+
+	if job.Status == JobDead {
+		logger.Error("Job %d on queue %s is dead after %d attempt(s): %v", job.ID, job.QueueName, job.Attempts, jobErr)
+	} else {
+		logger.Warn("Job %d on queue %s failed (attempt %d/%d), retrying in %s: %v", job.ID, job.QueueName, job.Attempts-job.ReplayedAttempts, job.MaxAttempts, delay, jobErr)
+	}
+	return nil
+}
+
+// fail records a failed attempt on the job. A job with attempts left since its last
+// replay becomes pending again after the returned retry delay, the others dead. The
+// attempt count is kept, so that the job's history shows how often it ran.
+func (j *Job) fail(errMsg string) time.Duration {
+	j.LastError = errMsg
+	if attempts := j.Attempts - j.ReplayedAttempts; attempts < j.MaxAttempts {
+		j.Status = JobPending
+		return RetryDelay(attempts)
+	}
+	j.Status = JobDead
+	return 0
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// updateLocked runs an update of a job that only matches while this worker holds it.
+func updateLocked(db execer, job *Job, query string, args ...interface{}) error {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update job %d: %w", job.ID, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update job %d: %w", job.ID, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrJobLost, job.ID)
+	}
+	return nil
+}
+
+// RecoverExpiredJobs releases running jobs whose worker stopped sending heartbeats and
+// records their attempts as lost. Jobs with attempts left become pending again, the
+// others dead.
+func (q *Queue) RecoverExpiredJobs() (int, error) {
+	query := `
+		WITH expired AS (
+			SELECT id, locked_by FROM jobs
+			WHERE status = $3 AND locked_at < NOW() - $4 * INTERVAL '1 second'
+			FOR UPDATE SKIP LOCKED
+		), released AS (
+			UPDATE jobs j
+			SET status = CASE WHEN j.attempts - j.replayed_attempts < j.max_attempts THEN $1 ELSE $2 END,
+			    last_error = 'Worker ' || e.locked_by || ' stopped sending heartbeats',
+			    available_at = NOW(), locked_by = NULL, locked_at = NULL, updated_at = NOW()
+			FROM expired e
+			WHERE j.id = e.id
+			RETURNING j.id, j.last_error
+		), lost AS (
+			UPDATE job_attempts a
+			SET status = $5, error = r.last_error, finished_at = NOW()
+			FROM released r
+			WHERE a.job_id = r.id AND a.finished_at IS NULL
+		)
+		SELECT COUNT(*) FROM released
+	`
+	var n int
+	err := q.db.QueryRow(query, JobPending, JobDead, JobRunning, int(jobLease.Seconds()), AttemptLost).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recover expired jobs: %w", err)
+	}
+	if n > 0 {
+		logger.Warn("Recovered %d job(s) from workers that stopped sending heartbeats", n)
+	}
+	return n, nil
+}
+
+// StartWorkers claims and runs the jobs of the registered queues until ctx is done. The
+// workers wait until the first handler is registered.
+func (q *Queue) StartWorkers(ctx context.Context) {
+	select {
+	case <-q.registered:
+	default:
+		logger.Info("No job handlers registered, job workers start once one is")
+		select {
+		case <-q.registered:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	logger.Info("Starting job workers as %s with %d workers", q.workerID, q.maxWorkers)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := q.RecoverExpiredJobs(); err != nil {
+			logger.Error("Failed to recover expired jobs: %v", err)
+		}
+		if err := q.processJobs(ctx); err != nil {
+			logger.Error("Error processing jobs: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopping job workers")
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+func (q *Queue) processJobs(ctx context.Context) error {
+	free := q.maxWorkers - int(q.active.Load())
+	if free <= 0 {
+		return nil
+	}
+	jobs, err := q.Claim(q.queueNames(), free)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		q.active.Add(1)
+		go func(job *Job) {
+			defer q.release()
+			q.runJob(ctx, job)
+		}(job)
+	}
+	return nil
+}
+
+// release frees the worker of a finished job and wakes the worker loop.
+func (q *Queue) release() {
+	q.active.Add(-1)
+	q.notify()
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runJob runs the handler of a claimed job while sending heartbeats, and records the
+// outcome. A job whose lock was lost is left to the worker that took it over.
+func (q *Queue) runJob(ctx context.Context, job *Job) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go q.keepAlive(jobCtx, cancel, job)
+
+	var err error
+	handler, ok := q.handler(job.QueueName)
+	if ok {
+		err = callHandler(jobCtx, handler, job)
+	} else {
+		err = fmt.Errorf("%w %s", ErrNoHandler, job.QueueName)
+	}
+
+	if errors.Is(context.Cause(jobCtx), ErrJobLost) {
+		logger.Warn("Job %d on queue %s was taken over by another worker", job.ID, job.QueueName)
+		return
+	}
+	if err != nil {
+		if failErr := q.Fail(job, err); failErr != nil {
+			logger.Error("Failed to record failure of job %d: %v", job.ID, failErr)
+		}
+		return
+	}
+	if err := q.Complete(job); err != nil {
+		logger.Error("Failed to complete job %d: %v", job.ID, err)
+		return
+	}
+	logger.Debug("Job %d on queue %s completed", job.ID, job.QueueName)
+}
+
+func (q *Queue) keepAlive(ctx context.Context, cancel context.CancelCauseFunc, job *Job) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := q.Heartbeat(job)
+			if errors.Is(err, ErrJobLost) {
+				cancel(ErrJobLost)
+				return
+			}
+			if err != nil {
+				logger.Error("Failed to send heartbeat for job %d: %v", job.ID, err)
+			}
+		}
+	}
+}
+
+// callHandler runs handler and turns a panic into an error.
+func callHandler(ctx context.Context, handler HandlerFunc, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// RetryDelay returns how long a job waits before the attempt after attempt.
+func RetryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+	return delay
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (*Job, error) {
+	job := &Job{}
+	var lastError, lockedBy sql.NullString
+	var lockedAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID,
+		&job.QueueName,
+		&job.Payload,
+		&job.Description,
+		&job.Status,
+		&job.Priority,
+		&job.AvailableAt,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.Replays,
+		&job.ReplayedAttempts,
+		&lastError,
+		&lockedBy,
+		&lockedAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.LastError = lastError.String
+	job.LockedBy = lockedBy.String
+	if lockedAt.Valid {
+		job.LockedAt = lockedAt.Time
+	}
+	return job, nil
+}
+
+// workerPoolSize returns how many jobs one instance runs at the same time, from
+// JOB_WORKERS.
+func workerPoolSize() int {
+	value := os.Getenv("JOB_WORKERS")
+	if value == "" {
+		return defaultJobWorkers
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		logger.Warn("Invalid JOB_WORKERS %q, using %d", value, defaultJobWorkers)
+		return defaultJobWorkers
+	}
+	return n
+}
+
+func newWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "holonet"
+	}
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{20, retryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		if delay := RetryDelay(tt.attempt); delay != tt.delay {
+			t.Errorf("Expected %s after attempt %d, got %s", tt.delay, tt.attempt, delay)
+		}
+	}
+}
+
+func TestWorkerPoolSize(t *testing.T) {
+	t.Setenv("JOB_WORKERS", "")
+	if n := workerPoolSize(); n != defaultJobWorkers {
+		t.Errorf("Expected %d workers by default, got %d", defaultJobWorkers, n)
+	}
+	t.Setenv("JOB_WORKERS", "12")
+	if n := workerPoolSize(); n != 12 {
+		t.Errorf("Expected 12 workers, got %d", n)
+	}
+	t.Setenv("JOB_WORKERS", "none")
+	if n := workerPoolSize(); n != defaultJobWorkers {
+		t.Errorf("Expected invalid values to be ignored, got %d", n)
+	}
+}
+
+func TestCallHandler(t *testing.T) {
+	job := &Job{ID: 1, Payload: json.RawMessage(`{"device": 5}`)}
+
+	err := callHandler(context.Background(), func(ctx context.Context, job *Job) error {
+		var payload struct {
+			Device int `json:"device"`
+		}
+		if err := job.DecodePayload(&payload); err != nil {
+			return err
+		}
+		if payload.Device != 5 {
+			t.Errorf("Expected device 5, got %d", payload.Device)
+		}
+		return nil
+	}, job)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	err = callHandler(context.Background(), func(ctx context.Context, job *Job) error {
+		panic("boom")
+	}, job)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected panic to be returned as error, got %v", err)
+	}
+}
+
+func TestStartWorkersWaitsForHandler(t *testing.T) {
+	// Without a database the workers would panic on their first poll.
+	q := New(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.StartWorkers(ctx)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected workers without handlers to stop when the context is done")
+	}
+}
@@ -0,0 +1,435 @@
+package workflow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/logger"
+)
+
+const StepWaitingApproval StepStatus = "waiting_approval"
+
+type ApprovalStatus string
+
+const (
+	ApprovalPending   ApprovalStatus = "pending"
+	ApprovalApproved  ApprovalStatus = "approved"
+	ApprovalRejected  ApprovalStatus = "rejected"
+	ApprovalExpired   ApprovalStatus = "expired"
+	ApprovalCancelled ApprovalStatus = "cancelled"
+)
+
+var (
+	// ErrApprovalRejected fails an execution for good when an approval step is rejected or
+	// expires; the execution is compensated and not retried.
+	ErrApprovalRejected  = errors.New("approval rejected")
+	ErrNoPendingApproval = errors.New("execution is not waiting for approval")
+	ErrNotApprover       = errors.New("user is not allowed to decide this approval")
+
+	// errAwaitingApproval stops a run at an approval step that has not been decided yet.
+	errAwaitingApproval = errors.New("waiting for approval")
+)
+
+// ApprovalGate turns a step into a manual sign-off instead of a function call. The
+// execution pauses in ExecutionWaitingApproval until one of Approvers (user IDs; any
+// authenticated user if empty) approves or rejects it. With TimeoutSeconds set, the
+// approval expires after that long, which counts as a rejection.
+type ApprovalGate struct {
+	Approvers      []int  `json:"approvers,omitempty"`
+	Message        string `json:"message,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+}
+
+func (g *ApprovalGate) validate() error {
+	for _, userID := range g.Approvers {
+		if userID <= 0 {
+			return fmt.Errorf("invalid approver user ID %d", userID)
+		}
+	}
+	if g.TimeoutSeconds < 0 {
+		return errors.New("approval timeout_seconds must not be negative")
+	}
+	return nil
+}
+
+// Approval is one request for sign-off of an approval step within one attempt of an
+// execution. Input holds the step's resolved inputs, e.g. the change to be approved.
+type Approval struct {
+	ID          int             `json:"id"`
+	ExecutionID int             `json:"execution_id"`
+	Step        string          `json:"step"`
+	Attempt     int             `json:"attempt"`
+	Status      ApprovalStatus  `json:"status"`
+	Approvers   []int           `json:"approvers"`
+	Message     string          `json:"message,omitempty"`
+	Input       json.RawMessage `json:"input,omitempty"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	DecidedBy   int             `json:"decided_by,omitempty"`
+	Comment     string          `json:"comment,omitempty"`
+	DecidedAt   time.Time       `json:"decided_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// canDecide reports whether userID may approve or reject.
+func (a *Approval) canDecide(userID int) bool {
+	if len(a.Approvers) == 0 {
+		return true
+	}
+	for _, approver := range a.Approvers {
+		if approver == userID {
+			return true
+		}
+	}
+	return false
+}
+
+const approvalColumns = `id, execution_id, step, attempt, status, approvers, message, input, expires_at, decided_by, comment,
+		decided_at, created_at, updated_at`
+
+func scanApproval(row rowScanner) (*Approval, error) {
+	approval := &Approval{}
+	var approvers, input []byte
+	var message, comment sql.NullString
+	var expiresAt, decidedAt sql.NullTime
+	var decidedBy sql.NullInt64
+
+	err := row.Scan(
+		&approval.ID,
+		&approval.ExecutionID,
+		&approval.Step,
+		&approval.Attempt,
+		&approval.Status,
+		&approvers,
+		&message,
+		&input,
+		&expiresAt,
+		&decidedBy,
+		&comment,
+		&decidedAt,
+		&approval.CreatedAt,
+		&approval.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	approval.Approvers = []int{}
+	if len(approvers) > 0 {
+		if err := json.Unmarshal(approvers, &approval.Approvers); err != nil {
+			return nil, fmt.Errorf("failed to decode approvers: %w", err)
+		}
+	}
+	if len(input) > 0 {
+		approval.Input = json.RawMessage(input)
+	}
+	approval.Message = message.String
+	approval.ExpiresAt = expiresAt.Time
+	approval.DecidedBy = int(decidedBy.Int64)
+	approval.Comment = comment.String
+	approval.DecidedAt = decidedAt.Time
+	return approval, nil
+}
+
+// ApprovalFilter selects approvals. Zero fields do not filter.
+type ApprovalFilter struct {
+	ExecutionID int
+	Status      ApprovalStatus
+}
+
+func (wm *WorkflowManager) ListApprovals(filter ApprovalFilter) ([]*Approval, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if filter.ExecutionID != 0 {
+		args = append(args, filter.ExecutionID)
+		conditions = append(conditions, fmt.Sprintf("execution_id = $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := `
+		SELECT ` + approvalColumns + `
+		FROM workflow_approvals
+		` + where + `
+		ORDER BY id
+	`
+
+	rows, err := wm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list approvals: %w", err)
+	}
+	defer rows.Close()
+
+	approvals := []*Approval{}
+	for rows.Next() {
+		approval, err := scanApproval(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan approval: %w", err)
+		}
+		approvals = append(approvals, approval)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating approvals: %w", err)
+	}
+
+	return approvals, nil
+}
+
+// DecideApproval approves or rejects the pending approval of an execution on behalf of
+// userID and hands the execution back to the executor, which continues or fails it.
+func (wm *WorkflowManager) DecideApproval(executionID, userID int, approve bool, comment string) (*Approval, error) {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + approvalColumns + `
+		FROM workflow_approvals
+		WHERE execution_id = $1 AND status = $2 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY id DESC
+		LIMIT 1
+		FOR UPDATE
+	`
+	approval, err := scanApproval(tx.QueryRow(query, executionID, ApprovalPending))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get approval: %w", err)
+		}
+		if _, err := wm.GetExecution(executionID); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoPendingApproval
+	}
+
+	if !approval.canDecide(userID) {
+		return nil, ErrNotApprover
+	}
+
+	approval.Status = ApprovalRejected
+	if approve {
+		approval.Status = ApprovalApproved
+	}
+
+	updateQuery := `
+		UPDATE workflow_approvals
+		SET status = $1, decided_by = $2, comment = $3, decided_at = NOW(), updated_at = NOW()
+		WHERE id = $4
+		RETURNING decided_at, updated_at
+	`
+	err = tx.QueryRow(updateQuery, approval.Status, userID, comment, approval.ID).Scan(&approval.DecidedAt, &approval.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decide approval: %w", err)
+	}
+	approval.DecidedBy = userID
+	approval.Comment = comment
+
+	requeueQuery := `
+		UPDATE workflow_executions
+		SET status = $1, scheduled_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND status = $3
+	`
+	if _, err := tx.Exec(requeueQuery, ExecutionPending, executionID, ExecutionWaitingApproval); err != nil {
+		return nil, fmt.Errorf("failed to requeue execution: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	logger.Info("User %d %s step %s of execution %d", userID, approval.Status, approval.Step, executionID)
+	return approval, nil
+}
+
+// ExpireApprovals rejects pending approvals whose timeout has passed and requeues their
+// executions, which then fail.
+func (wm *WorkflowManager) ExpireApprovals() (int, error) {
+	query := `
+		WITH expired AS (
+			UPDATE workflow_approvals
+			SET status = $1, decided_at = NOW(), updated_at = NOW()
+			WHERE status = $2 AND expires_at IS NOT NULL AND expires_at <= NOW()
+			RETURNING execution_id
+		)
+		UPDATE workflow_executions
+		SET status = $3, scheduled_at = NOW(), updated_at = NOW()
+		WHERE id IN (SELECT execution_id FROM expired) AND status = $4
+	`
+
+	result, err := wm.db.Exec(query, ApprovalExpired, ApprovalPending, ExecutionPending, ExecutionWaitingApproval)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire approvals: %w", err)
+	}
+
+	expired, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired approvals: %w", err)
+	}
+	if expired > 0 {
+		logger.Info("Expired approvals of %d execution(s)", expired)
+	}
+	return int(expired), nil
+}
+
+// cancelPendingApprovals withdraws the open approvals of an execution.
+func cancelPendingApprovals(tx *sql.Tx, executionID int) error {
+	query := `
+		UPDATE workflow_approvals
+		SET status = $1, decided_at = NOW(), updated_at = NOW()
+		WHERE execution_id = $2 AND status = $3
+	`
+	if _, err := tx.Exec(query, ApprovalCancelled, executionID, ApprovalPending); err != nil {
+		return fmt.Errorf("failed to cancel approvals of execution %d: %w", executionID, err)
+	}
+	return nil
+}
+
+// approvalForStep returns the approval of a step in the given attempt, if any.
+func (wm *WorkflowManager) approvalForStep(executionID int, step string, attempt int) (*Approval, error) {
+	query := `
+		SELECT ` + approvalColumns + `
+		FROM workflow_approvals
+		WHERE execution_id = $1 AND step = $2 AND attempt = $3
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	approval, err := scanApproval(wm.db.QueryRow(query, executionID, step, attempt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get approval of step %s: %w", step, err)
+	}
+	return approval, nil
+}
+
+func (wm *WorkflowManager) requestApproval(approval *Approval, timeout time.Duration) error {
+	approvers, err := json.Marshal(approval.Approvers)
+	if err != nil {
+		return fmt.Errorf("failed to encode approvers: %w", err)
+	}
+
+	query := `
+		INSERT INTO workflow_approvals (execution_id, step, attempt, status, approvers, message, input, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CASE WHEN $8::float8 > 0 THEN NOW() + make_interval(secs => $8) END, NOW(), NOW())
+		RETURNING id, expires_at, created_at, updated_at
+	`
+
+	var expiresAt sql.NullTime
+	err = wm.db.QueryRow(
+		query,
+		approval.ExecutionID,
+		approval.Step,
+		approval.Attempt,
+		approval.Status,
+		approvers,
+		approval.Message,
+		nullJSON(approval.Input),
+		timeout.Seconds(),
+	).Scan(&approval.ID, &expiresAt, &approval.CreatedAt, &approval.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to request approval of step %s: %w", approval.Step, err)
+	}
+	approval.ExpiresAt = expiresAt.Time
+	return nil
+}
+
+// suspendForApproval releases an execution that stopped at an approval step. If the
+// approval was already decided in the meantime, the execution is requeued right away.
+func (wm *WorkflowManager) suspendForApproval(execution *WorkflowExecution, workerID string) error {
+	query := `
+		UPDATE workflow_executions
+		SET status = CASE WHEN EXISTS (
+		        SELECT 1 FROM workflow_approvals WHERE execution_id = $1 AND status = $2
+		    ) THEN $3 ELSE $4 END,
+		    result = $5, scheduled_at = NOW(), locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND locked_by = $6
+		RETURNING status, updated_at
+	`
+
+	err := wm.db.QueryRow(
+		query,
+		execution.ID,
+		ApprovalPending,
+		ExecutionWaitingApproval,
+		ExecutionPending,
+		execution.Result,
+		workerID,
+	).Scan(&execution.Status, &execution.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("failed to suspend execution: %w", err)
+	}
+
+	execution.LockedBy = ""
+	execution.LeaseExpiresAt = time.Time{}
+	return nil
+}
+
+// runApprovalStep requests sign-off for an approval step, or applies the decision once
+// there is one. It returns errAwaitingApproval while the approval is pending.
+func (e *Executor) runApprovalStep(step Step, state *ExecutionStep, execution *WorkflowExecution) (interface{}, error) {
+	approval, err := e.manager.approvalForStep(execution.ID, step.Name, execution.Attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	if approval == nil {
+		approval = &Approval{
+			ExecutionID: execution.ID,
+			Step:        step.Name,
+			Attempt:     execution.Attempt,
+			Status:      ApprovalPending,
+			Approvers:   step.Approval.Approvers,
+			Message:     step.Approval.Message,
+			Input:       state.Input,
+		}
+		if approval.Approvers == nil {
+			approval.Approvers = []int{}
+		}
+		timeout := time.Duration(step.Approval.TimeoutSeconds) * time.Second
+		if err := e.manager.requestApproval(approval, timeout); err != nil {
+			return nil, err
+		}
+		e.logf(execution.ID, "Step %s is waiting for approval", step.Name)
+	}
+
+	switch approval.Status {
+	case ApprovalPending:
+		state.Status = StepWaitingApproval
+		if err := e.manager.saveExecutionStep(state); err != nil {
+			return nil, err
+		}
+		return nil, errAwaitingApproval
+	case ApprovalApproved:
+		e.logf(execution.ID, "Step %s was approved by user %d", step.Name, approval.DecidedBy)
+		return map[string]interface{}{
+			"approved_by": approval.DecidedBy,
+			"comment":     approval.Comment,
+			"decided_at":  approval.DecidedAt,
+		}, nil
+	case ApprovalExpired:
+		return nil, fmt.Errorf("%w: approval expired", ErrApprovalRejected)
+	default:
+		reason := fmt.Sprintf("%s by user %d", approval.Status, approval.DecidedBy)
+		if approval.Comment != "" {
+			reason += ": " + approval.Comment
+		}
+		return nil, fmt.Errorf("%w: %s", ErrApprovalRejected, reason)
+	}
+}
@@ -0,0 +1,32 @@
+package workflow
+
+import "testing"
+
+func TestValidateWorkflowWithApprovalStep(t *testing.T) {
+	wf := &Workflow{
+		Code: "package main\n\nfunc Plan(in map[string]interface{}) (interface{}, error) { return nil, nil }\n",
+		Steps: []Step{
+			{Name: "plan", Function: "Plan"},
+			{Name: "sign_off", DependsOn: []string{"plan"}, Inputs: map[string]interface{}{"change": "${steps.plan.output}"},
+				Approval: &ApprovalGate{Approvers: []int{3, 7}, TimeoutSeconds: 3600}},
+		},
+	}
+	if err := ValidateWorkflow(wf); err != nil {
+		t.Errorf("Expected workflow with approval step to be valid, got %v", err)
+	}
+}
+
+func TestApprovalCanDecide(t *testing.T) {
+	restricted := &Approval{Approvers: []int{3, 7}}
+	if !restricted.canDecide(7) {
+		t.Error("Expected listed approver to be allowed")
+	}
+	if restricted.canDecide(4) {
+		t.Error("Expected unlisted user to be refused")
+	}
+
+	open := &Approval{Approvers: []int{}}
+	if !open.canDecide(4) {
+		t.Error("Expected any user to be allowed when no approvers are listed")
+	}
+}
@@ -0,0 +1,325 @@
+package workflow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/holonet/core/logger"
+	"github.com/lib/pq"
+)
+
+// ErrLeaseLost is returned when an execution is no longer locked by the calling worker,
+// typically because its lease expired and another worker recovered it.
+var ErrLeaseLost = errors.New("execution lease lost")
+
+// ClaimPendingExecutions atomically moves up to limit due executions to running and locks
+// them to workerID. Executions that would exceed their workflow's max concurrency or
+// share a concurrency key with an unfinished execution stay pending until a slot frees
+// up. Executions waiting for an approval or a sub-workflow keep their slot, except
+// towards their own sub-workflows.
+// Claims are serialized with an advisory lock, so each execution is handed to exactly
+// one worker and the limits hold even when several holonet instances poll the same table.
+func (wm *WorkflowManager) ClaimPendingExecutions(workerID string, limit int, lease time.Duration) ([]*WorkflowExecution, error) {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, claimLockID); err != nil {
+		return nil, fmt.Errorf("failed to lock claims: %w", err)
+	}
+
+	// ancestors lists the executions that due sub-workflows wait for, which do not hold
+	// slots towards them.
+	candidateQuery := `
+		WITH RECURSIVE ancestors AS (
+			SELECT e.id AS execution_id, e.parent_execution_id AS id
+			FROM workflow_executions e
+			WHERE e.status = $2 AND e.scheduled_at <= NOW() AND e.parent_execution_id IS NOT NULL
+			UNION
+			SELECT a.execution_id, p.parent_execution_id
+			FROM ancestors a
+			JOIN workflow_executions p ON p.id = a.id
+			WHERE p.parent_execution_id IS NOT NULL
+		)
+		SELECT e.id, e.workflow_id, COALESCE(e.concurrency_key, ''), w.max_concurrency,
+		       (SELECT COUNT(*) FROM workflow_executions r WHERE r.workflow_id = e.workflow_id AND r.status = $1),
+		       (SELECT COUNT(*) FROM workflow_executions r WHERE r.workflow_id = e.workflow_id AND r.status = ANY($4)
+		           AND r.id NOT IN (SELECT a.id FROM ancestors a WHERE a.execution_id = e.id))
+		FROM workflow_executions e
+		JOIN workflows w ON w.id = e.workflow_id
+		WHERE e.status = $2 AND e.scheduled_at <= NOW()
+		  AND (e.concurrency_key IS NULL OR NOT EXISTS (
+		      SELECT 1 FROM workflow_executions r
+		      WHERE r.concurrency_key = e.concurrency_key
+		        AND (r.status = $1 OR (r.status = ANY($4)
+		             AND r.id NOT IN (SELECT a.id FROM ancestors a WHERE a.execution_id = e.id)))))
+		ORDER BY e.scheduled_at, e.id
+		LIMIT $3
+	`
+
+	rows, err := tx.Query(candidateQuery, ExecutionRunning, ExecutionPending, claimCandidateWindow, pq.Array(waitingStatuses))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending executions: %w", err)
+	}
+	candidates := []claimCandidate{}
+	for rows.Next() {
+		var c claimCandidate
+		if err := rows.Scan(&c.id, &c.workflowID, &c.key, &c.maxConcurrency, &c.running, &c.waiting); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan pending execution: %w", err)
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pending executions: %w", err)
+	}
+
+	ids := admitExecutions(candidates, limit)
+	if len(ids) == 0 {
+		return []*WorkflowExecution{}, nil
+	}
+
+	query := `
+		UPDATE workflow_executions
+		SET status = $1, locked_by = $2, lease_expires_at = NOW() + make_interval(secs => $3),
+		    started_at = NOW(), updated_at = NOW()
+		WHERE id = ANY($4) AND status = $5
+		RETURNING ` + executionColumns
+
+	rows, err = tx.Query(query, ExecutionRunning, workerID, lease.Seconds(), pq.Array(ids), ExecutionPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim pending executions: %w", err)
+	}
+	defer rows.Close()
+
+	executions := []*WorkflowExecution{}
+	for rows.Next() {
+		execution, err := scanExecution(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan claimed execution: %w", err)
+		}
+		executions = append(executions, execution)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating claimed executions: %w", err)
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return executions, nil
+}
+
+// RenewLease extends the lease of a running execution held by workerID. It returns
+// ErrCancelRequested once cancellation of the execution has been requested.
+func (wm *WorkflowManager) RenewLease(executionID int, workerID string, lease time.Duration) error {
+	query := `
+		UPDATE workflow_executions
+		SET lease_expires_at = NOW() + make_interval(secs => $1), updated_at = NOW()
+		WHERE id = $2 AND locked_by = $3 AND status = $4
+		RETURNING cancel_requested_at
+	`
+
+	var cancelRequestedAt sql.NullTime
+	err := wm.db.QueryRow(query, lease.Seconds(), executionID, workerID, ExecutionRunning).Scan(&cancelRequestedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("failed to renew lease: %w", err)
+	}
+
+	if cancelRequestedAt.Valid {
+		return ErrCancelRequested
+	}
+
+	return nil
+}
+
+// FinishExecution stores the final state of an execution, records it as the outcome of
+// the current attempt and releases the lock. It fails with ErrLeaseLost if workerID no
+// longer owns the execution, so a worker whose lease expired cannot overwrite the outcome
+// of the worker that recovered it.
+func (wm *WorkflowManager) FinishExecution(execution *WorkflowExecution, workerID string) error {
+	var compensations []byte
+	if len(execution.Compensations) > 0 {
+		var err error
+		if compensations, err = json.Marshal(execution.Compensations); err != nil {
+			return fmt.Errorf("failed to encode compensations: %w", err)
+		}
+	}
+
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE workflow_executions
+		SET status = $1, result = $2, error_message = $3, completed_at = $4, compensations = $5,
+		    locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+		WHERE id = $6 AND locked_by = $7
+		RETURNING updated_at
+	`
+
+	err = tx.QueryRow(
+		query,
+		execution.Status,
+		execution.Result,
+		execution.ErrorMessage,
+		nullTime(execution.CompletedAt),
+		compensations,
+		execution.ID,
+		workerID,
+	).Scan(&execution.UpdatedAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("failed to finish execution: %w", err)
+	}
+
+	if err := recordAttempt(tx, execution, workerID); err != nil {
+		return err
+	}
+	if execution.Status.IsFinal() {
+		if err := wakeParent(tx, execution); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	execution.LockedBy = ""
+	execution.LeaseExpiresAt = time.Time{}
+	return nil
+}
+
+// RecoverExpiredExecutions settles running executions whose lease expired. Running rows
+// without a lease (written before leases existed) are recovered once they have not been
+// touched for longer than lease. The interrupted run is recorded as a lost attempt; the
+// execution is put back to pending while it has attempts left and fails otherwise.
+func (wm *WorkflowManager) RecoverExpiredExecutions(lease time.Duration) (int, error) {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT e.id, e.attempt, e.mode, e.locked_by, e.started_at, w.max_attempts
+		FROM workflow_executions e
+		JOIN workflows w ON w.id = e.workflow_id
+		WHERE e.status = $1
+		  AND (e.lease_expires_at < NOW()
+		       OR (e.lease_expires_at IS NULL AND e.updated_at < NOW() - make_interval(secs => $2)))
+		FOR UPDATE OF e SKIP LOCKED
+	`
+
+	rows, err := tx.Query(query, ExecutionRunning, lease.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to recover expired executions: %w", err)
+	}
+
+	type expiredExecution struct {
+		execution   *WorkflowExecution
+		maxAttempts int
+	}
+	expired := []expiredExecution{}
+	for rows.Next() {
+		execution := &WorkflowExecution{}
+		var lockedBy sql.NullString
+		var startedAt sql.NullTime
+		var maxAttempts int
+		if err := rows.Scan(&execution.ID, &execution.Attempt, &execution.Mode, &lockedBy, &startedAt, &maxAttempts); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan expired execution: %w", err)
+		}
+		execution.LockedBy = lockedBy.String
+		execution.StartedAt = startedAt.Time
+		expired = append(expired, expiredExecution{execution: execution, maxAttempts: maxAttempts})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating expired executions: %w", err)
+	}
+
+	retryQuery := `
+		UPDATE workflow_executions
+		SET status = $1, attempt = attempt + 1, locked_by = NULL, lease_expires_at = NULL,
+		    started_at = NULL, updated_at = NOW()
+		WHERE id = $2
+	`
+	failQuery := `
+		UPDATE workflow_executions
+		SET status = $1, error_message = $2, completed_at = NOW(), locked_by = NULL, lease_expires_at = NULL,
+		    updated_at = NOW()
+		WHERE id = $3
+	`
+	for _, e := range expired {
+		execution := e.execution
+		status := expiredExecutionStatus(execution, e.maxAttempts)
+
+		lost := *execution
+		lost.Status = ExecutionLost
+		lost.ErrorMessage = "Worker lease expired"
+		lost.CompletedAt = time.Now()
+		if err := recordAttempt(tx, &lost, execution.LockedBy); err != nil {
+			return 0, err
+		}
+
+		if status == ExecutionPending {
+			_, err = tx.Exec(retryQuery, ExecutionPending, execution.ID)
+		} else {
+			_, err = tx.Exec(failQuery, ExecutionFailed, fmt.Sprintf("Worker lease expired after %d attempts", execution.Attempt), execution.ID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to recover execution %d: %w", execution.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit recovered executions: %w", err)
+	}
+
+	for _, e := range expired {
+		if expiredExecutionStatus(e.execution, e.maxAttempts) == ExecutionPending {
+			logger.Warn("Recovered execution %d after its lease expired", e.execution.ID)
+		} else {
+			logger.Warn("Execution %d failed after its lease expired with no attempts left", e.execution.ID)
+		}
+	}
+	return len(expired), nil
+}
+
+// expiredExecutionStatus is the status an execution whose lease expired moves to. Like a
+// failed attempt, it runs again while it has attempts left; apply executions are never
+// run again, since their changes were partly sent.
+func expiredExecutionStatus(execution *WorkflowExecution, maxAttempts int) ExecutionStatus {
+	if execution.Attempt >= maxAttempts || execution.Mode == ModeApply {
+		return ExecutionFailed
+	}
+	return ExecutionPending
+}
+
+func newWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "holonet"
+	}
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
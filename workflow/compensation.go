@@ -0,0 +1,152 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/holonet/core/logger"
+)
+
+const (
+	StepCompensated        StepStatus = "compensated"
+	StepCompensationFailed StepStatus = "compensation_failed"
+)
+
+type CompensationStatus string
+
+const (
+	CompensationCompleted CompensationStatus = "compensated"
+	CompensationFailed    CompensationStatus = "failed"
+	// CompensationUndefined marks a completed step without a compensating function, whose
+	// effects were left in place.
+	CompensationUndefined CompensationStatus = "not_defined"
+)
+
+// compensationTimeout bounds all compensations of one execution together.
+const compensationTimeout = 5 * time.Minute
+
+// Compensation records the rollback of one completed step of a failed or cancelled
+// execution.
+type Compensation struct {
+	Step         string             `json:"step"`
+	Function     string             `json:"function,omitempty"`
+	Status       CompensationStatus `json:"status"`
+	ErrorMessage string             `json:"error_message,omitempty"`
+	CompletedAt  time.Time          `json:"completed_at"`
+}
+
+// compensate undoes the completed steps of a multi-step execution in reverse completion
+// order by calling each step's Compensate function with the step's input and output.
+// Compensations are attempted for every step even if one of them fails; the outcome is
+// stored on the steps and in execution.Compensations. It reports whether every completed
+// step was rolled back.
+func (e *Executor) compensate(ctx context.Context, workflow *Workflow, execution *WorkflowExecution) bool {
+	// Dry runs changed nothing, and apply executions do not run steps.
+	if len(workflow.Steps) == 0 || execution.Mode == ModeDryRun || execution.Mode == ModeApply {
+		return true
+	}
+
+	states, err := e.manager.ListExecutionSteps(execution.ID)
+	if err != nil {
+		logger.Error("Failed to load steps of execution %d for compensation: %v", execution.ID, err)
+		return false
+	}
+
+	definitions := make(map[string]Step, len(workflow.Steps))
+	for _, step := range workflow.Steps {
+		definitions[step.Name] = step
+	}
+
+	// Approval and sub-workflow steps have nothing to undo; a sub-workflow compensates
+	// its own steps when it fails.
+	completed := []*ExecutionStep{}
+	for _, state := range states {
+		definition := definitions[state.Name]
+		if state.Status == StepCompleted && definition.Approval == nil && definition.Workflow == nil {
+			completed = append(completed, state)
+		}
+	}
+	if len(completed) == 0 {
+		return true
+	}
+	sort.SliceStable(completed, func(i, j int) bool {
+		return completed[i].CompletedAt.After(completed[j].CompletedAt)
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, compensationTimeout)
+	defer cancel()
+
+	params, err := decodeParameters(execution.Parameters)
+	if err != nil {
+		params = map[string]interface{}{}
+	}
+
+	var p *program
+	var loadErr error
+	complete := true
+	for _, state := range completed {
+		step := definitions[state.Name]
+		record := Compensation{Step: state.Name, Function: step.Compensate}
+
+		switch {
+		case step.Compensate == "":
+			record.Status = CompensationUndefined
+			complete = false
+		default:
+			if p == nil && loadErr == nil {
+				p, loadErr = e.runtime.load(ctx, workflow, execution, params)
+			}
+			err := loadErr
+			if err == nil {
+				err = e.compensateStep(ctx, p, step, state)
+			}
+			if err != nil {
+				err = e.manager.logs.redactError(execution.ID, err)
+				logger.Error("Compensation of step %s of execution %d failed: %v", state.Name, execution.ID, err)
+				e.logf(execution.ID, "Compensation of step %s failed: %v", state.Name, err)
+				record.Status = CompensationFailed
+				record.ErrorMessage = err.Error()
+				state.Status = StepCompensationFailed
+				state.ErrorMessage = "Compensation failed: " + err.Error()
+				complete = false
+			} else {
+				logger.Info("Compensated step %s of execution %d", state.Name, execution.ID)
+				e.logf(execution.ID, "Compensated step %s", state.Name)
+				record.Status = CompensationCompleted
+				state.Status = StepCompensated
+			}
+			if err := e.manager.saveExecutionStep(state); err != nil {
+				logger.Error("Failed to record compensation of step %s: %v", state.Name, err)
+			}
+		}
+
+		record.CompletedAt = time.Now()
+		execution.Compensations = append(execution.Compensations, record)
+	}
+
+	return complete
+}
+
+func (e *Executor) compensateStep(ctx context.Context, p *program, step Step, state *ExecutionStep) error {
+	var input, output interface{}
+	if len(state.Input) > 0 {
+		if err := json.Unmarshal(state.Input, &input); err != nil {
+			return fmt.Errorf("failed to decode step input: %w", err)
+		}
+	}
+	if len(state.Output) > 0 {
+		if err := json.Unmarshal(state.Output, &output); err != nil {
+			return fmt.Errorf("failed to decode step output: %w", err)
+		}
+	}
+
+	p.host.enterScope("compensate:" + step.Name)
+	_, err := p.call(ctx, step.Compensate, map[string]interface{}{
+		"input":  input,
+		"output": output,
+	})
+	return err
+}
@@ -0,0 +1,133 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/holonet/core/logger"
+)
+
+const (
+	defaultWorkerPoolSize   = 10
+	maxConcurrencyKeyLength = 255
+	// claimCandidateWindow is how many due executions a claim looks at, so that a run of
+	// executions blocked by one key does not starve the others.
+	claimCandidateWindow = 200
+	// claimLockID is the advisory lock that serializes claims across holonet instances,
+	// so that two workers cannot both start the last free slot of a workflow or key.
+	claimLockID = 7401
+)
+
+// waitingStatuses are the statuses of executions that paused partway through their
+// changes. They keep their slot and concurrency key until they finish.
+var waitingStatuses = []string{
+	string(ExecutionWaitingApproval),
+	string(ExecutionWaitingWorkflow),
+}
+
+// workerPoolSize returns how many executions one executor runs at the same time, from
+// WORKFLOW_WORKERS.
+func workerPoolSize() int {
+	value := os.Getenv("WORKFLOW_WORKERS")
+	if value == "" {
+		return defaultWorkerPoolSize
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		logger.Warn("Invalid WORKFLOW_WORKERS %q, using %d", value, defaultWorkerPoolSize)
+		return defaultWorkerPoolSize
+	}
+	return n
+}
+
+// validateConcurrencyKey checks that a concurrency key template only references
+// execution parameters.
+func validateConcurrencyKey(key string) error {
+	if len(key) > maxConcurrencyKeyLength {
+		return fmt.Errorf("concurrency_key must be at most %d characters", maxConcurrencyKeyLength)
+	}
+	for _, match := range refPattern.FindAllStringSubmatch(key, -1) {
+		path, err := parseRef(match[1])
+		if err != nil {
+			return fmt.Errorf("invalid concurrency_key: %w", err)
+		}
+		if path[0] != "params" {
+			return fmt.Errorf("invalid concurrency_key: ${%s} must reference a parameter", match[1])
+		}
+	}
+	return nil
+}
+
+// resolveConcurrencyKey returns the concurrency key of an execution with the given
+// parameters, or "" if the workflow has none. Parameters the key references but that
+// are missing are reported as a *ParameterValidationError.
+func (p ExecutionPolicy) resolveConcurrencyKey(parameters json.RawMessage) (string, error) {
+	if p.ConcurrencyKey == "" {
+		return "", nil
+	}
+
+	params, err := decodeParameters(parameters)
+	if err != nil {
+		return "", &ParameterValidationError{Errors: []FieldError{{Message: err.Error()}}}
+	}
+	resolved, err := stepScope{params: params}.resolve(p.ConcurrencyKey)
+	if err != nil {
+		return "", &ParameterValidationError{Errors: []FieldError{{Message: "concurrency key: " + err.Error()}}}
+	}
+
+	key, ok := resolved.(string)
+	if !ok {
+		encoded, _ := json.Marshal(resolved)
+		key = string(encoded)
+	}
+	if len(key) > maxConcurrencyKeyLength {
+		key = key[:maxConcurrencyKeyLength]
+	}
+	return key, nil
+}
+
+// claimCandidate is a due execution with what is needed to decide whether it may start.
+type claimCandidate struct {
+	id             int
+	workflowID     int
+	key            string
+	maxConcurrency int
+	// running and waiting are the numbers of running and waiting executions of the
+	// workflow, not counting those the candidate runs as a sub-workflow of.
+	running int
+	waiting int
+}
+
+// admitExecutions picks, in order, up to limit candidates that can start without
+// exceeding their workflow's max concurrency or sharing a concurrency key with an
+// unfinished execution or another admitted one. Waiting executions hold their slot like
+// running ones. Candidates are expected to exclude keys that are already held.
+func admitExecutions(candidates []claimCandidate, limit int) []int {
+	admitted := []int{}
+	running := make(map[int]int)
+	keys := make(map[string]bool)
+
+	for _, c := range candidates {
+		if len(admitted) >= limit {
+			break
+		}
+		if _, seen := running[c.workflowID]; !seen {
+			running[c.workflowID] = c.running + c.waiting
+		}
+		if c.maxConcurrency > 0 && running[c.workflowID] >= c.maxConcurrency {
+			continue
+		}
+		if c.key != "" && keys[c.key] {
+			continue
+		}
+
+		admitted = append(admitted, c.id)
+		running[c.workflowID]++
+		if c.key != "" {
+			keys[c.key] = true
+		}
+	}
+	return admitted
+}
@@ -0,0 +1,128 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAdmitExecutions(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []claimCandidate
+		limit      int
+		want       []int
+	}{
+		{
+			name:       "unlimited",
+			candidates: []claimCandidate{{id: 1, workflowID: 1}, {id: 2, workflowID: 1}, {id: 3, workflowID: 2}},
+			limit:      10,
+			want:       []int{1, 2, 3},
+		},
+		{
+			name:       "batch limit",
+			candidates: []claimCandidate{{id: 1, workflowID: 1}, {id: 2, workflowID: 1}, {id: 3, workflowID: 2}},
+			limit:      2,
+			want:       []int{1, 2},
+		},
+		{
+			name: "max concurrency counts running executions",
+			candidates: []claimCandidate{
+				{id: 1, workflowID: 1, maxConcurrency: 2, running: 1},
+				{id: 2, workflowID: 1, maxConcurrency: 2, running: 1},
+				{id: 3, workflowID: 2},
+			},
+			limit: 10,
+			want:  []int{1, 3},
+		},
+		{
+			name:       "workflow at its limit",
+			candidates: []claimCandidate{{id: 1, workflowID: 1, maxConcurrency: 1, running: 1}, {id: 2, workflowID: 2}},
+			limit:      10,
+			want:       []int{2},
+		},
+		{
+			name: "waiting executions hold their slot",
+			candidates: []claimCandidate{
+				{id: 1, workflowID: 1, maxConcurrency: 2, running: 1, waiting: 1},
+				{id: 2, workflowID: 2, maxConcurrency: 1, waiting: 1},
+				{id: 3, workflowID: 3, maxConcurrency: 2, waiting: 1},
+				{id: 4, workflowID: 3, maxConcurrency: 2, waiting: 1},
+			},
+			limit: 10,
+			want:  []int{3},
+		},
+		{
+			name: "one execution per key",
+			candidates: []claimCandidate{
+				{id: 1, workflowID: 1, key: "site-ams1"},
+				{id: 2, workflowID: 1, key: "site-ams1"},
+				{id: 3, workflowID: 2, key: "site-ams1"},
+				{id: 4, workflowID: 1, key: "site-fra1"},
+			},
+			limit: 10,
+			want:  []int{1, 4},
+		},
+		{
+			name: "blocked candidates do not use the batch",
+			candidates: []claimCandidate{
+				{id: 1, workflowID: 1, key: "a"},
+				{id: 2, workflowID: 1, key: "a"},
+				{id: 3, workflowID: 1, key: "b"},
+			},
+			limit: 2,
+			want:  []int{1, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := admitExecutions(tt.candidates, tt.limit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestValidateConcurrencyKey(t *testing.T) {
+	for _, key := range []string{"", "global", "site-${params.site}", "${params.site.slug}/${params.tenant}"} {
+		if err := validateConcurrencyKey(key); err != nil {
+			t.Errorf("Expected key %q to be valid, got %v", key, err)
+		}
+	}
+	for _, key := range []string{"${steps.a.output}", "${site}", "${params}"} {
+		if err := validateConcurrencyKey(key); err == nil {
+			t.Errorf("Expected key %q to be invalid", key)
+		}
+	}
+
+	policy := DefaultExecutionPolicy()
+	policy.MaxConcurrency = -1
+	if err := policy.Validate(); err == nil {
+		t.Error("Expected negative max_concurrency to be invalid")
+	}
+}
+
+func TestResolveConcurrencyKey(t *testing.T) {
+	policy := ExecutionPolicy{ConcurrencyKey: "site-${params.site.slug}"}
+	key, err := policy.resolveConcurrencyKey(json.RawMessage(`{"site": {"slug": "ams1"}}`))
+	if err != nil {
+		t.Fatalf("Failed to resolve key: %v", err)
+	}
+	if key != "site-ams1" {
+		t.Errorf("Expected key site-ams1, got %q", key)
+	}
+
+	policy.ConcurrencyKey = "${params.site_id}"
+	if key, _ := policy.resolveConcurrencyKey(json.RawMessage(`{"site_id": 12}`)); key != "12" {
+		t.Errorf("Expected a numeric parameter to be formatted, got %q", key)
+	}
+
+	var validationErr *ParameterValidationError
+	if _, err := policy.resolveConcurrencyKey(json.RawMessage(`{}`)); !errors.As(err, &validationErr) {
+		t.Errorf("Expected a missing parameter to be a validation error, got %v", err)
+	}
+
+	if key, err := (ExecutionPolicy{}).resolveConcurrencyKey(nil); key != "" || err != nil {
+		t.Errorf("Expected no key without a template, got %q, %v", key, err)
+	}
+}
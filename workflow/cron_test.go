@@ -0,0 +1,71 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"@every 5m",
+		"a * * * *",
+	}
+
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseCron(expr); err == nil {
+				t.Errorf("Expected error for expression '%s', got nil", expr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := time.UTC
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+
+	tests := []struct {
+		expr     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"0 0 * * *", time.Date(2025, 1, 1, 12, 30, 0, 0, utc), time.Date(2025, 1, 2, 0, 0, 0, 0, utc)},
+		{"@daily", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2025, 1, 2, 0, 0, 0, 0, utc)},
+		{"*/15 * * * *", time.Date(2025, 1, 1, 12, 7, 30, 0, utc), time.Date(2025, 1, 1, 12, 15, 0, 0, utc)},
+		{"30 9 * * mon-fri", time.Date(2025, 1, 3, 10, 0, 0, 0, utc), time.Date(2025, 1, 6, 9, 30, 0, 0, utc)},
+		{"0 0 1 jan *", time.Date(2025, 6, 1, 0, 0, 0, 0, utc), time.Date(2026, 1, 1, 0, 0, 0, 0, utc)},
+		{"0 0 29 2 *", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+		{"0 12 * * 7", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2025, 1, 5, 12, 0, 0, 0, utc)},
+		// Day of month and day of week are OR-ed when both are restricted.
+		{"0 0 15 * fri", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Date(2025, 1, 3, 0, 0, 0, 0, utc)},
+		{"0 0 * * *", time.Date(2025, 3, 29, 12, 0, 0, 0, amsterdam), time.Date(2025, 3, 30, 0, 0, 0, 0, amsterdam)},
+		// 02:30 does not exist on the spring-forward day in Amsterdam.
+		{"30 2 * * *", time.Date(2025, 3, 29, 12, 0, 0, 0, amsterdam), time.Date(2025, 3, 31, 2, 30, 0, 0, amsterdam)},
+		{"0 0 30 2 *", time.Date(2025, 1, 1, 0, 0, 0, 0, utc), time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			schedule, err := ParseCron(test.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse '%s': %v", test.expr, err)
+			}
+			next := schedule.Next(test.from)
+			if !next.Equal(test.expected) {
+				t.Errorf("Expected next run %s, got %s", test.expected, next)
+			}
+		})
+	}
+}
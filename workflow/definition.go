@@ -0,0 +1,518 @@
+package workflow
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/holonet/core/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// DefinitionVersion is the version of the workflow definition format.
+const DefinitionVersion = "holonet/v1"
+
+var (
+	ErrInvalidDefinition = errors.New("invalid workflow definition")
+	ErrAmbiguousWorkflow = errors.New("more than one workflow has this name")
+)
+
+// Definition is the declarative form of a workflow, with its schedules and triggers, that
+// can be kept in version control and imported as YAML or JSON. A workflow is identified
+// by its name: importing a definition updates the workflow with that name, or creates it.
+type Definition struct {
+	Version         string          `json:"version"`
+	Name            string          `json:"name"`
+	Description     string          `json:"description,omitempty"`
+	Status          WorkflowStatus  `json:"status,omitempty"`
+	ParameterSchema json.RawMessage `json:"parameter_schema,omitempty"`
+	ExecutionPolicy
+	Steps     []Step               `json:"steps,omitempty"`
+	Schedules []ScheduleDefinition `json:"schedules,omitempty"`
+	Triggers  []TriggerDefinition  `json:"triggers,omitempty"`
+	Code      string               `json:"code"`
+}
+
+// ScheduleDefinition is a recurring schedule of a Definition. Enabled defaults to true.
+type ScheduleDefinition struct {
+	CronExpression  string          `json:"cron_expression"`
+	Timezone        string          `json:"timezone,omitempty"`
+	Parameters      json.RawMessage `json:"parameters,omitempty"`
+	MissedRunPolicy MissedRunPolicy `json:"missed_run_policy,omitempty"`
+	Enabled         *bool           `json:"enabled,omitempty"`
+}
+
+// TriggerDefinition is a webhook trigger of a Definition. Enabled defaults to true.
+type TriggerDefinition struct {
+	Name       string                 `json:"name,omitempty"`
+	ObjectType string                 `json:"object_type"`
+	Actions    []string               `json:"actions,omitempty"`
+	Filter     map[string]interface{} `json:"filter,omitempty"`
+	Enabled    *bool                  `json:"enabled,omitempty"`
+}
+
+// ImportResult describes what importing a definition changed.
+type ImportResult struct {
+	Workflow         *Workflow `json:"workflow"`
+	Created          bool      `json:"created"`
+	NewRevision      bool      `json:"new_revision"`
+	SchedulesCreated int       `json:"schedules_created"`
+	SchedulesDeleted int       `json:"schedules_deleted"`
+	TriggersCreated  int       `json:"triggers_created"`
+	TriggersDeleted  int       `json:"triggers_deleted"`
+}
+
+// ParseDefinition reads a YAML or JSON definition and validates it. Unknown fields are
+// rejected so that typos do not go unnoticed.
+func ParseDefinition(data []byte) (*Definition, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+	}
+	if _, ok := raw.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%w: expected a mapping at the top level", ErrInvalidDefinition)
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	def := &Definition{}
+	if err := decoder.Decode(def); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+	}
+
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// Validate checks everything that can be checked without a database, including the
+// schedules' parameters against the parameter schema, and fills in the defaults of the
+// execution policy, schedules and triggers.
+func (d *Definition) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidDefinition, fmt.Sprintf(format, args...))
+	}
+
+	if d.Version != DefinitionVersion {
+		return invalid("version must be %q, got %q", DefinitionVersion, d.Version)
+	}
+	if strings.TrimSpace(d.Name) == "" {
+		return invalid("name is required")
+	}
+	switch d.Status {
+	case "", StatusDraft, StatusActive, StatusInactive, StatusArchived:
+	default:
+		return invalid("unknown status %q", d.Status)
+	}
+
+	d.ExecutionPolicy.applyDefaults()
+	if err := d.ExecutionPolicy.Validate(); err != nil {
+		return invalid("%v", err)
+	}
+	if len(d.ParameterSchema) > 0 {
+		if _, err := ParseSchema(d.ParameterSchema); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	if err := ValidateWorkflow(d.workflow()); err != nil {
+		return invalid("%v", err)
+	}
+
+	for i := range d.Schedules {
+		schedule := d.Schedules[i].schedule(0)
+		if err := schedule.Validate(); err != nil {
+			return invalid("schedules[%d]: %v", i, err)
+		}
+		parameters, err := d.workflow().PrepareParameters(schedule.Parameters)
+		if err == nil {
+			_, err = d.ExecutionPolicy.resolveConcurrencyKey(parameters)
+		}
+		if err != nil {
+			return invalid("schedules[%d]: %v", i, err)
+		}
+		d.Schedules[i].Timezone = schedule.Timezone
+		d.Schedules[i].MissedRunPolicy = schedule.MissedRunPolicy
+	}
+	for i := range d.Triggers {
+		trigger := d.Triggers[i].trigger(0)
+		if err := trigger.Validate(); err != nil {
+			return invalid("triggers[%d]: %v", i, err)
+		}
+		d.Triggers[i].ObjectType = trigger.ObjectType
+	}
+	return nil
+}
+
+// workflow returns the workflow fields of the definition.
+func (d *Definition) workflow() *Workflow {
+	return &Workflow{
+		Name:            d.Name,
+		Description:     d.Description,
+		Code:            d.Code,
+		Steps:           d.Steps,
+		ParameterSchema: d.ParameterSchema,
+		ExecutionPolicy: d.ExecutionPolicy,
+	}
+}
+
+func (s *ScheduleDefinition) schedule(workflowID int) *WorkflowSchedule {
+	return &WorkflowSchedule{
+		WorkflowID:      workflowID,
+		CronExpression:  s.CronExpression,
+		Timezone:        s.Timezone,
+		Parameters:      s.Parameters,
+		MissedRunPolicy: s.MissedRunPolicy,
+		Enabled:         s.Enabled == nil || *s.Enabled,
+	}
+}
+
+func (t *TriggerDefinition) trigger(workflowID int) *WorkflowTrigger {
+	return &WorkflowTrigger{
+		WorkflowID: workflowID,
+		Name:       t.Name,
+		ObjectType: t.ObjectType,
+		Actions:    t.Actions,
+		Filter:     t.Filter,
+		Enabled:    t.Enabled == nil || *t.Enabled,
+	}
+}
+
+// sameSchedule reports whether an existing schedule is what a definition asks for, in
+// which case importing keeps it and its queued run.
+func sameSchedule(existing, wanted *WorkflowSchedule) bool {
+	return existing.CronExpression == wanted.CronExpression &&
+		existing.Timezone == wanted.Timezone &&
+		existing.MissedRunPolicy == wanted.MissedRunPolicy &&
+		existing.Enabled == wanted.Enabled &&
+		sameJSON(existing.Parameters, wanted.Parameters)
+}
+
+func sameTrigger(existing, wanted *WorkflowTrigger) bool {
+	return existing.Name == wanted.Name &&
+		existing.ObjectType == wanted.ObjectType &&
+		existing.Enabled == wanted.Enabled &&
+		reflect.DeepEqual(copyJSON(existing.Actions), copyJSON(wanted.Actions)) &&
+		(len(existing.Filter) == 0 && len(wanted.Filter) == 0 ||
+			reflect.DeepEqual(copyJSON(existing.Filter), copyJSON(wanted.Filter)))
+}
+
+// sameJSON compares two JSON documents, treating empty as null.
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if len(a) > 0 {
+		if err := json.Unmarshal(a, &va); err != nil {
+			return false
+		}
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &vb); err != nil {
+			return false
+		}
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// EncodeDefinition renders a definition as "yaml" or "json".
+func EncodeDefinition(def *Definition, format string) ([]byte, error) {
+	encoded, err := json.MarshalIndent(def, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode definition: %w", err)
+	}
+
+	switch format {
+	case "json":
+		return append(encoded, '\n'), nil
+	case "yaml":
+		// Decoding the JSON into a node keeps the field order of Definition.
+		var node yaml.Node
+		if err := yaml.Unmarshal(encoded, &node); err != nil {
+			return nil, fmt.Errorf("failed to convert definition to YAML: %w", err)
+		}
+		resetYAMLStyle(&node)
+
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return nil, fmt.Errorf("failed to encode definition as YAML: %w", err)
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode definition as YAML: %w", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown definition format %q (use yaml or json)", format)
+	}
+}
+
+// resetYAMLStyle drops the JSON quoting and flow style from a node tree and writes
+// multi-line strings, such as the code, as literal blocks.
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" && strings.Contains(node.Value, "\n") {
+		node.Style = yaml.LiteralStyle
+	}
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+// ExportDefinition returns the definition of a workflow with its schedules and triggers.
+func (wm *WorkflowManager) ExportDefinition(workflowID int) (*Definition, error) {
+	workflow, err := wm.GetWorkflow(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := wm.ListSchedules(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	triggers, err := wm.ListTriggers(workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	def := &Definition{
+		Version:         DefinitionVersion,
+		Name:            workflow.Name,
+		Description:     workflow.Description,
+		Status:          workflow.Status,
+		ParameterSchema: workflow.ParameterSchema,
+		ExecutionPolicy: workflow.ExecutionPolicy,
+		Steps:           workflow.Steps,
+		Code:            workflow.Code,
+	}
+	for _, schedule := range schedules {
+		sd := ScheduleDefinition{
+			CronExpression:  schedule.CronExpression,
+			Timezone:        schedule.Timezone,
+			Parameters:      schedule.Parameters,
+			MissedRunPolicy: schedule.MissedRunPolicy,
+		}
+		if !schedule.Enabled {
+			sd.Enabled = &schedule.Enabled
+		}
+		def.Schedules = append(def.Schedules, sd)
+	}
+	for _, trigger := range triggers {
+		td := TriggerDefinition{
+			Name:       trigger.Name,
+			ObjectType: trigger.ObjectType,
+			Actions:    trigger.Actions,
+			Filter:     trigger.Filter,
+		}
+		if !trigger.Enabled {
+			td.Enabled = &trigger.Enabled
+		}
+		def.Triggers = append(def.Triggers, td)
+	}
+	return def, nil
+}
+
+// findWorkflowByName returns the ID of the workflow with the given name, or 0 if there
+// is none.
+func (wm *WorkflowManager) findWorkflowByName(name string) (int, error) {
+	rows, err := wm.db.Query(`SELECT id FROM workflows WHERE name = $1`, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up workflow: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, fmt.Errorf("failed to scan workflow ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating workflows: %w", err)
+	}
+
+	switch len(ids) {
+	case 0:
+		return 0, nil
+	case 1:
+		return ids[0], nil
+	default:
+		return 0, fmt.Errorf("%w: %q (IDs %v)", ErrAmbiguousWorkflow, name, ids)
+	}
+}
+
+// ImportDefinition creates or updates the workflow named by a validated definition and
+// makes its schedules and triggers match the definition. Schedules and triggers that
+// are already as defined are left alone, so importing the same definition again changes
+// nothing. A definition without a status keeps the status of an existing workflow. The
+// import runs in a single transaction, so a definition that fails part way changes
+// nothing.
+func (wm *WorkflowManager) ImportDefinition(def *Definition) (*ImportResult, error) {
+	id, err := wm.findWorkflowByName(def.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{}
+	cancelled := 0
+	var workflow *Workflow
+	if id == 0 {
+		workflow = def.workflow()
+		if def.Status != "" {
+			workflow.Status = def.Status
+			if err := checkStatusChange(StatusDraft, workflow); err != nil {
+				return nil, err
+			}
+		}
+		if err := insertWorkflow(tx, workflow); err != nil {
+			return nil, err
+		}
+		result.Created = true
+		result.NewRevision = true
+		if def.Status != "" && def.Status != workflow.Status {
+			workflow.Status = def.Status
+			if _, err := updateWorkflow(tx, workflow); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		workflow, err = wm.GetWorkflow(id)
+		if err != nil {
+			return nil, err
+		}
+		revision := workflow.Revision
+		workflow.Description = def.Description
+		workflow.Code = def.Code
+		workflow.Steps = def.Steps
+		workflow.ParameterSchema = def.ParameterSchema
+		workflow.ExecutionPolicy = def.ExecutionPolicy
+		if def.Status != "" {
+			workflow.Status = def.Status
+		}
+		cancelled, err = updateWorkflow(tx, workflow)
+		if err != nil {
+			return nil, err
+		}
+		result.NewRevision = workflow.Revision != revision
+	}
+	result.Workflow = workflow
+
+	if err := wm.syncSchedules(tx, workflow, def.Schedules, result); err != nil {
+		return nil, err
+	}
+	if err := wm.syncTriggers(tx, workflow.ID, def.Triggers, result); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+
+	if cancelled > 0 {
+		logger.Info("Cancelled %d pending execution(s) of deactivated workflow %d", cancelled, workflow.ID)
+	}
+	return result, nil
+}
+
+func (wm *WorkflowManager) syncSchedules(tx *sql.Tx, workflow *Workflow, wanted []ScheduleDefinition, result *ImportResult) error {
+	workflowID := workflow.ID
+	existing, err := wm.ListSchedules(workflowID)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[int]bool)
+	create := []*WorkflowSchedule{}
+	for i := range wanted {
+		schedule := wanted[i].schedule(workflowID)
+		if err := schedule.Validate(); err != nil {
+			return err
+		}
+		found := false
+		for _, current := range existing {
+			if !keep[current.ID] && sameSchedule(current, schedule) {
+				keep[current.ID] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			create = append(create, schedule)
+		}
+	}
+
+	for _, current := range existing {
+		if keep[current.ID] {
+			continue
+		}
+		if err := deleteSchedule(tx, workflowID, current.ID); err != nil {
+			return err
+		}
+		result.SchedulesDeleted++
+	}
+	for _, schedule := range create {
+		if err := wm.createSchedule(tx, workflow, schedule); err != nil {
+			return err
+		}
+		result.SchedulesCreated++
+	}
+	return nil
+}
+
+func (wm *WorkflowManager) syncTriggers(tx *sql.Tx, workflowID int, wanted []TriggerDefinition, result *ImportResult) error {
+	existing, err := wm.ListTriggers(workflowID)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[int]bool)
+	create := []*WorkflowTrigger{}
+	for i := range wanted {
+		trigger := wanted[i].trigger(workflowID)
+		if err := trigger.Validate(); err != nil {
+			return err
+		}
+		found := false
+		for _, current := range existing {
+			if !keep[current.ID] && sameTrigger(current, trigger) {
+				keep[current.ID] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			create = append(create, trigger)
+		}
+	}
+
+	for _, current := range existing {
+		if keep[current.ID] {
+			continue
+		}
+		if err := deleteTrigger(tx, workflowID, current.ID); err != nil {
+			return err
+		}
+		result.TriggersDeleted++
+	}
+	for _, trigger := range create {
+		if err := createTrigger(tx, trigger); err != nil {
+			return err
+		}
+		result.TriggersCreated++
+	}
+	return nil
+}
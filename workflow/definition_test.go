@@ -0,0 +1,162 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDefinition = `
+version: holonet/v1
+name: Provision interface
+description: Allocates an address and creates the interface
+status: active
+max_attempts: 3
+parameter_schema:
+  type: object
+  required: [device]
+  properties:
+    device: {type: string}
+    vlan: {type: string, default: "100"}
+steps:
+  - name: allocate
+    function: Allocate
+  - name: create
+    function: Create
+    depends_on: [allocate]
+    inputs:
+      device: ${params.device}
+schedules:
+  - cron_expression: "0 3 * * *"
+    parameters: {device: edge-01}
+  - cron_expression: "@hourly"
+    parameters: {device: edge-02, vlan: "200"}
+    enabled: false
+triggers:
+  - object_type: DCIM.Device
+    actions: [created]
+    filter:
+      data.status.value: active
+code: |
+  package main
+
+  func Allocate(in map[string]interface{}) (interface{}, error) { return nil, nil }
+
+  func Create(in map[string]interface{}) (interface{}, error) { return nil, nil }
+`
+
+func TestParseDefinition(t *testing.T) {
+	def, err := ParseDefinition([]byte(testDefinition))
+	if err != nil {
+		t.Fatalf("Expected definition to be valid, got %v", err)
+	}
+	if def.Name != "Provision interface" || def.Status != StatusActive {
+		t.Errorf("Expected name and status to be read, got %q and %q", def.Name, def.Status)
+	}
+	if def.MaxAttempts != 3 || def.MaxRuntimeSeconds != defaultMaxRuntimeSeconds {
+		t.Errorf("Expected max_attempts 3 and the default runtime, got %+v", def.ExecutionPolicy)
+	}
+	if len(def.Steps) != 2 || def.Steps[1].Inputs["device"] != "${params.device}" {
+		t.Errorf("Expected steps to be read, got %+v", def.Steps)
+	}
+	if def.Schedules[0].Timezone != "UTC" || def.Schedules[0].MissedRunPolicy != MissedRunSkip {
+		t.Errorf("Expected schedule defaults to be filled in, got %+v", def.Schedules[0])
+	}
+	if def.Triggers[0].ObjectType != "dcim.device" {
+		t.Errorf("Expected trigger object type to be normalized, got %q", def.Triggers[0].ObjectType)
+	}
+}
+
+func TestParseDefinitionRejectsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":     strings.Replace(testDefinition, "max_attempts: 3", "max_attempt: 3", 1),
+		"missing version":   strings.Replace(testDefinition, "version: holonet/v1", "", 1),
+		"unknown function":  strings.Replace(testDefinition, "function: Create", "function: Missing", 1),
+		"invalid cron":      strings.Replace(testDefinition, `"0 3 * * *"`, `"0 25 * * *"`, 1),
+		"invalid trigger":   strings.Replace(testDefinition, "actions: [created]", "actions: [changed]", 1),
+		"invalid status":    strings.Replace(testDefinition, "status: active", "status: enabled", 1),
+		"not a mapping":     "- name: x",
+		"invalid YAML":      "name: [",
+		"schedule params":   strings.Replace(testDefinition, "parameters: {device: edge-01}", "parameters: {device: 1}", 1),
+		"schema not object": strings.Replace(testDefinition, "type: object", "type: string", 1),
+	}
+	for name, source := range tests {
+		if _, err := ParseDefinition([]byte(source)); !errors.Is(err, ErrInvalidDefinition) {
+			t.Errorf("%s: expected ErrInvalidDefinition, got %v", name, err)
+		}
+	}
+}
+
+func TestDefinitionRoundTrip(t *testing.T) {
+	def, err := ParseDefinition([]byte(testDefinition))
+	if err != nil {
+		t.Fatalf("Failed to parse definition: %v", err)
+	}
+
+	for _, format := range []string{"yaml", "json"} {
+		encoded, err := EncodeDefinition(def, format)
+		if err != nil {
+			t.Fatalf("Failed to encode definition as %s: %v", format, err)
+		}
+		parsed, err := ParseDefinition(encoded)
+		if err != nil {
+			t.Fatalf("Failed to parse %s export: %v\n%s", format, err, encoded)
+		}
+
+		want, _ := json.Marshal(def)
+		got, _ := json.Marshal(parsed)
+		var wantValue, gotValue interface{}
+		json.Unmarshal(want, &wantValue)
+		json.Unmarshal(got, &gotValue)
+		if !reflect.DeepEqual(wantValue, gotValue) {
+			t.Errorf("Expected %s round trip to keep the definition, got\n%s\nwant\n%s", format, got, want)
+		}
+	}
+}
+
+func TestEncodeDefinitionYAML(t *testing.T) {
+	def, err := ParseDefinition([]byte(testDefinition))
+	if err != nil {
+		t.Fatalf("Failed to parse definition: %v", err)
+	}
+	encoded, err := EncodeDefinition(def, "yaml")
+	if err != nil {
+		t.Fatalf("Failed to encode definition: %v", err)
+	}
+	yaml := string(encoded)
+
+	if !strings.HasPrefix(yaml, "version: holonet/v1\nname: Provision interface\n") {
+		t.Errorf("Expected fields in definition order, got\n%s", yaml)
+	}
+	if !strings.Contains(yaml, "code: |") {
+		t.Errorf("Expected code as a literal block, got\n%s", yaml)
+	}
+	if !strings.Contains(yaml, `default: "100"`) {
+		t.Errorf("Expected numeric-looking strings to stay quoted, got\n%s", yaml)
+	}
+
+	if _, err := EncodeDefinition(def, "xml"); err == nil {
+		t.Error("Expected unknown format to be rejected")
+	}
+}
+
+func TestSameSchedule(t *testing.T) {
+	existing := &WorkflowSchedule{CronExpression: "@daily", Timezone: "UTC", MissedRunPolicy: MissedRunSkip, Enabled: true,
+		Parameters: json.RawMessage(`{"a": 1, "b": [true]}`)}
+	wanted := &WorkflowSchedule{CronExpression: "@daily", Timezone: "UTC", MissedRunPolicy: MissedRunSkip, Enabled: true,
+		Parameters: json.RawMessage(`{"b":[true],"a":1.0}`)}
+	if !sameSchedule(existing, wanted) {
+		t.Error("Expected schedules with equivalent parameters to be the same")
+	}
+
+	wanted.Enabled = false
+	if sameSchedule(existing, wanted) {
+		t.Error("Expected a disabled schedule to differ from an enabled one")
+	}
+
+	if !sameJSON(nil, json.RawMessage("null")) {
+		t.Error("Expected missing parameters to equal null")
+	}
+}
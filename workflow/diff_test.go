@@ -0,0 +1,42 @@
+package workflow
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	a := splitLines("package main\n\nfunc main() {\n\tprintln(\"v1\")\n}\n")
+	b := splitLines("package main\n\nfunc main() {\n\tprintln(\"v2\")\n\tprintln(\"done\")\n}\n")
+
+	expected := "--- revision 1\n+++ revision 2\n" +
+		"@@ -1,5 +1,6 @@\n" +
+		" package main\n" +
+		" \n" +
+		" func main() {\n" +
+		"-\tprintln(\"v1\")\n" +
+		"+\tprintln(\"v2\")\n" +
+		"+\tprintln(\"done\")\n" +
+		" }\n"
+
+	if diff := unifiedDiff("revision 1", "revision 2", a, b, diffContextLines); diff != expected {
+		t.Errorf("Expected diff:\n%s\ngot:\n%s", expected, diff)
+	}
+}
+
+func TestUnifiedDiffSeparateHunks(t *testing.T) {
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	b := []string{"one", "2", "3", "4", "5", "6", "7", "8", "9", "ten"}
+
+	expected := "--- a\n+++ b\n" +
+		"@@ -1,2 +1,2 @@\n-1\n+one\n 2\n" +
+		"@@ -9,2 +9,2 @@\n 9\n-10\n+ten\n"
+
+	if diff := unifiedDiff("a", "b", a, b, 1); diff != expected {
+		t.Errorf("Expected diff:\n%s\ngot:\n%s", expected, diff)
+	}
+}
+
+func TestUnifiedDiffEqual(t *testing.T) {
+	lines := []string{"package main", "func main() {}"}
+	if diff := unifiedDiff("a", "b", lines, lines, diffContextLines); diff != "" {
+		t.Errorf("Expected empty diff, got %q", diff)
+	}
+}
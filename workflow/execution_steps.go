@@ -0,0 +1,353 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/logger"
+)
+
+type StepStatus string
+
+const (
+	StepPending   StepStatus = "pending"
+	StepRunning   StepStatus = "running"
+	StepCompleted StepStatus = "completed"
+	StepFailed    StepStatus = "failed"
+	StepSkipped   StepStatus = "skipped"
+)
+
+var ErrExecutionNotResumable = errors.New("only failed or cancelled executions can be resumed")
+
+// ExecutionStep is the persisted state of one step of a multi-step execution.
+type ExecutionStep struct {
+	ID           int             `json:"id"`
+	ExecutionID  int             `json:"execution_id"`
+	Name         string          `json:"name"`
+	Status       StepStatus      `json:"status"`
+	Input        json.RawMessage `json:"input,omitempty"`
+	Output       json.RawMessage `json:"output,omitempty"`
+	ErrorMessage string          `json:"error_message,omitempty"`
+	StartedAt    time.Time       `json:"started_at"`
+	CompletedAt  time.Time       `json:"completed_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+const executionStepColumns = `id, execution_id, name, status, input, output, error_message, started_at, completed_at, updated_at`
+
+func scanExecutionStep(row rowScanner) (*ExecutionStep, error) {
+	step := &ExecutionStep{}
+	var input, output []byte
+	var errorMessage sql.NullString
+	var startedAt, completedAt sql.NullTime
+
+	err := row.Scan(
+		&step.ID,
+		&step.ExecutionID,
+		&step.Name,
+		&step.Status,
+		&input,
+		&output,
+		&errorMessage,
+		&startedAt,
+		&completedAt,
+		&step.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(input) > 0 {
+		step.Input = json.RawMessage(input)
+	}
+	if len(output) > 0 {
+		step.Output = json.RawMessage(output)
+	}
+	step.ErrorMessage = errorMessage.String
+	step.StartedAt = startedAt.Time
+	step.CompletedAt = completedAt.Time
+	return step, nil
+}
+
+func (wm *WorkflowManager) ListExecutionSteps(executionID int) ([]*ExecutionStep, error) {
+	query := `
+		SELECT ` + executionStepColumns + `
+		FROM workflow_execution_steps
+		WHERE execution_id = $1
+		ORDER BY id
+	`
+
+	rows, err := wm.db.Query(query, executionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list execution steps: %w", err)
+	}
+	defer rows.Close()
+
+	steps := []*ExecutionStep{}
+	for rows.Next() {
+		step, err := scanExecutionStep(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan execution step: %w", err)
+		}
+		steps = append(steps, step)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating execution steps: %w", err)
+	}
+
+	return steps, nil
+}
+
+// prepareExecutionSteps creates a pending row for every step that the execution has no
+// state for yet and returns the state of all steps by name.
+func (wm *WorkflowManager) prepareExecutionSteps(executionID int, steps []Step) (map[string]*ExecutionStep, error) {
+	query := `
+		INSERT INTO workflow_execution_steps (execution_id, name, status, created_at, updated_at)
+		SELECT $1, $2, $3, NOW(), NOW()
+		WHERE NOT EXISTS (SELECT 1 FROM workflow_execution_steps WHERE execution_id = $1 AND name = $2)
+	`
+	for _, step := range steps {
+		if _, err := wm.db.Exec(query, executionID, step.Name, StepPending); err != nil {
+			return nil, fmt.Errorf("failed to create state of step %s: %w", step.Name, err)
+		}
+	}
+
+	stored, err := wm.ListExecutionSteps(executionID)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*ExecutionStep, len(stored))
+	for _, step := range stored {
+		byName[step.Name] = step
+	}
+	return byName, nil
+}
+
+func (wm *WorkflowManager) saveExecutionStep(step *ExecutionStep) error {
+	query := `
+		UPDATE workflow_execution_steps
+		SET status = $1, input = $2, output = $3, error_message = $4, started_at = $5, completed_at = $6, updated_at = NOW()
+		WHERE id = $7
+		RETURNING updated_at
+	`
+
+	err := wm.db.QueryRow(
+		query,
+		step.Status,
+		step.Input,
+		step.Output,
+		step.ErrorMessage,
+		nullTime(step.StartedAt),
+		nullTime(step.CompletedAt),
+		step.ID,
+	).Scan(&step.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save state of step %s: %w", step.Name, err)
+	}
+	return nil
+}
+
+// ResumeExecution puts a failed or cancelled execution back to pending as a new attempt.
+// Steps that already completed keep their output and are not run again; compensated
+// steps are run again. Apply executions cannot be resumed; promote a new dry run instead.
+func (wm *WorkflowManager) ResumeExecution(id int) (*WorkflowExecution, error) {
+	query := `
+		UPDATE workflow_executions
+		SET status = $1, attempt = attempt + 1, error_message = NULL, scheduled_at = NOW(), started_at = NULL,
+		    completed_at = NULL, cancel_requested_at = NULL, compensations = NULL, updated_at = NOW()
+		WHERE id = $2 AND status IN ($3, $4) AND mode <> $5
+		RETURNING ` + executionColumns
+
+	execution, err := scanExecution(wm.db.QueryRow(query, ExecutionPending, id, ExecutionFailed, ExecutionCancelled, ModeApply))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to resume execution: %w", err)
+		}
+		if _, err := wm.GetExecution(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrExecutionNotResumable
+	}
+
+	logger.Info("Resumed execution %d as attempt %d", id, execution.Attempt)
+	return execution, nil
+}
+
+// runSteps walks the steps of a multi-step workflow in dependency order. Steps completed
+// by an earlier attempt are not run again; their stored output is used instead. The
+// output of the execution is what the code passed to SetResult or, if nothing was, the
+// outputs of all completed steps by name. Dry runs run all steps again, so that the plan
+// holds the changes of every step.
+func (e *Executor) runSteps(ctx context.Context, workflow *Workflow, execution *WorkflowExecution) (*RunOutput, error) {
+	if err := ValidateWorkflow(workflow); err != nil {
+		return &RunOutput{}, err
+	}
+
+	ordered, err := orderSteps(workflow.Steps)
+	if err != nil {
+		return &RunOutput{}, err
+	}
+
+	params, err := decodeParameters(execution.Parameters)
+	if err != nil {
+		return &RunOutput{}, err
+	}
+
+	states, err := e.manager.prepareExecutionSteps(execution.ID, ordered)
+	if err != nil {
+		return &RunOutput{}, err
+	}
+
+	scope := stepScope{params: params, outputs: map[string]interface{}{}, redacted: map[string]bool{}}
+	for _, step := range ordered {
+		state := states[step.Name]
+		if execution.Mode == ModeDryRun {
+			state.Status = StepPending
+		}
+		if state.Status == StepCompleted && len(state.Output) > 0 {
+			var output interface{}
+			if err := json.Unmarshal(state.Output, &output); err != nil {
+				return &RunOutput{}, fmt.Errorf("failed to decode stored output of step %s: %w", step.Name, err)
+			}
+			scope.outputs[step.Name] = output
+			scope.redacted[step.Name] = strings.Contains(string(state.Output), redactedValue)
+		}
+	}
+
+	p, err := e.runtime.load(ctx, workflow, execution, params)
+	if err != nil {
+		return p.output(), err
+	}
+
+	for _, step := range ordered {
+		state := states[step.Name]
+		if state.Status == StepCompleted {
+			continue
+		}
+
+		if err := e.runStep(ctx, p, execution, step, state, states, scope); err != nil {
+			result := p.output()
+			if result.Output == nil {
+				result.Output = scope.outputs
+			}
+			return result, fmt.Errorf("step %s failed: %w", step.Name, err)
+		}
+	}
+
+	result := p.output()
+	if result.Output == nil {
+		result.Output = scope.outputs
+	}
+	return result, nil
+}
+
+func (e *Executor) runStep(ctx context.Context, p *program, execution *WorkflowExecution, step Step, state *ExecutionStep, states map[string]*ExecutionStep, scope stepScope) error {
+	state.Input = nil
+	state.Output = nil
+	state.ErrorMessage = ""
+	state.StartedAt = time.Time{}
+	state.CompletedAt = time.Time{}
+
+	skip := func(reason string) error {
+		logger.Info("Skipping step %s of execution %d: %s", step.Name, state.ExecutionID, reason)
+		e.logf(state.ExecutionID, "Skipped step %s: %s", step.Name, reason)
+		state.Status = StepSkipped
+		state.ErrorMessage = reason
+		state.CompletedAt = time.Now()
+		return e.manager.saveExecutionStep(state)
+	}
+
+	for _, dep := range step.DependsOn {
+		if states[dep].Status == StepSkipped {
+			return skip(fmt.Sprintf("dependency %s was skipped", dep))
+		}
+	}
+
+	if step.When != "" {
+		ok, err := evaluateCondition(step.When, scope)
+		if err != nil {
+			return e.failStep(state, err)
+		}
+		if !ok {
+			return skip("condition " + step.When + " is false")
+		}
+	}
+
+	input, err := scope.resolveInputs(step.Inputs)
+	if err != nil {
+		return e.failStep(state, err)
+	}
+	if state.Input, err = json.Marshal(input); err != nil {
+		return e.failStep(state, fmt.Errorf("failed to encode input: %w", err))
+	}
+
+	state.Status = StepRunning
+	state.StartedAt = time.Now()
+	if err := e.manager.saveExecutionStep(state); err != nil {
+		return err
+	}
+
+	var output interface{}
+	if step.Approval != nil && execution.Mode == ModeDryRun {
+		// The approval is given by promoting the plan.
+		e.logf(state.ExecutionID, "Step %s needs approval when the plan is promoted", step.Name)
+		output = map[string]interface{}{"dry_run": true}
+	} else if step.Approval != nil {
+		output, err = e.runApprovalStep(step, state, execution)
+		if errors.Is(err, errAwaitingApproval) {
+			return err
+		}
+	} else if step.Workflow != nil {
+		output, err = e.runWorkflowStep(step, state, execution)
+		if errors.Is(err, errAwaitingWorkflow) {
+			return err
+		}
+	} else {
+		logger.Info("Running step %s of execution %d", step.Name, state.ExecutionID)
+		e.logf(state.ExecutionID, "Running step %s", step.Name)
+		p.host.enterScope("step:" + step.Name)
+		output, err = p.call(ctx, step.Function, input)
+	}
+	if err != nil {
+		return e.failStep(state, err)
+	}
+
+	normalized, err := normalizeJSON(output)
+	if err != nil {
+		return e.failStep(state, fmt.Errorf("step output is not JSON serializable: %w", err))
+	}
+	if state.Output, err = json.Marshal(normalized); err != nil {
+		return e.failStep(state, fmt.Errorf("failed to encode output: %w", err))
+	}
+	state.Output = e.manager.logs.redactJSON(state.ExecutionID, state.Output)
+
+	state.Status = StepCompleted
+	state.CompletedAt = time.Now()
+	if err := e.manager.saveExecutionStep(state); err != nil {
+		return err
+	}
+
+	e.logf(state.ExecutionID, "Completed step %s", step.Name)
+	scope.outputs[step.Name] = normalized
+	return nil
+}
+
+// failStep records err on the step and returns it.
+func (e *Executor) failStep(state *ExecutionStep, err error) error {
+	err = e.manager.logs.redactError(state.ExecutionID, err)
+	state.Status = StepFailed
+	state.ErrorMessage = err.Error()
+	state.CompletedAt = time.Now()
+	e.logf(state.ExecutionID, "Step %s failed: %v", state.Name, err)
+	if saveErr := e.manager.saveExecutionStep(state); saveErr != nil {
+		logger.Error("Failed to record failure of step %s: %v", state.Name, saveErr)
+	}
+	return err
+}
@@ -0,0 +1,223 @@
+package workflow
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/logger"
+)
+
+var (
+	ErrExecutionNotFound = errors.New("execution not found")
+	ErrExecutionFinished = errors.New("execution already finished")
+	// ErrCancelRequested is returned to the worker running an execution once a user
+	// asked for it to be cancelled.
+	ErrCancelRequested = errors.New("execution cancellation requested")
+)
+
+const (
+	defaultExecutionListLimit = 50
+	maxExecutionListLimit     = 500
+)
+
+type ExecutionFilter struct {
+	WorkflowID        int
+	ParentExecutionID int
+	Status            ExecutionStatus
+	// From and To bound scheduled_at; zero values are ignored.
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+func (s ExecutionStatus) IsFinal() bool {
+	return s == ExecutionCompleted || s == ExecutionFailed || s == ExecutionCancelled
+}
+
+func (wm *WorkflowManager) ListExecutions(filter ExecutionFilter) ([]*WorkflowExecution, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.WorkflowID != 0 {
+		addCondition("workflow_id = $%d", filter.WorkflowID)
+	}
+	if filter.ParentExecutionID != 0 {
+		addCondition("parent_execution_id = $%d", filter.ParentExecutionID)
+	}
+	if filter.Status != "" {
+		addCondition("status = $%d", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		addCondition("scheduled_at >= $%d", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		addCondition("scheduled_at <= $%d", filter.To.UTC())
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultExecutionListLimit
+	}
+	if limit > maxExecutionListLimit {
+		limit = maxExecutionListLimit
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, limit, filter.Offset)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM workflow_executions
+		%s
+		ORDER BY scheduled_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, executionColumns, where, len(args)-1, len(args))
+
+	rows, err := wm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list executions: %w", err)
+	}
+	defer rows.Close()
+
+	executions := []*WorkflowExecution{}
+	for rows.Next() {
+		execution, err := scanExecution(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan execution: %w", err)
+		}
+		executions = append(executions, execution)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating executions: %w", err)
+	}
+
+	return executions, nil
+}
+
+func (wm *WorkflowManager) GetExecution(id int) (*WorkflowExecution, error) {
+	query := `
+		SELECT ` + executionColumns + `
+		FROM workflow_executions
+		WHERE id = $1
+	`
+
+	execution, err := scanExecution(wm.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrExecutionNotFound
+		}
+		return nil, fmt.Errorf("failed to get execution: %w", err)
+	}
+
+	return execution, nil
+}
+
+// CancelExecution cancels a pending execution right away. For a running execution it
+// records the request; the worker running it picks that up on its next lease renewal
+// and cancels the run's context. An execution waiting for approval or for a sub-workflow
+// has its approval withdrawn and is handed to a worker, which compensates its completed
+// steps. The unfinished sub-workflow executions below it are cancelled too.
+func (wm *WorkflowManager) CancelExecution(id int) (*WorkflowExecution, error) {
+	execution, err := wm.cancelExecution(id)
+	if err != nil {
+		return nil, err
+	}
+
+	descendants, err := wm.unfinishedDescendants(id)
+	if err != nil {
+		logger.Error("Failed to cancel sub-workflows of execution %d: %v", id, err)
+		return execution, nil
+	}
+	for _, childID := range descendants {
+		if _, err := wm.cancelExecution(childID); err != nil && !errors.Is(err, ErrExecutionFinished) {
+			logger.Error("Failed to cancel sub-workflow execution %d: %v", childID, err)
+		}
+	}
+	return execution, nil
+}
+
+func (wm *WorkflowManager) cancelExecution(id int) (*WorkflowExecution, error) {
+	pendingQuery := `
+		UPDATE workflow_executions
+		SET status = $1, error_message = $2, cancel_requested_at = NOW(), completed_at = NOW(), updated_at = NOW()
+		WHERE id = $3 AND status = $4
+		RETURNING ` + executionColumns
+
+	execution, err := scanExecution(wm.db.QueryRow(pendingQuery, ExecutionCancelled, "Cancelled by user", id, ExecutionPending))
+	if err == nil {
+		logger.Info("Cancelled pending execution %d", id)
+		return execution, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to cancel execution: %w", err)
+	}
+
+	execution, err = wm.cancelWaitingExecution(id)
+	if err == nil {
+		logger.Info("Cancelled waiting execution %d", id)
+		return execution, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to cancel execution: %w", err)
+	}
+
+	runningQuery := `
+		UPDATE workflow_executions
+		SET cancel_requested_at = COALESCE(cancel_requested_at, NOW()), updated_at = NOW()
+		WHERE id = $1 AND status = $2
+		RETURNING ` + executionColumns
+
+	execution, err = scanExecution(wm.db.QueryRow(runningQuery, id, ExecutionRunning))
+	if err == nil {
+		logger.Info("Requested cancellation of running execution %d", id)
+		return execution, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to cancel execution: %w", err)
+	}
+
+	if _, err := wm.GetExecution(id); err != nil {
+		return nil, err
+	}
+	return nil, ErrExecutionFinished
+}
+
+func (wm *WorkflowManager) cancelWaitingExecution(id int) (*WorkflowExecution, error) {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE workflow_executions
+		SET status = $1, cancel_requested_at = NOW(), scheduled_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND status IN ($3, $4)
+		RETURNING ` + executionColumns
+
+	execution, err := scanExecution(tx.QueryRow(query, ExecutionPending, id, ExecutionWaitingApproval, ExecutionWaitingWorkflow))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cancelPendingApprovals(tx, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return execution, nil
+}
@@ -3,24 +3,45 @@ package workflow
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/holonet/core/logger"
 )
 
+const (
+	executionLease     = 30 * time.Second
+	leaseRenewInterval = 5 * time.Second
+	claimBatchSize     = 10
+)
+
 type Executor struct {
-	manager *WorkflowManager
+	manager  *WorkflowManager
+	runtime  *Runtime
+	workerID string
+	// maxWorkers bounds the executions this executor runs at once; active counts them.
+	maxWorkers int
+	active     atomic.Int32
+	// wake makes the execution loop claim again as soon as a worker frees up.
+	wake chan struct{}
 }
 
 func NewExecutor(manager *WorkflowManager) *Executor {
+	runtime := NewRuntime()
+	runtime.logs = manager.logs
 	return &Executor{
-		manager: manager,
+		manager:    manager,
+		runtime:    runtime,
+		workerID:   newWorkerID(),
+		maxWorkers: workerPoolSize(),
+		wake:       make(chan struct{}, 1),
 	}
 }
 
 func (e *Executor) StartExecutionLoop(ctx context.Context) {
-	logger.Info("Starting workflow execution loop")
+	logger.Info("Starting workflow execution loop as worker %s with %d workers", e.workerID, e.maxWorkers)
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -30,53 +51,133 @@ func (e *Executor) StartExecutionLoop(ctx context.Context) {
 			logger.Info("Stopping workflow execution loop")
 			return
 		case <-ticker.C:
-			if err := e.processWorkflows(); err != nil {
-				logger.Error("Error processing workflows: %v", err)
-			}
+		case <-e.wake:
+		}
+		if err := e.processWorkflows(ctx); err != nil {
+			logger.Error("Error processing workflows: %v", err)
 		}
 	}
 }
 
-func (e *Executor) processWorkflows() error {
-	executions, err := e.manager.GetPendingExecutions()
+func (e *Executor) processWorkflows(ctx context.Context) error {
+	if _, err := e.manager.RecoverExpiredExecutions(executionLease); err != nil {
+		logger.Error("Failed to recover expired executions: %v", err)
+	}
+	if _, err := e.manager.ExpireApprovals(); err != nil {
+		logger.Error("Failed to expire approvals: %v", err)
+	}
+	if _, err := e.manager.WakeWaitingParents(); err != nil {
+		logger.Error("Failed to wake executions waiting for sub-workflows: %v", err)
+	}
+
+	free := e.maxWorkers - int(e.active.Load())
+	if free <= 0 {
+		return nil
+	}
+	executions, err := e.manager.ClaimPendingExecutions(e.workerID, min(free, claimBatchSize), executionLease)
 	if err != nil {
-		return fmt.Errorf("failed to get pending executions: %w", err)
+		return fmt.Errorf("failed to claim pending executions: %w", err)
 	}
 
 	for _, execution := range executions {
-		go e.executeWorkflow(execution)
+		e.active.Add(1)
+		go func(execution *WorkflowExecution) {
+			defer e.release()
+			e.executeWorkflow(ctx, execution)
+		}(execution)
 	}
 
 	return nil
 }
 
-func (e *Executor) executeWorkflow(execution *WorkflowExecution) {
-	logger.Info("Executing workflow %d (execution %d)", execution.WorkflowID, execution.ID)
-	execution.Status = ExecutionRunning
-	execution.StartedAt = time.Now()
-	if err := e.manager.UpdateExecution(execution); err != nil {
-		logger.Error("Failed to update execution status to running: %v", err)
-		return
+// release frees the worker of a finished execution and wakes the execution loop, which
+// may now start an execution that was waiting for the worker or its concurrency limit.
+func (e *Executor) release() {
+	e.active.Add(-1)
+	select {
+	case e.wake <- struct{}{}:
+	default:
 	}
+}
+
+func (e *Executor) executeWorkflow(ctx context.Context, execution *WorkflowExecution) {
+	logger.Info("Executing workflow %d (execution %d)", execution.WorkflowID, execution.ID)
+	e.manager.logs.start(execution.ID, execution.Attempt)
+	defer e.manager.logs.finish(execution.ID)
+	e.logf(execution.ID, "Attempt %d started on worker %s", execution.Attempt, e.workerID)
+
+	// The lease is kept until the execution is finished, including compensations that
+	// run after the run itself was cancelled.
+	leaseCtx, stopLease := context.WithCancel(ctx)
+	defer stopLease()
+	runCtx, cancel := context.WithCancelCause(leaseCtx)
+	defer cancel(nil)
+	go e.keepLease(leaseCtx, cancel, execution)
 
-	workflow, err := e.manager.GetWorkflow(execution.WorkflowID)
+	workflow, err := e.manager.workflowForExecution(execution)
 	if err != nil {
 		logger.Error("Failed to get workflow %d: %v", execution.WorkflowID, err)
 		e.markExecutionFailed(execution, fmt.Sprintf("Failed to get workflow: %v", err))
 		return
 	}
 
-	result, err := e.runWorkflowCode(workflow, execution.Parameters)
+	// Cancellation of an execution waiting for approval is requested while it is not
+	// running; it is carried out here so that completed steps are compensated.
+	if !execution.CancelRequestedAt.IsZero() {
+		logger.Info("Workflow %d (execution %d) cancelled", execution.WorkflowID, execution.ID)
+		e.compensate(leaseCtx, workflow, execution)
+		e.markExecutionCancelled(execution)
+		return
+	}
+
+	attemptCtx, cancelAttempt := context.WithTimeoutCause(runCtx, workflow.MaxRuntime(), ErrExecutionTimedOut)
+	defer cancelAttempt()
+
+	result, err := e.runWorkflowCode(attemptCtx, workflow, execution)
+	execution.Result = e.manager.logs.redactJSON(execution.ID, result)
+	err = e.manager.logs.redactError(execution.ID, err)
 	if err != nil {
-		logger.Error("Failed to execute workflow %d: %v", execution.WorkflowID, err)
-		e.markExecutionFailed(execution, fmt.Sprintf("Execution error: %v", err))
+		switch cause := context.Cause(attemptCtx); {
+		case errors.Is(cause, ErrCancelRequested):
+			logger.Info("Workflow %d (execution %d) cancelled", execution.WorkflowID, execution.ID)
+			e.compensate(leaseCtx, workflow, execution)
+			e.markExecutionCancelled(execution)
+		case errors.Is(cause, ErrLeaseLost):
+			logger.Warn("Workflow %d (execution %d) stopped after losing its lease", execution.WorkflowID, execution.ID)
+			e.logf(execution.ID, "Attempt %d stopped after losing its lease", execution.Attempt)
+		case errors.Is(cause, ErrExecutionTimedOut):
+			logger.Error("Workflow %d (execution %d) exceeded its max runtime of %s", execution.WorkflowID, execution.ID, workflow.MaxRuntime())
+			e.failOrRetry(leaseCtx, execution, workflow, fmt.Sprintf("Execution timed out after %s", workflow.MaxRuntime()))
+		case errors.Is(err, errAwaitingApproval):
+			if err := e.manager.suspendForApproval(execution, e.workerID); err != nil {
+				logger.Error("Failed to suspend execution %d for approval: %v", execution.ID, err)
+				return
+			}
+			logger.Info("Workflow %d (execution %d) is waiting for approval", execution.WorkflowID, execution.ID)
+		case errors.Is(err, errAwaitingWorkflow):
+			if err := e.manager.suspendForWorkflow(execution, e.workerID); err != nil {
+				logger.Error("Failed to suspend execution %d for sub-workflow: %v", execution.ID, err)
+				return
+			}
+			logger.Info("Workflow %d (execution %d) is waiting for a sub-workflow", execution.WorkflowID, execution.ID)
+		case errors.Is(err, ErrApprovalRejected):
+			logger.Info("Workflow %d (execution %d) was not approved: %v", execution.WorkflowID, execution.ID, err)
+			errorMessage := fmt.Sprintf("Execution error: %v", err)
+			if !e.compensate(leaseCtx, workflow, execution) {
+				errorMessage += "; compensation incomplete"
+			}
+			e.markExecutionFailed(execution, errorMessage)
+		default:
+			logger.Error("Failed to execute workflow %d: %v", execution.WorkflowID, err)
+			e.failOrRetry(leaseCtx, execution, workflow, fmt.Sprintf("Execution error: %v", err))
+		}
 		return
 	}
 
+	e.logf(execution.ID, "Execution completed")
 	execution.Status = ExecutionCompleted
-	execution.Result = result
 	execution.CompletedAt = time.Now()
-	if err := e.manager.UpdateExecution(execution); err != nil {
+	if err := e.manager.FinishExecution(execution, e.workerID); err != nil {
 		logger.Error("Failed to update execution status to completed: %v", err)
 		return
 	}
@@ -84,27 +185,101 @@ func (e *Executor) executeWorkflow(execution *WorkflowExecution) {
 	logger.Info("Workflow %d (execution %d) completed successfully", execution.WorkflowID, execution.ID)
 }
 
+// keepLease renews the execution lease until ctx is done. The run is cancelled when a
+// user requests cancellation or when the lease is lost, since another worker may
+// already have recovered the execution. After a cancellation request the lease is still
+// renewed so that compensations can finish.
+func (e *Executor) keepLease(ctx context.Context, cancel context.CancelCauseFunc, execution *WorkflowExecution) {
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+
+	cancelled := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := e.manager.RenewLease(execution.ID, e.workerID, executionLease)
+			switch {
+			case errors.Is(err, ErrCancelRequested):
+				if !cancelled {
+					logger.Info("Cancellation requested for execution %d", execution.ID)
+					cancel(ErrCancelRequested)
+					cancelled = true
+				}
+			case errors.Is(err, ErrLeaseLost):
+				logger.Warn("Lost lease on execution %d, cancelling run", execution.ID)
+				cancel(ErrLeaseLost)
+				return
+			case err != nil:
+				logger.Error("Failed to renew lease on execution %d: %v", execution.ID, err)
+			}
+		}
+	}
+}
+
+// failOrRetry reschedules a failed attempt according to the workflow's retry policy, or
+// compensates the completed steps and marks the execution failed once its attempts are
+// exhausted. Apply executions are not retried, since their changes were partly sent.
+func (e *Executor) failOrRetry(ctx context.Context, execution *WorkflowExecution, workflow *Workflow, errorMessage string) {
+	if execution.Attempt >= workflow.MaxAttempts || execution.Mode == ModeApply {
+		if !e.compensate(ctx, workflow, execution) {
+			errorMessage += "; compensation incomplete"
+		}
+		e.markExecutionFailed(execution, errorMessage)
+		return
+	}
+
+	attempt := execution.Attempt
+	delay := workflow.RetryDelay(attempt)
+	e.logf(execution.ID, "Attempt %d/%d failed: %s; retrying in %s", attempt, workflow.MaxAttempts, errorMessage, delay)
+	if err := e.manager.RetryExecution(execution, e.workerID, errorMessage, delay); err != nil {
+		logger.Error("Failed to reschedule execution %d: %v", execution.ID, err)
+		return
+	}
+
+	logger.Info("Execution %d attempt %d/%d failed, retrying in %s", execution.ID, attempt, workflow.MaxAttempts, delay)
+}
+
 func (e *Executor) markExecutionFailed(execution *WorkflowExecution, errorMessage string) {
+	e.logf(execution.ID, "Execution failed: %s", errorMessage)
 	execution.Status = ExecutionFailed
 	execution.ErrorMessage = errorMessage
 	execution.CompletedAt = time.Now()
-	if err := e.manager.UpdateExecution(execution); err != nil {
+	if err := e.manager.FinishExecution(execution, e.workerID); err != nil {
 		logger.Error("Failed to update execution status to failed: %v", err)
 	}
 }
 
-func (e *Executor) runWorkflowCode(workflow *Workflow, parameters json.RawMessage) (json.RawMessage, error) {
-	logger.Info("Simulating execution of workflow %d: %s", workflow.ID, workflow.Name)
-	time.Sleep(2 * time.Second)
-	result := map[string]interface{}{
-		"workflow_name": workflow.Name,
-		"executed_at":   time.Now(),
-		"parameters":    parameters,
-		"result":        "Workflow executed successfully",
+func (e *Executor) markExecutionCancelled(execution *WorkflowExecution) {
+	e.logf(execution.ID, "Execution cancelled")
+	execution.Status = ExecutionCancelled
+	execution.ErrorMessage = "Cancelled by user"
+	execution.CompletedAt = time.Now()
+	if err := e.manager.FinishExecution(execution, e.workerID); err != nil {
+		logger.Error("Failed to update execution status to cancelled: %v", err)
 	}
-	resultJSON, err := json.Marshal(result)
+}
+
+func (e *Executor) runWorkflowCode(ctx context.Context, workflow *Workflow, execution *WorkflowExecution) (json.RawMessage, error) {
+	if execution.Mode == ModeApply {
+		logger.Info("Applying plan %d of workflow %d: %s", execution.PlanExecutionID, workflow.ID, workflow.Name)
+		return e.applyPlan(ctx, workflow, execution)
+	}
+
+	logger.Info("Running workflow %d: %s", workflow.ID, workflow.Name)
+
+	var output *RunOutput
+	var runErr error
+	if len(workflow.Steps) > 0 {
+		output, runErr = e.runSteps(ctx, workflow, execution)
+	} else {
+		output, runErr = e.runtime.RunExecution(ctx, workflow, execution)
+	}
+
+	resultJSON, err := json.Marshal(output)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal result: %w", err)
 	}
-	return resultJSON, nil
+	return resultJSON, runErr
 }
@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrActivationFailed  = errors.New("workflow cannot be activated")
+)
+
+// workflowTransitions lists the statuses a workflow can move to from each status. A
+// workflow starts as a draft, is activated to run, can be deactivated and activated
+// again and ends archived. Archived workflows never change again.
+var workflowTransitions = map[WorkflowStatus][]WorkflowStatus{
+	StatusDraft:    {StatusActive, StatusArchived},
+	StatusActive:   {StatusInactive},
+	StatusInactive: {StatusActive, StatusArchived},
+}
+
+// CheckTransition reports whether a workflow may move from one status to another.
+// Keeping the current status is always allowed, except for archived workflows.
+func CheckTransition(from, to WorkflowStatus) error {
+	if from == StatusArchived {
+		return ErrWorkflowArchived
+	}
+	switch to {
+	case StatusDraft, StatusActive, StatusInactive, StatusArchived:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidTransition, to)
+	}
+	if from == to {
+		return nil
+	}
+	for _, allowed := range workflowTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+}
+
+// checkStatusChange checks that workflow may be saved with its status when it currently
+// has status from. Workflows that are or become active must compile, and archiving is
+// left to ArchiveWorkflow since it also moves the executions to the archive.
+func checkStatusChange(from WorkflowStatus, workflow *Workflow) error {
+	if err := CheckTransition(from, workflow.Status); err != nil {
+		return err
+	}
+	if workflow.Status == StatusArchived && from != StatusArchived {
+		return fmt.Errorf("%w: %s to %s requires archiving the workflow", ErrInvalidTransition, from, workflow.Status)
+	}
+	if workflow.Status == StatusActive {
+		if err := CompileWorkflow(workflow); err != nil {
+			return fmt.Errorf("%w: %v", ErrActivationFailed, err)
+		}
+	}
+	return nil
+}
+
+// lockWorkflowStatus locks the row of a workflow until tx ends and returns its status.
+func lockWorkflowStatus(tx *sql.Tx, workflowID int) (WorkflowStatus, error) {
+	var status WorkflowStatus
+	err := tx.QueryRow(`SELECT status FROM workflows WHERE id = $1 FOR UPDATE`, workflowID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %d", ErrWorkflowNotFound, workflowID)
+		}
+		return "", fmt.Errorf("failed to lock workflow: %w", err)
+	}
+	return status, nil
+}
+
+// cancelPendingExecutions cancels the executions of a workflow that have not started
+// yet, with reason as their error message, and returns how many it cancelled.
+func cancelPendingExecutions(tx *sql.Tx, workflowID int, reason string) (int, error) {
+	query := `
+		UPDATE workflow_executions
+		SET status = $1, error_message = $2, completed_at = NOW(), updated_at = NOW()
+		WHERE workflow_id = $3 AND status = $4
+	`
+	result, err := tx.Exec(query, ExecutionCancelled, reason, workflowID, ExecutionPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel pending executions: %w", err)
+	}
+	cancelled, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count cancelled executions: %w", err)
+	}
+	return int(cancelled), nil
+}
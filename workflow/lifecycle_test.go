@@ -0,0 +1,84 @@
+package workflow
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		from, to WorkflowStatus
+		err      error
+	}{
+		{StatusDraft, StatusActive, nil},
+		{StatusDraft, StatusArchived, nil},
+		{StatusDraft, StatusDraft, nil},
+		{StatusDraft, StatusInactive, ErrInvalidTransition},
+		{StatusActive, StatusInactive, nil},
+		{StatusActive, StatusDraft, ErrInvalidTransition},
+		{StatusActive, StatusArchived, ErrInvalidTransition},
+		{StatusInactive, StatusActive, nil},
+		{StatusInactive, StatusArchived, nil},
+		{StatusArchived, StatusActive, ErrWorkflowArchived},
+		{StatusArchived, StatusArchived, ErrWorkflowArchived},
+		{StatusActive, "paused", ErrInvalidTransition},
+	}
+
+	for _, tt := range tests {
+		err := CheckTransition(tt.from, tt.to)
+		if tt.err == nil && err != nil {
+			t.Errorf("Expected %s to %s to be allowed, got %v", tt.from, tt.to, err)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("Expected %v for %s to %s, got %v", tt.err, tt.from, tt.to, err)
+		}
+	}
+}
+
+func TestCheckStatusChange(t *testing.T) {
+	valid := `package main
+
+func Run(params map[string]interface{}) (interface{}, error) {
+	return params["name"], nil
+}`
+	broken := `package main
+
+func Run(params map[string]interface{}) (interface{}, error) {
+	return undefinedName, nil
+}`
+
+	if err := checkStatusChange(StatusDraft, &Workflow{Code: valid, Status: StatusActive}); err != nil {
+		t.Errorf("Expected valid code to be activated, got %v", err)
+	}
+	if err := checkStatusChange(StatusDraft, &Workflow{Code: broken, Status: StatusActive}); !errors.Is(err, ErrActivationFailed) {
+		t.Errorf("Expected ErrActivationFailed for code that does not compile, got %v", err)
+	}
+	if err := checkStatusChange(StatusDraft, &Workflow{Code: broken, Status: StatusDraft}); err != nil {
+		t.Errorf("Expected drafts not to be compiled, got %v", err)
+	}
+	if err := checkStatusChange(StatusInactive, &Workflow{Code: valid, Status: StatusArchived}); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Expected archiving to be refused outside ArchiveWorkflow, got %v", err)
+	}
+}
+
+func TestCompileWorkflowDoesNotRun(t *testing.T) {
+	code := `package main
+
+func main() {
+	panic("main must not run")
+}`
+	if err := CompileWorkflow(&Workflow{Code: code}); err != nil {
+		t.Errorf("Expected code to compile without running main, got %v", err)
+	}
+
+	denied := `package main
+
+import "os/exec"
+
+func main() {
+	exec.Command("true")
+}`
+	if err := CompileWorkflow(&Workflow{Code: denied}); err == nil {
+		t.Error("Expected error for an import outside the sandbox")
+	}
+}
@@ -0,0 +1,241 @@
+package workflow
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/holonet/core/logger"
+)
+
+type LogStream string
+
+const (
+	// LogSystem lines are written by the executor, e.g. when an attempt starts or a step
+	// completes.
+	LogSystem LogStream = "system"
+	LogInfo   LogStream = "info"
+	LogWarn   LogStream = "warn"
+	LogError  LogStream = "error"
+	LogStdout LogStream = "stdout"
+	LogStderr LogStream = "stderr"
+)
+
+const (
+	maxLogLineLength = 4096
+	// maxLogLinesPerAttempt bounds how many lines one attempt stores so that a workflow
+	// printing in a loop cannot fill the database.
+	maxLogLinesPerAttempt = 10000
+)
+
+// ExecutionLogLine is one persisted log line of an execution. Seq numbers the lines of
+// an execution from 1 and is the offset clients resume from.
+type ExecutionLogLine struct {
+	Seq         int       `json:"seq"`
+	ExecutionID int       `json:"execution_id"`
+	Attempt     int       `json:"attempt"`
+	Stream      LogStream `json:"stream"`
+	Message     string    `json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// executionLogs appends log lines of the executions running in this process. Only the
+// worker holding an execution's lease writes its log, so sequence numbers can be handed
+// out from memory once they have been initialized from the table.
+type executionLogs struct {
+	db *sql.DB
+
+	mu   sync.Mutex
+	open map[int]*openLog
+	// secrets are the secret values each execution has read; see addSecret.
+	secrets map[int][]string
+}
+
+type openLog struct {
+	attempt   int
+	seq       int
+	lines     int
+	truncated bool
+}
+
+func newExecutionLogs(db *sql.DB) *executionLogs {
+	return &executionLogs{db: db, open: map[int]*openLog{}, secrets: map[int][]string{}}
+}
+
+// start prepares the log of an execution for a new attempt.
+func (l *executionLogs) start(executionID, attempt int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.open, executionID)
+	if log := l.get(executionID); log != nil {
+		log.attempt = attempt
+	}
+}
+
+// finish releases the in-memory state of an execution's log.
+func (l *executionLogs) finish(executionID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.open, executionID)
+	delete(l.secrets, executionID)
+}
+
+// get returns the open log of an execution, loading its last sequence number if needed.
+// The caller must hold l.mu.
+func (l *executionLogs) get(executionID int) *openLog {
+	if log, ok := l.open[executionID]; ok {
+		return log
+	}
+
+	log := &openLog{}
+	query := `SELECT COALESCE(MAX(seq), 0) FROM workflow_execution_logs WHERE execution_id = $1`
+	if err := l.db.QueryRow(query, executionID).Scan(&log.seq); err != nil {
+		logger.Error("Failed to load log offset of execution %d: %v", executionID, err)
+		return nil
+	}
+	l.open[executionID] = log
+	return log
+}
+
+// append stores a line. Failures are logged rather than returned, since losing a log line
+// must not fail the execution.
+func (l *executionLogs) append(executionID int, stream LogStream, message string) {
+	if l == nil || executionID == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log := l.get(executionID)
+	if log == nil || log.truncated {
+		return
+	}
+
+	log.lines++
+	if log.lines > maxLogLinesPerAttempt {
+		log.truncated = true
+		stream = LogSystem
+		message = fmt.Sprintf("Log truncated after %d lines", maxLogLinesPerAttempt)
+	}
+	message = truncateLogLine(redactValues(l.secrets[executionID], message))
+
+	query := `
+		INSERT INTO workflow_execution_logs (execution_id, seq, attempt, stream, message, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+	`
+	if _, err := l.db.Exec(query, executionID, log.seq+1, log.attempt, stream, message); err != nil {
+		logger.Error("Failed to store log line of execution %d: %v", executionID, err)
+		return
+	}
+	log.seq++
+}
+
+func truncateLogLine(message string) string {
+	if len(message) <= maxLogLineLength {
+		return message
+	}
+	cut := maxLogLineLength
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+	return message[:cut] + " [truncated]"
+}
+
+// logWriter turns the stdout or stderr of workflow code into log lines.
+type logWriter struct {
+	logs        *executionLogs
+	executionID int
+	stream      LogStream
+
+	mu      sync.Mutex
+	partial []byte
+	closed  bool
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return len(p), nil
+	}
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.logs.append(w.executionID, w.stream, string(bytes.TrimRight(w.partial[:i], "\r")))
+		w.partial = w.partial[i+1:]
+	}
+	if len(w.partial) > maxLogLineLength {
+		w.logs.append(w.executionID, w.stream, string(w.partial))
+		w.partial = nil
+	}
+	return len(p), nil
+}
+
+// flush writes out a last line that was not terminated by a newline.
+func (w *logWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.logs.append(w.executionID, w.stream, string(w.partial))
+		w.partial = nil
+	}
+}
+
+// close flushes the last line and drops everything written after it.
+func (w *logWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.logs.append(w.executionID, w.stream, string(w.partial))
+		w.partial = nil
+	}
+	w.closed = true
+}
+
+const executionLogColumns = `seq, execution_id, attempt, stream, message, created_at`
+
+// ListExecutionLogs returns up to limit log lines of an execution with a sequence number
+// greater than after, oldest first.
+func (wm *WorkflowManager) ListExecutionLogs(executionID, after, limit int) ([]*ExecutionLogLine, error) {
+	query := `
+		SELECT ` + executionLogColumns + `
+		FROM workflow_execution_logs
+		WHERE execution_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`
+
+	rows, err := wm.db.Query(query, executionID, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list execution logs: %w", err)
+	}
+	defer rows.Close()
+
+	lines := []*ExecutionLogLine{}
+	for rows.Next() {
+		line := &ExecutionLogLine{}
+		if err := rows.Scan(&line.Seq, &line.ExecutionID, &line.Attempt, &line.Stream, &line.Message, &line.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan execution log line: %w", err)
+		}
+		lines = append(lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating execution logs: %w", err)
+	}
+
+	return lines, nil
+}
+
+// logf writes a system line to the log of an execution.
+func (e *Executor) logf(executionID int, format string, args ...interface{}) {
+	e.manager.logs.append(executionID, LogSystem, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,25 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateLogLine(t *testing.T) {
+	if got := truncateLogLine("short"); got != "short" {
+		t.Errorf("Expected short line to be unchanged, got %q", got)
+	}
+
+	long := strings.Repeat("a", maxLogLineLength-1) + "é" + strings.Repeat("b", 10)
+	got := truncateLogLine(long)
+	if !strings.HasSuffix(got, " [truncated]") {
+		t.Errorf("Expected truncated marker, got suffix %q", got[len(got)-20:])
+	}
+	if !utf8.ValidString(got) {
+		t.Error("Expected truncation to keep the line valid UTF-8")
+	}
+	if len(got) > maxLogLineLength+len(" [truncated]") {
+		t.Errorf("Expected at most %d bytes, got %d", maxLogLineLength+len(" [truncated]"), len(got))
+	}
+}
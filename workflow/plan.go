@@ -0,0 +1,407 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/holonet/core/logger"
+)
+
+// ExecutionMode says whether an execution changes NetBox, only plans the changes, or
+// applies a plan.
+type ExecutionMode string
+
+const (
+	ModeRun ExecutionMode = "run"
+	// ModeDryRun executions run the workflow code, but record the NetBox changes it
+	// makes instead of sending them; the result holds the plan.
+	ModeDryRun ExecutionMode = "dry_run"
+	// ModeApply executions do not run code; they send the changes planned by a dry run.
+	ModeApply ExecutionMode = "apply"
+)
+
+// planPlaceholderBase is the offset of the IDs that planned creates return, so that
+// placeholders are not mistaken for real object IDs when a plan is applied.
+const planPlaceholderBase = -1000000
+
+var (
+	ErrNotAPlan            = errors.New("execution is not a dry run")
+	ErrPlanNotCompleted    = errors.New("dry run has not completed")
+	ErrPlanAlreadyPromoted = errors.New("plan was already promoted")
+	ErrPlanHasSecrets      = errors.New("plan contains secret values, which are not stored")
+)
+
+// PlannedChange is a NetBox write recorded by a dry run. Creates get a placeholder ID,
+// which later changes in the same plan may use and which is replaced by the real ID of
+// the created object when the plan is applied.
+type PlannedChange struct {
+	Seq           int         `json:"seq"`
+	Method        string      `json:"method"`
+	Endpoint      string      `json:"endpoint"`
+	Body          interface{} `json:"body,omitempty"`
+	PlaceholderID int         `json:"placeholder_id,omitempty"`
+}
+
+// Plan is the change set of a dry run.
+type Plan struct {
+	Changes []PlannedChange `json:"changes"`
+}
+
+// AppliedChange is the outcome of one planned change of an apply execution.
+type AppliedChange struct {
+	PlannedChange
+	ObjectID int    `json:"object_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// planRecorder stands in for NetBox during a dry run. Reads go to NetBox, except for
+// objects the plan itself creates; writes are recorded and answered as NetBox would
+// answer them.
+type planRecorder struct {
+	netbox NetboxRequester
+
+	mu      sync.Mutex
+	changes []PlannedChange
+	// objects holds the planned state of objects created or updated by the plan, by
+	// object endpoint.
+	objects map[string]map[string]interface{}
+}
+
+func newPlanRecorder(netbox NetboxRequester) *planRecorder {
+	return &planRecorder{
+		netbox:  netbox,
+		changes: []PlannedChange{},
+		objects: make(map[string]map[string]interface{}),
+	}
+}
+
+func (r *planRecorder) Request(method, endpoint string, body interface{}) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	objectEndpoint, _, _ := strings.Cut(endpoint, "?")
+	if method == http.MethodGet {
+		if object, ok := r.objects[objectEndpoint]; ok {
+			return json.Marshal(object)
+		}
+		return r.read(endpoint)
+	}
+
+	normalized, err := normalizeJSON(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record request body: %w", err)
+	}
+	change := PlannedChange{
+		Seq:      len(r.changes) + 1,
+		Method:   method,
+		Endpoint: endpoint,
+		Body:     normalized,
+	}
+
+	var response map[string]interface{}
+	switch method {
+	case http.MethodPost:
+		change.PlaceholderID = planPlaceholderBase - change.Seq
+		response = mergeObject(nil, normalized)
+		response["id"] = change.PlaceholderID
+		r.objects[objectEndpoint+strconv.Itoa(change.PlaceholderID)+"/"] = response
+	case http.MethodPatch, http.MethodPut:
+		current, ok := r.objects[objectEndpoint]
+		if !ok {
+			// Answer with the object as it would be after the update if NetBox has it.
+			if data, err := r.read(endpoint); err == nil {
+				json.Unmarshal(data, &current)
+			}
+		}
+		response = mergeObject(current, normalized)
+		if _, ok := response["id"]; !ok {
+			if id, err := strconv.Atoi(objectIDFromEndpoint(objectEndpoint)); err == nil {
+				response["id"] = id
+			}
+		}
+		r.objects[objectEndpoint] = response
+	case http.MethodDelete:
+		delete(r.objects, objectEndpoint)
+	}
+
+	r.changes = append(r.changes, change)
+	if response == nil {
+		return nil, nil
+	}
+	return json.Marshal(response)
+}
+
+func (r *planRecorder) read(endpoint string) ([]byte, error) {
+	if r.netbox == nil {
+		return nil, ErrNetboxUnavailable
+	}
+	return r.netbox.Request(http.MethodGet, endpoint, nil)
+}
+
+func (r *planRecorder) plan() *Plan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Plan{Changes: append([]PlannedChange{}, r.changes...)}
+}
+
+// mergeObject returns a copy of base with the fields of a JSON object body set.
+func mergeObject(base map[string]interface{}, body interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base))
+	for key, value := range base {
+		merged[key] = value
+	}
+	if fields, ok := body.(map[string]interface{}); ok {
+		for key, value := range fields {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+func objectIDFromEndpoint(endpoint string) string {
+	parts := strings.Split(strings.Trim(endpoint, "/"), "/")
+	return parts[len(parts)-1]
+}
+
+// replacePlaceholders substitutes the real IDs of objects created while applying a plan
+// for their placeholders, in path segments of endpoints and in numbers of bodies.
+func replacePlaceholders(value interface{}, ids map[int]int) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if id, ok := ids[int(v)]; ok && float64(int(v)) == v {
+			return float64(id)
+		}
+	case map[string]interface{}:
+		replaced := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			replaced[key] = replacePlaceholders(item, ids)
+		}
+		return replaced
+	case []interface{}:
+		replaced := make([]interface{}, len(v))
+		for i, item := range v {
+			replaced[i] = replacePlaceholders(item, ids)
+		}
+		return replaced
+	}
+	return value
+}
+
+func replaceEndpointPlaceholders(endpoint string, ids map[int]int) string {
+	path, query, hasQuery := strings.Cut(endpoint, "?")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if n, err := strconv.Atoi(segment); err == nil {
+			if id, ok := ids[n]; ok {
+				segments[i] = strconv.Itoa(id)
+			}
+		}
+	}
+	path = strings.Join(segments, "/")
+	if hasQuery {
+		return path + "?" + query
+	}
+	return path
+}
+
+// loadPlan returns the plan recorded by a completed dry run.
+func (wm *WorkflowManager) loadPlan(executionID int) (*Plan, error) {
+	execution, err := wm.GetExecution(executionID)
+	if err != nil {
+		return nil, err
+	}
+	if execution.Mode != ModeDryRun {
+		return nil, fmt.Errorf("%w: %d", ErrNotAPlan, executionID)
+	}
+
+	var result struct {
+		Plan *Plan `json:"plan"`
+	}
+	if len(execution.Result) > 0 {
+		if err := json.Unmarshal(execution.Result, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode plan of execution %d: %w", executionID, err)
+		}
+	}
+	if result.Plan == nil {
+		return &Plan{Changes: []PlannedChange{}}, nil
+	}
+	return result.Plan, nil
+}
+
+// applyPlan sends the changes planned by the dry run an apply execution was promoted
+// from, in order, and stops at the first change that fails. Changes that were sent are
+// not undone, and an apply that was interrupted is not started again, since it cannot
+// tell which changes NetBox already has.
+func (e *Executor) applyPlan(ctx context.Context, workflow *Workflow, execution *WorkflowExecution) (json.RawMessage, error) {
+	if execution.Attempt > 1 {
+		return nil, fmt.Errorf("apply of plan %d was interrupted and may be partly applied", execution.PlanExecutionID)
+	}
+
+	plan, err := e.manager.loadPlan(execution.PlanExecutionID)
+	if err != nil {
+		return nil, err
+	}
+
+	netbox := netboxFor(e.runtime.netboxRequester(), workflow)
+	applied := []AppliedChange{}
+	ids := make(map[int]int)
+	result := func() json.RawMessage {
+		encoded, _ := json.Marshal(&RunOutput{Output: map[string]interface{}{
+			"plan_execution_id": execution.PlanExecutionID,
+			"applied":           applied,
+		}})
+		return encoded
+	}
+
+	e.logf(execution.ID, "Applying %d planned change(s) of execution %d", len(plan.Changes), execution.PlanExecutionID)
+	for _, change := range plan.Changes {
+		if err := ctx.Err(); err != nil {
+			return result(), err
+		}
+
+		outcome := AppliedChange{PlannedChange: change}
+		outcome.Endpoint = replaceEndpointPlaceholders(change.Endpoint, ids)
+		outcome.Body = replacePlaceholders(change.Body, ids)
+
+		var data []byte
+		if netbox == nil {
+			err = ErrNetboxUnavailable
+		} else {
+			data, err = netbox.Request(change.Method, outcome.Endpoint, outcome.Body)
+		}
+		if err != nil {
+			outcome.Error = err.Error()
+			applied = append(applied, outcome)
+			e.logf(execution.ID, "Change %d (%s %s) failed: %v", change.Seq, change.Method, outcome.Endpoint, err)
+			return result(), fmt.Errorf("change %d (%s %s) failed: %w", change.Seq, change.Method, outcome.Endpoint, err)
+		}
+
+		if change.PlaceholderID != 0 {
+			var created struct {
+				ID int `json:"id"`
+			}
+			if err := json.Unmarshal(data, &created); err != nil || created.ID == 0 {
+				applied = append(applied, outcome)
+				return result(), fmt.Errorf("change %d (%s %s) returned no object ID", change.Seq, change.Method, outcome.Endpoint)
+			}
+			ids[change.PlaceholderID] = created.ID
+			outcome.ObjectID = created.ID
+		}
+		applied = append(applied, outcome)
+		e.logf(execution.ID, "Applied change %d: %s %s", change.Seq, change.Method, outcome.Endpoint)
+	}
+
+	return result(), nil
+}
+
+// ScheduleDryRun queues a dry run of a workflow, which records the NetBox changes the
+// workflow would make instead of making them.
+func (wm *WorkflowManager) ScheduleDryRun(workflowID int, parameters json.RawMessage, scheduledAt time.Time) (*WorkflowExecution, error) {
+	return wm.scheduleExecution(workflowID, parameters, scheduledAt, ModeDryRun)
+}
+
+// PromotePlan queues an apply execution that makes exactly the changes planned by a
+// completed dry run. A plan can be promoted once. If the workflow has approval steps,
+// promoting is approving them, so userID must be allowed to decide all of them.
+func (wm *WorkflowManager) PromotePlan(planID, userID int) (*WorkflowExecution, error) {
+	plan, err := wm.GetExecution(planID)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Mode != ModeDryRun {
+		return nil, ErrNotAPlan
+	}
+
+	// Secrets were masked when the plan was stored, so such a plan cannot be applied.
+	changes, err := wm.loadPlan(planID)
+	if err != nil {
+		return nil, err
+	}
+	if encoded, _ := json.Marshal(changes); strings.Contains(string(encoded), redactedValue) {
+		return nil, ErrPlanHasSecrets
+	}
+
+	workflow, err := wm.workflowForExecution(plan)
+	if err != nil {
+		return nil, err
+	}
+	if workflow.Status != StatusActive {
+		return nil, errors.New("cannot schedule inactive workflow")
+	}
+	for _, step := range workflow.Steps {
+		if step.Approval != nil && !(&Approval{Approvers: step.Approval.Approvers}).canDecide(userID) {
+			return nil, fmt.Errorf("%w: step %s", ErrNotApprover, step.Name)
+		}
+	}
+
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status ExecutionStatus
+	if err := tx.QueryRow(`SELECT status FROM workflow_executions WHERE id = $1 FOR UPDATE`, planID).Scan(&status); err != nil {
+		return nil, fmt.Errorf("failed to lock plan: %w", err)
+	}
+	if status != ExecutionCompleted {
+		return nil, ErrPlanNotCompleted
+	}
+
+	var promotedID int
+	err = tx.QueryRow(`SELECT id FROM workflow_executions WHERE plan_execution_id = $1 LIMIT 1`, planID).Scan(&promotedID)
+	if err == nil {
+		return nil, fmt.Errorf("%w as execution %d", ErrPlanAlreadyPromoted, promotedID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to check plan promotion: %w", err)
+	}
+
+	execution := &WorkflowExecution{
+		WorkflowID:      plan.WorkflowID,
+		Status:          ExecutionPending,
+		Parameters:      plan.Parameters,
+		ScheduledAt:     time.Now(),
+		Revision:        plan.Revision,
+		ConcurrencyKey:  plan.ConcurrencyKey,
+		Mode:            ModeApply,
+		PlanExecutionID: planID,
+	}
+
+	query := `
+		INSERT INTO workflow_executions (workflow_id, status, parameters, scheduled_at, revision, concurrency_key, mode,
+		                                 plan_execution_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+		RETURNING id, attempt, created_at, updated_at
+	`
+
+	err = tx.QueryRow(
+		query,
+		execution.WorkflowID,
+		execution.Status,
+		execution.Parameters,
+		execution.ScheduledAt,
+		nullInt(execution.Revision),
+		nullString(execution.ConcurrencyKey),
+		execution.Mode,
+		execution.PlanExecutionID,
+	).Scan(&execution.ID, &execution.Attempt, &execution.CreatedAt, &execution.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to promote plan: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	logger.Info("Promoted plan %d of workflow %d to execution %d", planID, plan.WorkflowID, execution.ID)
+	return execution, nil
+}
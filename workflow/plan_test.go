@@ -0,0 +1,81 @@
+package workflow
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPlanRecorder(t *testing.T) {
+	netbox := &fakeNetbox{responses: map[string]string{
+		"GET dcim/devices/5/": `{"id": 5, "name": "leaf1", "status": "active"}`,
+	}}
+	recorder := newPlanRecorder(netbox)
+
+	data, err := recorder.Request("POST", "dcim/interfaces/", map[string]interface{}{"device": 5, "name": "eth0"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var created map[string]interface{}
+	json.Unmarshal(data, &created)
+	if created["id"] != float64(planPlaceholderBase-1) || created["name"] != "eth0" {
+		t.Errorf("Expected created object with placeholder ID, got %v", created)
+	}
+
+	data, err = recorder.Request("GET", "dcim/interfaces/-1000001/", nil)
+	if err != nil {
+		t.Fatalf("Expected planned object to be readable, got %v", err)
+	}
+	var read map[string]interface{}
+	json.Unmarshal(data, &read)
+	if read["name"] != "eth0" {
+		t.Errorf("Expected planned object, got %v", read)
+	}
+
+	data, err = recorder.Request("PATCH", "dcim/devices/5/", map[string]interface{}{"status": "offline"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var updated map[string]interface{}
+	json.Unmarshal(data, &updated)
+	if updated["name"] != "leaf1" || updated["status"] != "offline" {
+		t.Errorf("Expected NetBox object with update applied, got %v", updated)
+	}
+
+	if _, err := recorder.Request("DELETE", "dcim/interfaces/-1000001/", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, request := range netbox.requests {
+		if request != "GET dcim/devices/5/" {
+			t.Errorf("Expected only reads to reach NetBox, got %s", request)
+		}
+	}
+
+	plan := recorder.plan()
+	if len(plan.Changes) != 3 {
+		t.Fatalf("Expected 3 planned changes, got %d", len(plan.Changes))
+	}
+	if plan.Changes[0].PlaceholderID != planPlaceholderBase-1 || plan.Changes[2].Method != "DELETE" {
+		t.Errorf("Unexpected plan %+v", plan.Changes)
+	}
+}
+
+func TestReplacePlaceholders(t *testing.T) {
+	ids := map[int]int{-1000001: 42}
+
+	if endpoint := replaceEndpointPlaceholders("dcim/interfaces/-1000001/", ids); endpoint != "dcim/interfaces/42/" {
+		t.Errorf("Expected dcim/interfaces/42/, got %s", endpoint)
+	}
+	if endpoint := replaceEndpointPlaceholders("dcim/interfaces/?device_id=-1000001", ids); endpoint != "dcim/interfaces/?device_id=-1000001" {
+		t.Errorf("Expected query to be left alone, got %s", endpoint)
+	}
+
+	body := replacePlaceholders(map[string]interface{}{
+		"interface": float64(-1000001),
+		"tags":      []interface{}{float64(-1000001), float64(3)},
+		"name":      "eth0",
+	}, ids).(map[string]interface{})
+	if body["interface"] != float64(42) || body["tags"].([]interface{})[0] != float64(42) || body["tags"].([]interface{})[1] != float64(3) {
+		t.Errorf("Expected placeholders to be replaced, got %v", body)
+	}
+}
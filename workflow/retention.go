@@ -0,0 +1,679 @@
+package workflow
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/holonet/core/logger"
+	"github.com/lib/pq"
+)
+
+// RetentionAction says what happens to finished executions once they are older than
+// their retention.
+type RetentionAction string
+
+const (
+	// RetentionPurge deletes expired executions with their steps, attempts, approvals
+	// and logs.
+	RetentionPurge RetentionAction = "purge"
+	// RetentionArchive writes expired executions to gzipped JSON files before deleting them.
+	RetentionArchive RetentionAction = "archive"
+)
+
+const (
+	defaultRetentionAction = RetentionArchive
+	defaultArchiveDir      = "archive"
+	archiveVersion         = 1
+	retentionInterval      = time.Hour
+	// retentionBatchSize is how many top-level executions go into one archive file.
+	retentionBatchSize = 100
+	// retentionLockID is the advisory lock that keeps two holonet instances from
+	// archiving the same executions.
+	retentionLockID = 7402
+)
+
+var (
+	ErrWorkflowArchived      = errors.New("workflow is archived")
+	ErrWorkflowHasExecutions = errors.New("workflow has running or waiting executions")
+	ErrRetentionBusy         = errors.New("a retention run is in progress")
+)
+
+// finalStatuses are the statuses of executions that have finished.
+var finalStatuses = []string{
+	string(ExecutionCompleted),
+	string(ExecutionFailed),
+	string(ExecutionCancelled),
+}
+
+// RetentionConfig is the global retention of finished executions, from
+// EXECUTION_RETENTION_DAYS (0, the default, keeps executions forever),
+// EXECUTION_RETENTION_ACTION (archive or purge) and EXECUTION_ARCHIVE_DIR. Workflows
+// can override the days and the action in their ExecutionPolicy.
+type RetentionConfig struct {
+	Days       int             `json:"retention_days"`
+	Action     RetentionAction `json:"retention_action"`
+	ArchiveDir string          `json:"archive_dir"`
+}
+
+func RetentionConfigFromEnv() RetentionConfig {
+	config := RetentionConfig{
+		Action:     defaultRetentionAction,
+		ArchiveDir: defaultArchiveDir,
+	}
+	if value := os.Getenv("EXECUTION_RETENTION_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			logger.Warn("Invalid EXECUTION_RETENTION_DAYS %q, keeping executions forever", value)
+		} else {
+			config.Days = days
+		}
+	}
+	switch value := RetentionAction(os.Getenv("EXECUTION_RETENTION_ACTION")); value {
+	case "":
+	case RetentionPurge, RetentionArchive:
+		config.Action = value
+	default:
+		logger.Warn("Invalid EXECUTION_RETENTION_ACTION %q, using %s", value, defaultRetentionAction)
+	}
+	if value := os.Getenv("EXECUTION_ARCHIVE_DIR"); value != "" {
+		config.ArchiveDir = value
+	}
+	return config
+}
+
+// forWorkflow returns the retention in days (0 for forever) and the action that apply to
+// the executions of a workflow with the given policy.
+func (c RetentionConfig) forWorkflow(policy ExecutionPolicy) (int, RetentionAction) {
+	days := c.Days
+	if policy.RetentionDays != 0 {
+		days = policy.RetentionDays
+	}
+	if days < 0 {
+		days = 0
+	}
+	action := c.Action
+	if policy.RetentionAction != "" {
+		action = policy.RetentionAction
+	}
+	return days, action
+}
+
+// RetentionRun is the outcome of one pass over the executions of all workflows.
+type RetentionRun struct {
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Purged      int       `json:"purged"`
+	Archived    int       `json:"archived"`
+	Files       int       `json:"files"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// WorkflowArchive is the outcome of archiving a workflow.
+type WorkflowArchive struct {
+	Workflow  *Workflow `json:"workflow"`
+	Directory string    `json:"directory"`
+	Cancelled int       `json:"cancelled"`
+	Archived  int       `json:"archived"`
+	Files     int       `json:"files"`
+}
+
+// RetentionStats describes how much execution history is kept and how much of it is due
+// to be purged or archived.
+type RetentionStats struct {
+	Config       RetentionConfig          `json:"config"`
+	LastRun      *RetentionRun            `json:"last_run,omitempty"`
+	Executions   int                      `json:"executions"`
+	Expired      int                      `json:"expired"`
+	LogLines     int                      `json:"log_lines"`
+	ArchiveFiles int                      `json:"archive_files"`
+	ArchiveBytes int64                    `json:"archive_bytes"`
+	Workflows    []WorkflowRetentionStats `json:"workflows"`
+}
+
+type WorkflowRetentionStats struct {
+	WorkflowID      int             `json:"workflow_id"`
+	Name            string          `json:"name"`
+	Status          WorkflowStatus  `json:"status"`
+	RetentionDays   int             `json:"retention_days"`
+	RetentionAction RetentionAction `json:"retention_action"`
+	Executions      int             `json:"executions"`
+	Finished        int             `json:"finished"`
+	Expired         int             `json:"expired"`
+	OldestAt        time.Time       `json:"oldest_at"`
+}
+
+// archivedExecution is an execution with everything stored about it.
+type archivedExecution struct {
+	*WorkflowExecution
+	Steps     []*ExecutionStep    `json:"steps"`
+	Attempts  []*ExecutionAttempt `json:"attempts"`
+	Approvals []*Approval         `json:"approvals"`
+	Logs      []*ExecutionLogLine `json:"logs"`
+}
+
+// executionArchive is the content of an archive file. Sub-workflow executions are
+// archived together with the top-level execution of their tree.
+type executionArchive struct {
+	Version    int                 `json:"version"`
+	WorkflowID int                 `json:"workflow_id"`
+	ArchivedAt time.Time           `json:"archived_at"`
+	Executions []archivedExecution `json:"executions"`
+}
+
+// workflowArchive is the content of the file written when a workflow is archived.
+type workflowArchive struct {
+	Version    int                 `json:"version"`
+	ArchivedAt time.Time           `json:"archived_at"`
+	Workflow   *Workflow           `json:"workflow"`
+	Definition *Definition         `json:"definition"`
+	Revisions  []*WorkflowRevision `json:"revisions"`
+}
+
+// Retainer purges or archives finished executions once they are older than their
+// workflow's retention, and archives workflows.
+type Retainer struct {
+	manager *WorkflowManager
+	config  RetentionConfig
+
+	mu      sync.Mutex
+	lastRun *RetentionRun
+}
+
+func NewRetainer(manager *WorkflowManager) *Retainer {
+	return &Retainer{
+		manager: manager,
+		config:  RetentionConfigFromEnv(),
+	}
+}
+
+func (r *Retainer) Config() RetentionConfig {
+	return r.config
+}
+
+func (r *Retainer) LastRun() *RetentionRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastRun
+}
+
+func (r *Retainer) StartRetentionLoop(ctx context.Context) {
+	logger.Info("Starting execution retention loop (retention %d day(s), action %s)", r.config.Days, r.config.Action)
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RunOnce(ctx); err != nil && !errors.Is(err, ErrRetentionBusy) {
+			logger.Error("Error applying execution retention: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopping execution retention loop")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce purges or archives the expired executions of all workflows. It fails with
+// ErrRetentionBusy if another run holds the retention lock.
+func (r *Retainer) RunOnce(ctx context.Context) (*RetentionRun, error) {
+	run := &RetentionRun{StartedAt: time.Now()}
+	err := r.withLock(ctx, false, func() error {
+		workflows, err := r.manager.ListWorkflows()
+		if err != nil {
+			return err
+		}
+		for _, workflow := range workflows {
+			days, action := r.config.forWorkflow(workflow.ExecutionPolicy)
+			var cutoff time.Time
+			switch {
+			case workflow.Status == StatusArchived:
+				// Finishes archiving a workflow whose archiving was interrupted.
+				action = RetentionArchive
+			case days == 0:
+				continue
+			default:
+				cutoff = time.Now().AddDate(0, 0, -days)
+			}
+			count, files, err := r.expireExecutions(ctx, workflow.ID, cutoff, action)
+			if action == RetentionPurge {
+				run.Purged += count
+			} else {
+				run.Archived += count
+			}
+			run.Files += files
+			if err != nil {
+				return fmt.Errorf("workflow %d: %w", workflow.ID, err)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrRetentionBusy) {
+		return nil, err
+	}
+
+	run.CompletedAt = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.lastRun = run
+	r.mu.Unlock()
+
+	if run.Purged > 0 || run.Archived > 0 {
+		logger.Info("Retention purged %d and archived %d execution(s) into %d file(s)", run.Purged, run.Archived, run.Files)
+	}
+	return run, err
+}
+
+// withLock runs fn while holding the retention lock. Without wait it fails with
+// ErrRetentionBusy if the lock is taken.
+func (r *Retainer) withLock(ctx context.Context, wait bool, fn func() error) error {
+	conn, err := r.manager.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if wait {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, retentionLockID); err != nil {
+			return fmt.Errorf("failed to take retention lock: %w", err)
+		}
+	} else {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, retentionLockID).Scan(&locked); err != nil {
+			return fmt.Errorf("failed to take retention lock: %w", err)
+		}
+		if !locked {
+			return ErrRetentionBusy
+		}
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, retentionLockID)
+
+	return fn()
+}
+
+// expireExecutions purges or archives, in batches, the finished top-level executions of
+// a workflow that completed before cutoff, with their sub-workflow executions. A zero
+// cutoff takes all finished executions. It returns the number of executions removed and
+// the number of archive files written.
+func (r *Retainer) expireExecutions(ctx context.Context, workflowID int, cutoff time.Time, action RetentionAction) (int, int, error) {
+	query := `
+		SELECT e.id
+		FROM workflow_executions e
+		WHERE e.workflow_id = $1 AND e.parent_execution_id IS NULL AND e.status = ANY($2)
+		  AND ($3::timestamp IS NULL OR e.completed_at < $3)
+		  AND NOT EXISTS (
+		      SELECT 1 FROM workflow_executions a WHERE a.plan_execution_id = e.id AND a.status = ANY($4)
+		  )
+		ORDER BY e.id
+		LIMIT $5
+	`
+
+	removed, files := 0, 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return removed, files, err
+		}
+
+		roots, err := queryIDs(r.manager.db, query, workflowID, pq.Array(finalStatuses), nullTime(cutoff), pq.Array(unfinishedStatuses), retentionBatchSize)
+		if err != nil {
+			return removed, files, fmt.Errorf("failed to list expired executions: %w", err)
+		}
+		if len(roots) == 0 {
+			return removed, files, nil
+		}
+
+		ids, err := r.manager.executionTrees(roots)
+		if err != nil {
+			return removed, files, err
+		}
+
+		if action == RetentionArchive {
+			archive, err := r.manager.buildExecutionArchive(workflowID, ids)
+			if err != nil {
+				return removed, files, err
+			}
+			name := fmt.Sprintf("executions-%d-%d.json.gz", roots[0], roots[len(roots)-1])
+			if err := writeArchiveFile(filepath.Join(r.workflowDir(workflowID), name), archive); err != nil {
+				return removed, files, err
+			}
+			files++
+		}
+
+		deleted, err := r.manager.deleteFinishedExecutions(ids)
+		if err != nil {
+			return removed, files, err
+		}
+		removed += deleted
+
+		if len(roots) < retentionBatchSize {
+			return removed, files, nil
+		}
+	}
+}
+
+func (r *Retainer) workflowDir(workflowID int) string {
+	return filepath.Join(r.config.ArchiveDir, fmt.Sprintf("workflow-%d", workflowID))
+}
+
+// ArchiveWorkflow archives a workflow together with its history: the workflow stops
+// accepting executions, its schedules and triggers are disabled and its queued
+// executions cancelled, its definition and revisions are written to the archive
+// directory and all its finished executions are moved there. Workflows with running or
+// waiting executions cannot be archived.
+func (r *Retainer) ArchiveWorkflow(ctx context.Context, workflowID int) (*WorkflowArchive, error) {
+	result := &WorkflowArchive{Directory: r.workflowDir(workflowID)}
+	err := r.withLock(ctx, true, func() error {
+		workflow, cancelled, err := r.manager.markWorkflowArchived(workflowID)
+		if err != nil {
+			return err
+		}
+		result.Workflow = workflow
+		result.Cancelled = cancelled
+
+		definition, err := r.manager.ExportDefinition(workflowID)
+		if err != nil {
+			return err
+		}
+		revisions, err := r.manager.ListRevisions(workflowID)
+		if err != nil {
+			return err
+		}
+		archive := &workflowArchive{
+			Version:    archiveVersion,
+			ArchivedAt: time.Now().UTC(),
+			Workflow:   workflow,
+			Definition: definition,
+			Revisions:  revisions,
+		}
+		if err := writeArchiveFile(filepath.Join(result.Directory, "workflow.json.gz"), archive); err != nil {
+			return err
+		}
+		result.Files++
+
+		archived, files, err := r.expireExecutions(ctx, workflowID, time.Time{}, RetentionArchive)
+		result.Archived = archived
+		result.Files += files
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Archived workflow %d with %d execution(s) to %s", workflowID, result.Archived, result.Directory)
+	return result, nil
+}
+
+// markWorkflowArchived sets a draft or inactive workflow to archived, disables its schedules and triggers
+// and cancels its pending executions. It returns the workflow and the number of
+// cancelled executions.
+func (wm *WorkflowManager) markWorkflowArchived(workflowID int) (*Workflow, int, error) {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	status, err := lockWorkflowStatus(tx, workflowID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := CheckTransition(status, StatusArchived); err != nil {
+		return nil, 0, err
+	}
+
+	var busy bool
+	busyQuery := `SELECT EXISTS (SELECT 1 FROM workflow_executions WHERE workflow_id = $1 AND status = ANY($2))`
+	waiting := []string{string(ExecutionRunning), string(ExecutionWaitingApproval), string(ExecutionWaitingWorkflow)}
+	if err := tx.QueryRow(busyQuery, workflowID, pq.Array(waiting)).Scan(&busy); err != nil {
+		return nil, 0, fmt.Errorf("failed to check executions: %w", err)
+	}
+	if busy {
+		return nil, 0, ErrWorkflowHasExecutions
+	}
+
+	cancelled, err := cancelPendingExecutions(tx, workflowID, "Workflow archived")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if _, err := tx.Exec(`UPDATE workflow_schedules SET enabled = FALSE, updated_at = NOW() WHERE workflow_id = $1`, workflowID); err != nil {
+		return nil, 0, fmt.Errorf("failed to disable schedules: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE workflow_triggers SET enabled = FALSE, updated_at = NOW() WHERE workflow_id = $1`, workflowID); err != nil {
+		return nil, 0, fmt.Errorf("failed to disable triggers: %w", err)
+	}
+
+	workflow, err := scanWorkflow(tx.QueryRow(`
+		UPDATE workflows
+		SET status = $1, updated_at = NOW()
+		WHERE id = $2
+		RETURNING `+workflowColumns, StatusArchived, workflowID))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to archive workflow: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return workflow, cancelled, nil
+}
+
+// executionTrees returns the IDs of the given executions and of all sub-workflow
+// executions below them.
+func (wm *WorkflowManager) executionTrees(roots []int) ([]int, error) {
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT id FROM workflow_executions WHERE id = ANY($1)
+			UNION ALL
+			SELECT e.id
+			FROM workflow_executions e
+			JOIN tree t ON e.parent_execution_id = t.id
+		)
+		SELECT id FROM tree ORDER BY id
+	`
+	ids, err := queryIDs(wm.db, query, pq.Array(roots))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sub-workflow executions: %w", err)
+	}
+	return ids, nil
+}
+
+// buildExecutionArchive collects the executions with the given IDs and everything stored
+// about them.
+func (wm *WorkflowManager) buildExecutionArchive(workflowID int, ids []int) (*executionArchive, error) {
+	archive := &executionArchive{
+		Version:    archiveVersion,
+		WorkflowID: workflowID,
+		ArchivedAt: time.Now().UTC(),
+		Executions: []archivedExecution{},
+	}
+
+	for _, id := range ids {
+		execution, err := wm.GetExecution(id)
+		if err != nil {
+			return nil, err
+		}
+		entry := archivedExecution{WorkflowExecution: execution}
+		if entry.Steps, err = wm.ListExecutionSteps(id); err != nil {
+			return nil, err
+		}
+		if entry.Attempts, err = wm.ListAttempts(id); err != nil {
+			return nil, err
+		}
+		if entry.Approvals, err = wm.ListApprovals(ApprovalFilter{ExecutionID: id}); err != nil {
+			return nil, err
+		}
+
+		entry.Logs = []*ExecutionLogLine{}
+		for {
+			after := 0
+			if len(entry.Logs) > 0 {
+				after = entry.Logs[len(entry.Logs)-1].Seq
+			}
+			lines, err := wm.ListExecutionLogs(id, after, maxLogLinesPerAttempt)
+			if err != nil {
+				return nil, err
+			}
+			entry.Logs = append(entry.Logs, lines...)
+			if len(lines) < maxLogLinesPerAttempt {
+				break
+			}
+		}
+
+		archive.Executions = append(archive.Executions, entry)
+	}
+	return archive, nil
+}
+
+// deleteFinishedExecutions deletes those of the given executions that have finished;
+// their steps, attempts, approvals and logs go with them.
+func (wm *WorkflowManager) deleteFinishedExecutions(ids []int) (int, error) {
+	result, err := wm.db.Exec(`DELETE FROM workflow_executions WHERE id = ANY($1) AND status = ANY($2)`, pq.Array(ids), pq.Array(finalStatuses))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete executions: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted executions: %w", err)
+	}
+	return int(deleted), nil
+}
+
+// writeArchiveFile writes value as gzipped JSON. The file is written next to its final
+// name and renamed, so that an archive file is never left half written.
+func writeArchiveFile(path string, value interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := gzip.NewWriter(tmp)
+	if err := json.NewEncoder(zw).Encode(value); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+	return nil
+}
+
+// Stats returns how many executions are kept and expired per workflow, and the size of
+// the archive.
+func (r *Retainer) Stats() (*RetentionStats, error) {
+	stats := &RetentionStats{
+		Config:    r.config,
+		LastRun:   r.LastRun(),
+		Workflows: []WorkflowRetentionStats{},
+	}
+
+	workflows, err := r.manager.ListWorkflows()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE status = ANY($2)),
+		       COUNT(*) FILTER (WHERE status = ANY($2) AND parent_execution_id IS NULL AND $3 > 0
+		                        AND completed_at < NOW() - make_interval(days => $3)),
+		       MIN(created_at)
+		FROM workflow_executions
+		WHERE workflow_id = $1
+	`
+	for _, workflow := range workflows {
+		days, action := r.config.forWorkflow(workflow.ExecutionPolicy)
+		entry := WorkflowRetentionStats{
+			WorkflowID:      workflow.ID,
+			Name:            workflow.Name,
+			Status:          workflow.Status,
+			RetentionDays:   days,
+			RetentionAction: action,
+		}
+		var oldest sql.NullTime
+		err := r.manager.db.QueryRow(query, workflow.ID, pq.Array(finalStatuses), days).Scan(&entry.Executions, &entry.Finished, &entry.Expired, &oldest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count executions of workflow %d: %w", workflow.ID, err)
+		}
+		entry.OldestAt = oldest.Time
+
+		stats.Executions += entry.Executions
+		stats.Expired += entry.Expired
+		stats.Workflows = append(stats.Workflows, entry)
+	}
+
+	if err := r.manager.db.QueryRow(`SELECT COUNT(*) FROM workflow_execution_logs`).Scan(&stats.LogLines); err != nil {
+		return nil, fmt.Errorf("failed to count execution logs: %w", err)
+	}
+
+	err = filepath.WalkDir(r.config.ArchiveDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != ".gz" {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		stats.ArchiveFiles++
+		stats.ArchiveBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive directory: %w", err)
+	}
+
+	return stats, nil
+}
+
+func queryIDs(db *sql.DB, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,103 @@
+package workflow
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRetentionConfigFromEnv(t *testing.T) {
+	t.Setenv("EXECUTION_RETENTION_DAYS", "")
+	t.Setenv("EXECUTION_RETENTION_ACTION", "")
+	t.Setenv("EXECUTION_ARCHIVE_DIR", "")
+	config := RetentionConfigFromEnv()
+	if config.Days != 0 || config.Action != RetentionArchive || config.ArchiveDir != defaultArchiveDir {
+		t.Errorf("Expected default config, got %+v", config)
+	}
+
+	t.Setenv("EXECUTION_RETENTION_DAYS", "90")
+	t.Setenv("EXECUTION_RETENTION_ACTION", "purge")
+	t.Setenv("EXECUTION_ARCHIVE_DIR", "/var/lib/holonet/archive")
+	config = RetentionConfigFromEnv()
+	if config.Days != 90 || config.Action != RetentionPurge || config.ArchiveDir != "/var/lib/holonet/archive" {
+		t.Errorf("Expected config from environment, got %+v", config)
+	}
+
+	t.Setenv("EXECUTION_RETENTION_DAYS", "-3")
+	t.Setenv("EXECUTION_RETENTION_ACTION", "shred")
+	config = RetentionConfigFromEnv()
+	if config.Days != 0 || config.Action != RetentionArchive {
+		t.Errorf("Expected invalid values to be ignored, got %+v", config)
+	}
+}
+
+func TestRetentionForWorkflow(t *testing.T) {
+	config := RetentionConfig{Days: 30, Action: RetentionArchive}
+
+	tests := []struct {
+		policy ExecutionPolicy
+		days   int
+		action RetentionAction
+	}{
+		{ExecutionPolicy{}, 30, RetentionArchive},
+		{ExecutionPolicy{RetentionDays: 7, RetentionAction: RetentionPurge}, 7, RetentionPurge},
+		{ExecutionPolicy{RetentionDays: -1}, 0, RetentionArchive},
+	}
+
+	for _, tt := range tests {
+		days, action := config.forWorkflow(tt.policy)
+		if days != tt.days || action != tt.action {
+			t.Errorf("Expected %d days and %s for %+v, got %d and %s", tt.days, tt.action, tt.policy, days, action)
+		}
+	}
+}
+
+func TestRetentionPolicyValidation(t *testing.T) {
+	policy := DefaultExecutionPolicy()
+	policy.RetentionDays = -2
+	if err := policy.Validate(); err == nil {
+		t.Error("Expected error for retention_days below -1")
+	}
+
+	policy = DefaultExecutionPolicy()
+	policy.RetentionAction = "shred"
+	if err := policy.Validate(); err == nil {
+		t.Error("Expected error for unknown retention_action")
+	}
+}
+
+func TestWriteArchiveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workflow-3", "executions-10-12.json.gz")
+	archive := &executionArchive{
+		Version:    archiveVersion,
+		WorkflowID: 3,
+		Executions: []archivedExecution{{WorkflowExecution: &WorkflowExecution{ID: 10, WorkflowID: 3, Status: ExecutionCompleted}}},
+	}
+	if err := writeArchiveFile(path, archive); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected archive file, got %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Expected gzipped file, got %v", err)
+	}
+	var read executionArchive
+	if err := json.NewDecoder(zr).Decode(&read); err != nil {
+		t.Fatalf("Expected JSON content, got %v", err)
+	}
+	if read.WorkflowID != 3 || len(read.Executions) != 1 || read.Executions[0].ID != 10 {
+		t.Errorf("Unexpected archive content %+v", read)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the archive file to be left, got %d entries", len(entries))
+	}
+}
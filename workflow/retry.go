@@ -0,0 +1,268 @@
+package workflow
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/holonet/core/queue"
+)
+
+type BackoffStrategy string
+
+const (
+	BackoffFixed       BackoffStrategy = "fixed"
+	BackoffLinear      BackoffStrategy = "linear"
+	BackoffExponential BackoffStrategy = "exponential"
+)
+
+const (
+	defaultMaxRuntimeSeconds = 3600
+	defaultMaxAttempts       = 1
+	defaultBackoffStrategy   = BackoffExponential
+	defaultBackoffSeconds    = 30
+	maxRetryDelay            = time.Hour
+)
+
+// ErrExecutionTimedOut is the cancellation cause of a run that exceeded the workflow's
+// max runtime.
+var ErrExecutionTimedOut = errors.New("execution exceeded its max runtime")
+
+// ExecutionPolicy bounds how long a single attempt of a workflow may run, how failed
+// attempts are retried, how many executions may run at the same time and how long
+// finished executions are kept.
+type ExecutionPolicy struct {
+	MaxRuntimeSeconds int             `json:"max_runtime_seconds"`
+	MaxAttempts       int             `json:"max_attempts"`
+	BackoffStrategy   BackoffStrategy `json:"backoff_strategy"`
+	BackoffSeconds    int             `json:"backoff_seconds"`
+	// MaxConcurrency limits the running executions of the workflow; 0 is unlimited.
+	MaxConcurrency int `json:"max_concurrency"`
+	// ConcurrencyKey is a template such as "site-${params.site}". Executions whose key
+	// resolves to the same value, of any workflow, run one at a time.
+	ConcurrencyKey string `json:"concurrency_key,omitempty"`
+	// RetentionDays and RetentionAction override the global retention of finished
+	// executions; see RetentionConfig. RetentionDays -1 keeps them forever.
+	RetentionDays   int             `json:"retention_days,omitempty"`
+	RetentionAction RetentionAction `json:"retention_action,omitempty"`
+	// NetboxPriority is the class the NetBox requests of the workflow are queued with
+	// when NetBox is busy; empty is normal.
+	NetboxPriority queue.NetboxPriority `json:"netbox_priority,omitempty"`
+}
+
+func DefaultExecutionPolicy() ExecutionPolicy {
+	policy := ExecutionPolicy{}
+	policy.applyDefaults()
+	return policy
+}
+
+func (p *ExecutionPolicy) applyDefaults() {
+	if p.MaxRuntimeSeconds == 0 {
+		p.MaxRuntimeSeconds = defaultMaxRuntimeSeconds
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.BackoffStrategy == "" {
+		p.BackoffStrategy = defaultBackoffStrategy
+	}
+	if p.BackoffSeconds == 0 {
+		p.BackoffSeconds = defaultBackoffSeconds
+	}
+}
+
+func (p ExecutionPolicy) Validate() error {
+	if p.MaxRuntimeSeconds <= 0 {
+		return errors.New("max_runtime_seconds must be positive")
+	}
+	if p.MaxAttempts <= 0 {
+		return errors.New("max_attempts must be positive")
+	}
+	if p.BackoffSeconds < 0 {
+		return errors.New("backoff_seconds must not be negative")
+	}
+	switch p.BackoffStrategy {
+	case BackoffFixed, BackoffLinear, BackoffExponential:
+	default:
+		return fmt.Errorf("invalid backoff_strategy %q (use fixed, linear or exponential)", p.BackoffStrategy)
+	}
+	if p.MaxConcurrency < 0 {
+		return errors.New("max_concurrency must not be negative")
+	}
+	if p.RetentionDays < -1 {
+		return errors.New("retention_days must be -1 (keep forever), 0 (global default) or positive")
+	}
+	switch p.RetentionAction {
+	case "", RetentionPurge, RetentionArchive:
+	default:
+		return fmt.Errorf("invalid retention_action %q (use purge or archive)", p.RetentionAction)
+	}
+	if err := queue.ValidateNetboxPriority(p.NetboxPriority); err != nil {
+		return errors.New("invalid netbox_priority (use interactive, normal or bulk)")
+	}
+	return validateConcurrencyKey(p.ConcurrencyKey)
+}
+
+func (p ExecutionPolicy) MaxRuntime() time.Duration {
+	return time.Duration(p.MaxRuntimeSeconds) * time.Second
+}
+
+// RetryDelay returns how long to wait before the attempt following the given failed
+// attempt (numbered from 1). Delays are capped at one hour.
+func (p ExecutionPolicy) RetryDelay(attempt int) time.Duration {
+	base := time.Duration(p.BackoffSeconds) * time.Second
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	var delay time.Duration
+	switch p.BackoffStrategy {
+	case BackoffFixed:
+		delay = base
+	case BackoffLinear:
+		delay = base * time.Duration(attempt)
+	default:
+		delay = base
+		for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+			delay *= 2
+		}
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// ExecutionAttempt records the outcome of one run of an execution.
+type ExecutionAttempt struct {
+	ID           int             `json:"id"`
+	ExecutionID  int             `json:"execution_id"`
+	Attempt      int             `json:"attempt"`
+	Status       ExecutionStatus `json:"status"`
+	ErrorMessage string          `json:"error_message"`
+	WorkerID     string          `json:"worker_id"`
+	StartedAt    time.Time       `json:"started_at"`
+	CompletedAt  time.Time       `json:"completed_at"`
+}
+
+// RetryExecution records the failed attempt of a running execution held by workerID and
+// puts the execution back to pending with the next attempt number, due after delay.
+func (wm *WorkflowManager) RetryExecution(execution *WorkflowExecution, workerID, errorMessage string, delay time.Duration) error {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE workflow_executions
+		SET status = $1, attempt = attempt + 1, result = $2, error_message = $3,
+		    scheduled_at = NOW() + make_interval(secs => $4), started_at = NULL, completed_at = NULL,
+		    locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+		WHERE id = $5 AND locked_by = $6
+		RETURNING ` + executionColumns
+
+	retried, err := scanExecution(tx.QueryRow(
+		query,
+		ExecutionPending,
+		execution.Result,
+		errorMessage,
+		delay.Seconds(),
+		execution.ID,
+		workerID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("failed to reschedule execution: %w", err)
+	}
+
+	failed := *execution
+	failed.Status = ExecutionFailed
+	failed.ErrorMessage = errorMessage
+	failed.CompletedAt = time.Now()
+	if err := recordAttempt(tx, &failed, workerID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	*execution = *retried
+	return nil
+}
+
+func recordAttempt(tx *sql.Tx, execution *WorkflowExecution, workerID string) error {
+	query := `
+		INSERT INTO workflow_execution_attempts (execution_id, attempt, status, error_message, worker_id, started_at, completed_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+	`
+
+	_, err := tx.Exec(
+		query,
+		execution.ID,
+		execution.Attempt,
+		execution.Status,
+		execution.ErrorMessage,
+		workerID,
+		nullTime(execution.StartedAt),
+		nullTime(execution.CompletedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record execution attempt: %w", err)
+	}
+	return nil
+}
+
+func (wm *WorkflowManager) ListAttempts(executionID int) ([]*ExecutionAttempt, error) {
+	if _, err := wm.GetExecution(executionID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, execution_id, attempt, status, error_message, worker_id, started_at, completed_at
+		FROM workflow_execution_attempts
+		WHERE execution_id = $1
+		ORDER BY attempt, id
+	`
+
+	rows, err := wm.db.Query(query, executionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list execution attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []*ExecutionAttempt{}
+	for rows.Next() {
+		attempt := &ExecutionAttempt{}
+		var errorMessage, workerID sql.NullString
+		var startedAt sql.NullTime
+		err := rows.Scan(
+			&attempt.ID,
+			&attempt.ExecutionID,
+			&attempt.Attempt,
+			&attempt.Status,
+			&errorMessage,
+			&workerID,
+			&startedAt,
+			&attempt.CompletedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan execution attempt: %w", err)
+		}
+		attempt.ErrorMessage = errorMessage.String
+		attempt.WorkerID = workerID.String
+		attempt.StartedAt = startedAt.Time
+		attempts = append(attempts, attempt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating execution attempts: %w", err)
+	}
+
+	return attempts, nil
+}
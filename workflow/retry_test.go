@@ -0,0 +1,75 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		strategy BackoffStrategy
+		attempt  int
+		expected time.Duration
+	}{
+		{BackoffFixed, 1, 30 * time.Second},
+		{BackoffFixed, 4, 30 * time.Second},
+		{BackoffLinear, 1, 30 * time.Second},
+		{BackoffLinear, 3, 90 * time.Second},
+		{BackoffExponential, 1, 30 * time.Second},
+		{BackoffExponential, 3, 2 * time.Minute},
+		{BackoffExponential, 20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		policy := ExecutionPolicy{BackoffStrategy: tt.strategy, BackoffSeconds: 30}
+		if delay := policy.RetryDelay(tt.attempt); delay != tt.expected {
+			t.Errorf("Expected %s delay for %s attempt %d, got %s", tt.expected, tt.strategy, tt.attempt, delay)
+		}
+	}
+}
+
+func TestExecutionPolicyValidate(t *testing.T) {
+	policy := ExecutionPolicy{}
+	policy.applyDefaults()
+	if err := policy.Validate(); err != nil {
+		t.Errorf("Expected default policy to be valid, got %v", err)
+	}
+	if policy.MaxAttempts != 1 || policy.BackoffStrategy != BackoffExponential {
+		t.Errorf("Unexpected defaults: %+v", policy)
+	}
+
+	invalid := []ExecutionPolicy{
+		{MaxRuntimeSeconds: 0, MaxAttempts: 1, BackoffStrategy: BackoffFixed},
+		{MaxRuntimeSeconds: 60, MaxAttempts: 0, BackoffStrategy: BackoffFixed},
+		{MaxRuntimeSeconds: 60, MaxAttempts: 1, BackoffStrategy: "random"},
+		{MaxRuntimeSeconds: 60, MaxAttempts: 1, BackoffStrategy: BackoffFixed, BackoffSeconds: -1},
+		{MaxRuntimeSeconds: 60, MaxAttempts: 1, BackoffStrategy: BackoffFixed, NetboxPriority: "urgent"},
+	}
+	for _, policy := range invalid {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Expected policy %+v to be invalid", policy)
+		}
+	}
+}
+
+func TestExpiredExecutionStatus(t *testing.T) {
+	tests := []struct {
+		attempt     int
+		maxAttempts int
+		mode        ExecutionMode
+		expected    ExecutionStatus
+	}{
+		{1, 3, ModeRun, ExecutionPending},
+		{2, 3, ModeDryRun, ExecutionPending},
+		{3, 3, ModeRun, ExecutionFailed},
+		{1, 1, ModeRun, ExecutionFailed},
+		{1, 3, ModeApply, ExecutionFailed},
+	}
+
+	for _, test := range tests {
+		execution := &WorkflowExecution{Attempt: test.attempt, Mode: test.mode}
+		if status := expiredExecutionStatus(execution, test.maxAttempts); status != test.expected {
+			t.Errorf("Expected %s execution at attempt %d/%d to become %s, got %s", test.mode, test.attempt, test.maxAttempts, test.expected, status)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package workflow
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/logger"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// WorkflowRevision is an immutable snapshot of a workflow's code.
+type WorkflowRevision struct {
+	ID         int       `json:"id"`
+	WorkflowID int       `json:"workflow_id"`
+	Revision   int       `json:"revision"`
+	Code       string    `json:"code"`
+	Steps      []Step    `json:"steps,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RunRevision returns the revision new executions of the workflow run, or 0 for a
+// workflow created before revisions were recorded.
+func (w *Workflow) RunRevision() int {
+	if w.ActiveRevision != 0 {
+		return w.ActiveRevision
+	}
+	return w.Revision
+}
+
+func marshalSteps(steps []Step) ([]byte, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(steps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode steps: %w", err)
+	}
+	return encoded, nil
+}
+
+func unmarshalSteps(data []byte) ([]Step, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var steps []Step
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return nil, fmt.Errorf("failed to decode steps: %w", err)
+	}
+	return steps, nil
+}
+
+// sameSteps compares step definitions by their encoding, since JSONB does not keep the
+// formatting of the stored document.
+func sameSteps(stored, steps []byte) (bool, error) {
+	decoded, err := unmarshalSteps(stored)
+	if err != nil {
+		return false, err
+	}
+	normalized, err := marshalSteps(decoded)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(normalized, steps), nil
+}
+
+func insertRevision(tx *sql.Tx, workflowID, revision int, code string, steps []byte) error {
+	query := `
+		INSERT INTO workflow_revisions (workflow_id, revision, code, steps, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+	`
+	if _, err := tx.Exec(query, workflowID, revision, code, steps); err != nil {
+		return fmt.Errorf("failed to record revision %d of workflow %d: %w", revision, workflowID, err)
+	}
+	return nil
+}
+
+// recordCodeRevision stores workflow.Code and its encoded steps as a new revision if they
+// differ from the stored ones, and makes it the active revision. The workflow row stays
+// locked until tx ends so concurrent updates cannot allocate the same revision number.
+func recordCodeRevision(tx *sql.Tx, workflow *Workflow, steps []byte) error {
+	var storedCode string
+	var storedSteps []byte
+	var revision int
+	err := tx.QueryRow(`SELECT code, steps, revision FROM workflows WHERE id = $1 FOR UPDATE`, workflow.ID).Scan(&storedCode, &storedSteps, &revision)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrWorkflowNotFound, workflow.ID)
+		}
+		return fmt.Errorf("failed to lock workflow: %w", err)
+	}
+
+	workflow.Revision = revision
+	unchangedSteps, err := sameSteps(storedSteps, steps)
+	if err != nil {
+		return err
+	}
+	if storedCode == workflow.Code && unchangedSteps {
+		return nil
+	}
+
+	if revision == 0 {
+		// Keep the code the workflow had before revisions were recorded.
+		revision = 1
+		if err := insertRevision(tx, workflow.ID, revision, storedCode, storedSteps); err != nil {
+			return err
+		}
+	}
+
+	revision++
+	if err := insertRevision(tx, workflow.ID, revision, workflow.Code, steps); err != nil {
+		return err
+	}
+
+	workflow.Revision = revision
+	workflow.ActiveRevision = revision
+	logger.Info("Recorded revision %d of workflow %d", revision, workflow.ID)
+	return nil
+}
+
+const revisionColumns = `id, workflow_id, revision, code, steps, created_at`
+
+func scanRevision(row rowScanner) (*WorkflowRevision, error) {
+	revision := &WorkflowRevision{}
+	var steps []byte
+	if err := row.Scan(&revision.ID, &revision.WorkflowID, &revision.Revision, &revision.Code, &steps, &revision.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if revision.Steps, err = unmarshalSteps(steps); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+func (wm *WorkflowManager) ListRevisions(workflowID int) ([]*WorkflowRevision, error) {
+	if _, err := wm.GetWorkflow(workflowID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + revisionColumns + `
+		FROM workflow_revisions
+		WHERE workflow_id = $1
+		ORDER BY revision DESC
+	`
+
+	rows, err := wm.db.Query(query, workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []*WorkflowRevision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan revision: %w", err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating revisions: %w", err)
+	}
+
+	return revisions, nil
+}
+
+func (wm *WorkflowManager) GetRevision(workflowID, revision int) (*WorkflowRevision, error) {
+	query := `
+		SELECT ` + revisionColumns + `
+		FROM workflow_revisions
+		WHERE workflow_id = $1 AND revision = $2
+	`
+
+	result, err := scanRevision(wm.db.QueryRow(query, workflowID, revision))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, fmt.Errorf("failed to get revision: %w", err)
+	}
+
+	return result, nil
+}
+
+// DiffRevisions returns a unified diff of the code of two revisions of a workflow,
+// followed by a diff of their steps if either has any.
+func (wm *WorkflowManager) DiffRevisions(workflowID, from, to int) (string, error) {
+	fromRevision, err := wm.GetRevision(workflowID, from)
+	if err != nil {
+		return "", err
+	}
+	toRevision, err := wm.GetRevision(workflowID, to)
+	if err != nil {
+		return "", err
+	}
+
+	diff := unifiedDiff(
+		fmt.Sprintf("revision %d", from),
+		fmt.Sprintf("revision %d", to),
+		splitLines(fromRevision.Code),
+		splitLines(toRevision.Code),
+		diffContextLines,
+	)
+
+	if len(fromRevision.Steps) > 0 || len(toRevision.Steps) > 0 {
+		fromSteps, _ := json.MarshalIndent(fromRevision.Steps, "", "  ")
+		toSteps, _ := json.MarshalIndent(toRevision.Steps, "", "  ")
+		diff += unifiedDiff(
+			fmt.Sprintf("revision %d steps", from),
+			fmt.Sprintf("revision %d steps", to),
+			splitLines(string(fromSteps)),
+			splitLines(string(toSteps)),
+			diffContextLines,
+		)
+	}
+
+	return diff, nil
+}
+
+// RollbackWorkflow restores the code of an earlier revision. The restored code is stored
+// as a new revision, so the history itself is never rewritten.
+func (wm *WorkflowManager) RollbackWorkflow(workflowID, revision int) (*Workflow, error) {
+	target, err := wm.GetRevision(workflowID, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	workflow, err := wm.GetWorkflow(workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	workflow.Code = target.Code
+	workflow.Steps = target.Steps
+	workflow.ActiveRevision = workflow.Revision
+	if err := wm.UpdateWorkflow(workflow); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Rolled back workflow %d to the code of revision %d", workflowID, revision)
+	return workflow, nil
+}
+
+// PromoteRevision makes revision the one new executions of the workflow run and activates
+// the workflow. The code of the revision must compile.
+func (wm *WorkflowManager) PromoteRevision(workflowID, revision int) (*Workflow, error) {
+	target, err := wm.GetRevision(workflowID, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	status, err := lockWorkflowStatus(tx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	promoted := &Workflow{ID: workflowID, Code: target.Code, Steps: target.Steps, Status: StatusActive}
+	if err := checkStatusChange(status, promoted); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE workflows
+		SET active_revision = $1, status = $2, updated_at = NOW()
+		WHERE id = $3
+		RETURNING ` + workflowColumns
+
+	workflow, err := scanWorkflow(tx.QueryRow(query, revision, StatusActive, workflowID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to promote revision: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	logger.Info("Promoted revision %d of workflow %d to active", revision, workflowID)
+	return workflow, nil
+}
+
+// runnableWorkflow returns workflow with the code and steps of the revision its new
+// executions run, the way workflowForExecution loads them.
+func runnableWorkflow(tx *sql.Tx, workflow *Workflow) (*Workflow, error) {
+	revision := workflow.RunRevision()
+	if revision == 0 || revision == workflow.Revision {
+		return workflow, nil
+	}
+
+	var code string
+	var steps []byte
+	err := tx.QueryRow(`SELECT code, steps FROM workflow_revisions WHERE workflow_id = $1 AND revision = $2`, workflow.ID, revision).Scan(&code, &steps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revision %d: %w", revision, err)
+	}
+
+	runnable := *workflow
+	runnable.Code = code
+	if runnable.Steps, err = unmarshalSteps(steps); err != nil {
+		return nil, err
+	}
+	return &runnable, nil
+}
+
+// workflowForExecution returns the workflow of execution with the code of the revision
+// the execution was scheduled against.
+func (wm *WorkflowManager) workflowForExecution(execution *WorkflowExecution) (*Workflow, error) {
+	workflow, err := wm.GetWorkflow(execution.WorkflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	if execution.Revision == 0 || execution.Revision == workflow.Revision {
+		return workflow, nil
+	}
+
+	revision, err := wm.GetRevision(workflow.ID, execution.Revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revision %d: %w", execution.Revision, err)
+	}
+	workflow.Code = revision.Code
+	workflow.Steps = revision.Steps
+	return workflow, nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
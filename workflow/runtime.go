@@ -0,0 +1,419 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"path"
+	"reflect"
+	"sync"
+
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+// maxCapturedOutput caps how much stdout/stderr of a single run is kept in the execution result.
+const maxCapturedOutput = 1 << 20
+
+// callPackage passes the input and results of program.call through the interpreter, so
+// that the call is evaluated with a context and stops when it is cancelled. It is loaded
+// after the workflow code, which therefore cannot import it.
+const (
+	callPackage = "holonet/internal/call"
+	callImport  = "_holonetcall"
+)
+
+// allowedPackages are the stdlib packages that workflow code may import. They compute on
+// values only; packages that reach the host, such as os, io/ioutil, net, net/http and
+// os/exec, are left out, so workflows can only reach NetBox, the cache and secrets
+// through the holonet package.
+var allowedPackages = []string{
+	"bytes",
+	"cmp",
+	"container/heap",
+	"container/list",
+	"crypto/sha256",
+	"encoding/base64",
+	"encoding/csv",
+	"encoding/hex",
+	"encoding/json",
+	"errors",
+	"fmt",
+	"hash/crc32",
+	"hash/fnv",
+	"maps",
+	"math",
+	"math/big",
+	"math/bits",
+	"net/netip",
+	"regexp",
+	"slices",
+	"sort",
+	"strconv",
+	"strings",
+	"time",
+	"unicode",
+	"unicode/utf8",
+}
+
+// RunFunc is the signature of the optional Run entry point of a workflow and of step
+// functions.
+type RunFunc func(params map[string]interface{}) (interface{}, error)
+
+type RunOutput struct {
+	Output interface{} `json:"output,omitempty"`
+	Stdout string      `json:"stdout"`
+	Stderr string      `json:"stderr"`
+	// Plan holds the NetBox changes recorded by a dry run.
+	Plan *Plan `json:"plan,omitempty"`
+}
+
+// Runtime executes workflow code in a sandboxed Go interpreter. Workflow code is a
+// "package main" source file that either defines func main() or
+// func Run(params map[string]interface{}) (interface{}, error).
+type Runtime struct {
+	symbols interp.Exports
+
+	mu      sync.RWMutex
+	netbox  NetboxRequester
+	cache   CacheStore
+	secrets SecretResolver
+	logs    *executionLogs
+}
+
+func NewRuntime() *Runtime {
+	symbols := interp.Exports{}
+	for _, pkg := range allowedPackages {
+		// Symbols are keyed by import path and package name, such as "encoding/json/json".
+		key := pkg + "/" + path.Base(pkg)
+		if syms, ok := stdlib.Symbols[key]; ok {
+			symbols[key] = syms
+		}
+	}
+	return &Runtime{symbols: symbols}
+}
+
+type entryPoints struct {
+	hasMain bool
+	hasRun  bool
+	funcs   map[string]bool
+}
+
+// Validate parses workflow code and checks that it declares an entry point.
+func (rt *Runtime) Validate(code string) error {
+	_, err := inspectCode(code)
+	return err
+}
+
+// ValidateWorkflow checks the code of a workflow and, for a multi-step workflow, its step
+// definition and that every step function is declared in the code.
+func ValidateWorkflow(workflow *Workflow) error {
+	if len(workflow.Steps) == 0 {
+		_, err := inspectCode(workflow.Code)
+		return err
+	}
+
+	if err := ValidateSteps(workflow.Steps); err != nil {
+		return err
+	}
+
+	entry, err := parseCode(workflow.Code)
+	if err != nil {
+		return err
+	}
+	for _, step := range workflow.Steps {
+		if step.Approval != nil || step.Workflow != nil {
+			continue
+		}
+		if !entry.funcs[step.Function] {
+			return fmt.Errorf("step %q calls undeclared function %s", step.Name, step.Function)
+		}
+		if step.Compensate != "" && !entry.funcs[step.Compensate] {
+			return fmt.Errorf("step %q compensates with undeclared function %s", step.Name, step.Compensate)
+		}
+	}
+	return nil
+}
+
+// CompileWorkflow validates a workflow and compiles its code in a sandboxed interpreter
+// without running it, so that errors such as undefined names, type mismatches or denied
+// imports surface before the workflow is activated.
+func CompileWorkflow(workflow *Workflow) error {
+	if err := ValidateWorkflow(workflow); err != nil {
+		return err
+	}
+
+	rt := NewRuntime()
+	host := rt.newHost(context.Background(), workflow, &WorkflowExecution{}, nil)
+	i := interp.New(interp.Options{
+		Stdin:  bytes.NewReader(nil),
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+		Args:   []string{workflow.Name},
+		Env:    []string{},
+	})
+	if err := i.Use(rt.symbols); err != nil {
+		return fmt.Errorf("failed to load sandbox symbols: %w", err)
+	}
+	if err := i.Use(host.exports()); err != nil {
+		return fmt.Errorf("failed to load host API: %w", err)
+	}
+	if _, err := i.Compile(workflow.Code); err != nil {
+		return fmt.Errorf("failed to compile workflow code: %w", err)
+	}
+	return nil
+}
+
+func parseCode(code string) (*entryPoints, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "workflow.go", code, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workflow code: %w", err)
+	}
+	if file.Name.Name != "main" {
+		return nil, fmt.Errorf("workflow code must be in package main, got package %s", file.Name.Name)
+	}
+
+	entry := &entryPoints{funcs: map[string]bool{}}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil {
+			continue
+		}
+		entry.funcs[fn.Name.Name] = true
+		switch fn.Name.Name {
+		case "main":
+			entry.hasMain = true
+		case "Run":
+			entry.hasRun = true
+		}
+	}
+	return entry, nil
+}
+
+func inspectCode(code string) (*entryPoints, error) {
+	entry, err := parseCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.hasMain && !entry.hasRun {
+		return nil, errors.New("workflow code must define func main() or func Run(params map[string]interface{}) (interface{}, error)")
+	}
+	return entry, nil
+}
+
+// program is workflow code evaluated in its own interpreter, whose functions can then be
+// called by name.
+type program struct {
+	interp *interp.Interpreter
+	host   *hostAPI
+	stdout *cappedBuffer
+	stderr *cappedBuffer
+	// stdoutLog and stderrLog copy the output into the execution log line by line.
+	stdoutLog *logWriter
+	stderrLog *logWriter
+
+	// input, result and err are the arguments and results of the running call.
+	input    map[string]interface{}
+	result   interface{}
+	err      error
+	callable bool
+}
+
+// load evaluates workflow code in a fresh interpreter for the given execution. Evaluating
+// the source also runs main() when it is declared.
+func (rt *Runtime) load(ctx context.Context, workflow *Workflow, execution *WorkflowExecution, params map[string]interface{}) (*program, error) {
+	executionID := execution.ID
+	p := &program{
+		host:   rt.newHost(ctx, workflow, execution, params),
+		stdout: &cappedBuffer{limit: maxCapturedOutput},
+		stderr: &cappedBuffer{limit: maxCapturedOutput},
+	}
+	p.stdoutLog = &logWriter{logs: p.host.logs, executionID: executionID, stream: LogStdout}
+	p.stderrLog = &logWriter{logs: p.host.logs, executionID: executionID, stream: LogStderr}
+
+	p.interp = interp.New(interp.Options{
+		Stdin:  bytes.NewReader(nil),
+		Stdout: io.MultiWriter(p.stdout, p.stdoutLog),
+		Stderr: io.MultiWriter(p.stderr, p.stderrLog),
+		Args:   []string{workflow.Name},
+		Env:    []string{},
+	})
+	if err := p.interp.Use(rt.symbols); err != nil {
+		return p, fmt.Errorf("failed to load sandbox symbols: %w", err)
+	}
+	if err := p.interp.Use(p.host.exports()); err != nil {
+		return p, fmt.Errorf("failed to load host API: %w", err)
+	}
+
+	if _, err := p.interp.EvalWithContext(ctx, workflow.Code); err != nil {
+		if ctx.Err() != nil {
+			p.abandon()
+		}
+		return p, err
+	}
+	return p, nil
+}
+
+// call runs the top-level function name, which must have the RunFunc signature. When ctx
+// is done, the interpreter stops running the function and the program is abandoned.
+func (p *program) call(ctx context.Context, name string, input map[string]interface{}) (interface{}, error) {
+	if p.host.abandoned.Load() {
+		return nil, errRunAbandoned
+	}
+	value, err := p.interp.EvalWithContext(ctx, "main."+name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	if _, ok := value.Interface().(func(map[string]interface{}) (interface{}, error)); !ok {
+		return nil, fmt.Errorf("%s has signature %s, expected func(map[string]interface{}) (interface{}, error)", name, value.Type())
+	}
+	if err := p.loadCallPackage(); err != nil {
+		return nil, err
+	}
+
+	p.input, p.result, p.err = input, nil, nil
+	if _, err := p.interp.EvalWithContext(ctx, callImport+".Return(main."+name+"("+callImport+".Input()))"); err != nil {
+		if ctx.Err() != nil {
+			p.abandon()
+			return nil, ctx.Err()
+		}
+		var panicked interp.Panic
+		if errors.As(err, &panicked) {
+			return nil, fmt.Errorf("workflow panicked: %v", panicked.Value)
+		}
+		return nil, err
+	}
+	return p.result, p.err
+}
+
+func (p *program) loadCallPackage() error {
+	if p.callable {
+		return nil
+	}
+	err := p.interp.Use(interp.Exports{
+		callPackage + "/call": {
+			"Input": reflect.ValueOf(func() map[string]interface{} {
+				return p.input
+			}),
+			"Return": reflect.ValueOf(func(result interface{}, err error) {
+				p.result, p.err = result, err
+			}),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load call package: %w", err)
+	}
+	if _, err := p.interp.Eval("import " + callImport + " \"" + callPackage + "\""); err != nil {
+		return fmt.Errorf("failed to import call package: %w", err)
+	}
+	p.callable = true
+	return nil
+}
+
+// abandon makes a program whose run was cancelled or timed out inert: code that is still
+// running, such as a host call that has not returned yet, can no longer call the host API
+// or write to the execution log.
+func (p *program) abandon() {
+	p.host.abandoned.Store(true)
+	p.stdoutLog.close()
+	p.stderrLog.close()
+}
+
+// output collects what the program produced so far. It also flushes unterminated output
+// lines to the execution log.
+func (p *program) output() *RunOutput {
+	p.stdoutLog.flush()
+	p.stderrLog.flush()
+	output := &RunOutput{
+		Output: p.host.result(),
+		Stdout: p.stdout.String(),
+		Stderr: p.stderr.String(),
+	}
+	if p.host.plan != nil {
+		output.Plan = p.host.plan.plan()
+	}
+	return output
+}
+
+func decodeParameters(parameters json.RawMessage) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	if len(parameters) > 0 && string(parameters) != "null" {
+		if err := json.Unmarshal(parameters, &params); err != nil {
+			return nil, fmt.Errorf("workflow parameters must be a JSON object: %w", err)
+		}
+	}
+	return params, nil
+}
+
+// Run evaluates the workflow code with the given parameters. The returned output is
+// populated even when err is non-nil so that partial stdout/stderr can be stored.
+func (rt *Runtime) Run(ctx context.Context, workflow *Workflow, parameters json.RawMessage) (*RunOutput, error) {
+	return rt.RunExecution(ctx, workflow, &WorkflowExecution{WorkflowID: workflow.ID, Parameters: parameters})
+}
+
+// RunExecution is Run for a stored execution, whose ID is exposed to the workflow code.
+func (rt *Runtime) RunExecution(ctx context.Context, workflow *Workflow, execution *WorkflowExecution) (*RunOutput, error) {
+	entry, err := inspectCode(workflow.Code)
+	if err != nil {
+		return &RunOutput{}, err
+	}
+
+	params, err := decodeParameters(execution.Parameters)
+	if err != nil {
+		return &RunOutput{}, err
+	}
+
+	p, err := rt.load(ctx, workflow, execution, params)
+	if err != nil {
+		return p.output(), err
+	}
+
+	if entry.hasRun {
+		p.host.enterScope("run")
+		result, err := p.call(ctx, "Run", params)
+		if err != nil {
+			return p.output(), err
+		}
+		if result != nil {
+			p.host.setResult(result)
+		}
+	}
+
+	return p.output(), nil
+}
+
+// cappedBuffer is a concurrency-safe writer that keeps at most limit bytes.
+type cappedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if remaining := b.limit - b.buf.Len(); remaining < len(p) {
+		b.truncated = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+	return b.buf.String()
+}
@@ -0,0 +1,249 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRuntimeRunMain(t *testing.T) {
+	rt := NewRuntime()
+	wf := &Workflow{Name: "main", Code: `
+package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello from workflow")
+}
+`}
+
+	output, err := rt.Run(context.Background(), wf, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(output.Stdout, "hello from workflow") {
+		t.Errorf("Expected stdout to contain workflow output, got '%s'", output.Stdout)
+	}
+}
+
+func TestRuntimeRunWithParameters(t *testing.T) {
+	rt := NewRuntime()
+	wf := &Workflow{Name: "params", Code: `
+package main
+
+import (
+	"fmt"
+	"holonet"
+)
+
+func Run(params map[string]interface{}) (interface{}, error) {
+	fmt.Println("max age", holonet.Param("max_age_days"))
+	return map[string]interface{}{"max_age_days": params["max_age_days"]}, nil
+}
+`}
+
+	output, err := rt.Run(context.Background(), wf, json.RawMessage(`{"max_age_days": 30}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	result, ok := output.Output.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected map output, got %T", output.Output)
+	}
+	if result["max_age_days"] != float64(30) {
+		t.Errorf("Expected max_age_days 30, got %v", result["max_age_days"])
+	}
+	if !strings.Contains(output.Stdout, "max age 30") {
+		t.Errorf("Expected stdout to contain parameter, got '%s'", output.Stdout)
+	}
+}
+
+func TestRuntimeRunErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		contains string
+	}{
+		{"no entry point", "package main\n\nfunc helper() {}\n", "must define"},
+		{"syntax error", "package main\n\nfunc main() {\n", "failed to parse"},
+		{"returned error", "package main\n\nimport \"errors\"\n\nfunc Run(params map[string]interface{}) (interface{}, error) {\n\treturn nil, errors.New(\"device unreachable\")\n}\n", "device unreachable"},
+		{"panic", "package main\n\nfunc main() {\n\tpanic(\"boom\")\n}\n", "boom"},
+		{"denied import", "package main\n\nimport \"os/exec\"\n\nfunc main() {\n\texec.Command(\"true\")\n}\n", "os/exec"},
+	}
+
+	rt := NewRuntime()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := rt.Run(context.Background(), &Workflow{Name: test.name, Code: test.code}, nil)
+			if err == nil {
+				t.Fatal("Expected an error, got nil")
+			}
+			if !strings.Contains(err.Error(), test.contains) {
+				t.Errorf("Expected error to contain '%s', got '%v'", test.contains, err)
+			}
+		})
+	}
+}
+
+func TestRuntimeDeniesHostPackages(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "insecure")
+
+	tests := map[string]string{
+		"os":        `os.ReadFile("/proc/self/environ")`,
+		"io/ioutil": `ioutil.ReadFile("/proc/self/environ")`,
+		"net":       `net.Dial("tcp", "localhost:5432")`,
+		"net/http":  `http.Get("http://localhost/")`,
+		"os/exec":   `exec.Command("env").Output()`,
+	}
+
+	rt := NewRuntime()
+	for pkg, call := range tests {
+		t.Run(pkg, func(t *testing.T) {
+			wf := &Workflow{Name: "host", Code: "package main\n\nimport \"" + pkg + "\"\n\nfunc Run(params map[string]interface{}) (interface{}, error) {\n\treturn " + call + "\n}\n"}
+
+			output, err := rt.Run(context.Background(), wf, nil)
+			if err == nil {
+				t.Fatalf("Expected importing %s to fail, got output %v", pkg, output.Output)
+			}
+			if !strings.Contains(err.Error(), pkg) {
+				t.Errorf("Expected error to name %s, got '%v'", pkg, err)
+			}
+		})
+	}
+}
+
+func TestRuntimeContextCancellation(t *testing.T) {
+	rt := NewRuntime()
+	wf := &Workflow{Name: "slow", Code: `
+package main
+
+import "time"
+
+func Run(params map[string]interface{}) (interface{}, error) {
+	time.Sleep(10 * time.Second)
+	return nil, nil
+}
+`}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := rt.Run(ctx, wf, nil)
+	if err == nil {
+		t.Fatal("Expected a context error, got nil")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected Run to return promptly after cancellation, took %s", time.Since(start))
+	}
+}
+
+// countingNetbox counts requests; it is safe for use by a run that keeps going after it
+// was cancelled.
+type countingNetbox struct {
+	requests atomic.Int64
+}
+
+func (n *countingNetbox) Request(method, endpoint string, body interface{}) ([]byte, error) {
+	n.requests.Add(1)
+	return []byte(`{}`), nil
+}
+
+func TestProgramStopsWhenCancelled(t *testing.T) {
+	tests := map[string]string{
+		"host calls": `for {
+		holonet.NetboxGet("status/", nil)
+		fmt.Println("tick")
+		time.Sleep(time.Millisecond)
+	}`,
+		"busy loop": `for i := 0; ; i++ {
+		if i%1000 == 0 {
+			fmt.Println("tick")
+		}
+	}`,
+	}
+
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			netbox := &countingNetbox{}
+			rt := NewRuntime()
+			rt.SetNetbox(netbox)
+			wf := &Workflow{Name: "loop", Code: "package main\n\nimport (\n\t\"fmt\"\n\t\"holonet\"\n\t\"time\"\n)\n\nvar _ = holonet.NetboxGet\nvar _ = time.Sleep\n\nfunc Loop(params map[string]interface{}) (interface{}, error) {\n\t" + body + "\n}\n"}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			p, err := rt.load(ctx, wf, &WorkflowExecution{}, nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := p.call(ctx, "Loop", nil); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Expected deadline exceeded, got %v", err)
+			}
+
+			// Let a host call that was under way when the run was cancelled return.
+			time.Sleep(50 * time.Millisecond)
+			requests, stdout := netbox.requests.Load(), p.stdout.String()
+			time.Sleep(200 * time.Millisecond)
+			if n := netbox.requests.Load(); n != requests {
+				t.Errorf("Expected no NetBox requests after cancellation, got %d more", n-requests)
+			}
+			if p.stdout.String() != stdout {
+				t.Error("Expected the workflow to stop writing output after cancellation")
+			}
+			if _, err := p.call(context.Background(), "Loop", nil); !errors.Is(err, errRunAbandoned) {
+				t.Errorf("Expected calls of an abandoned program to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestProgramCallsStepFunctions(t *testing.T) {
+	rt := NewRuntime()
+	wf := &Workflow{Name: "steps", Code: `package main
+
+import "holonet"
+
+func Allocate(input map[string]interface{}) (interface{}, error) {
+	return map[string]interface{}{"address": input["prefix"].(string) + "5", "site": holonet.Param("site")}, nil
+}
+`}
+
+	p, err := rt.load(context.Background(), wf, &WorkflowExecution{}, map[string]interface{}{"site": "ams1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output, err := p.call(context.Background(), "Allocate", map[string]interface{}{"prefix": "10.0.0."})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	result := output.(map[string]interface{})
+	if result["address"] != "10.0.0.5" || result["site"] != "ams1" {
+		t.Errorf("Unexpected step output: %v", result)
+	}
+
+	if _, err := p.call(context.Background(), "Missing", nil); err == nil {
+		t.Error("Expected error calling undeclared function")
+	}
+}
+
+func TestValidateWorkflowSteps(t *testing.T) {
+	wf := &Workflow{
+		Code:  "package main\n\nfunc Allocate(input map[string]interface{}) (interface{}, error) { return nil, nil }\n",
+		Steps: []Step{{Name: "allocate", Function: "Allocate"}},
+	}
+	if err := ValidateWorkflow(wf); err != nil {
+		t.Errorf("Expected valid workflow, got %v", err)
+	}
+
+	wf.Steps = append(wf.Steps, Step{Name: "notify", Function: "Notify", DependsOn: []string{"allocate"}})
+	if err := ValidateWorkflow(wf); err == nil || !strings.Contains(err.Error(), "undeclared function Notify") {
+		t.Errorf("Expected undeclared function error, got %v", err)
+	}
+}
@@ -0,0 +1,529 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/holonet/core/logger"
+)
+
+type MissedRunPolicy string
+
+const (
+	// MissedRunSkip drops runs that could not start on time (e.g. during downtime) and
+	// continues with the next future occurrence.
+	MissedRunSkip MissedRunPolicy = "skip"
+	// MissedRunCatchUp runs every missed occurrence, one after the other.
+	MissedRunCatchUp MissedRunPolicy = "catch_up"
+)
+
+// missedRunGrace is how late a pending run of a skip schedule may start before it is
+// considered missed.
+const missedRunGrace = 2 * time.Minute
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+type WorkflowSchedule struct {
+	ID              int             `json:"id"`
+	WorkflowID      int             `json:"workflow_id"`
+	CronExpression  string          `json:"cron_expression"`
+	Timezone        string          `json:"timezone"`
+	Parameters      json.RawMessage `json:"parameters"`
+	MissedRunPolicy MissedRunPolicy `json:"missed_run_policy"`
+	Enabled         bool            `json:"enabled"`
+	NextRunAt       time.Time       `json:"next_run_at"`
+	LastScheduledAt time.Time       `json:"last_scheduled_at"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// Validate checks the cron expression, timezone and policy and fills in defaults.
+func (s *WorkflowSchedule) Validate() error {
+	if _, err := ParseCron(s.CronExpression); err != nil {
+		return err
+	}
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", s.Timezone, err)
+	}
+	switch s.MissedRunPolicy {
+	case "":
+		s.MissedRunPolicy = MissedRunSkip
+	case MissedRunSkip, MissedRunCatchUp:
+	default:
+		return fmt.Errorf("invalid missed_run_policy %q: must be %q or %q", s.MissedRunPolicy, MissedRunSkip, MissedRunCatchUp)
+	}
+	return nil
+}
+
+// nextRun computes the next occurrence to materialize after the schedule's last
+// scheduled run, honouring the missed run policy.
+func (s *WorkflowSchedule) nextRun(now time.Time) (time.Time, error) {
+	cron, err := ParseCron(s.CronExpression)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	base := s.LastScheduledAt
+	if base.IsZero() || (s.MissedRunPolicy != MissedRunCatchUp && base.Before(now)) {
+		base = now
+	}
+
+	next := cron.Next(base.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q has no upcoming runs", s.CronExpression)
+	}
+	return next, nil
+}
+
+const scheduleColumns = `id, workflow_id, cron_expression, timezone, parameters, missed_run_policy, enabled,
+		next_run_at, last_scheduled_at, created_at, updated_at`
+
+func scanSchedule(row rowScanner) (*WorkflowSchedule, error) {
+	schedule := &WorkflowSchedule{}
+	var parameters []byte
+	var nextRunAt, lastScheduledAt sql.NullTime
+
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.WorkflowID,
+		&schedule.CronExpression,
+		&schedule.Timezone,
+		&parameters,
+		&schedule.MissedRunPolicy,
+		&schedule.Enabled,
+		&nextRunAt,
+		&lastScheduledAt,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parameters) > 0 {
+		schedule.Parameters = json.RawMessage(parameters)
+	}
+	schedule.NextRunAt = nextRunAt.Time
+	schedule.LastScheduledAt = lastScheduledAt.Time
+
+	return schedule, nil
+}
+
+func (wm *WorkflowManager) CreateSchedule(schedule *WorkflowSchedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	workflow, err := wm.GetWorkflow(schedule.WorkflowID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := wm.createSchedule(tx, workflow, schedule); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit schedule: %w", err)
+	}
+
+	logger.Info("Created schedule %d for workflow %d (%s %s)", schedule.ID, schedule.WorkflowID, schedule.CronExpression, schedule.Timezone)
+	return nil
+}
+
+// createSchedule stores schedule for workflow within tx and queues its first run.
+func (wm *WorkflowManager) createSchedule(tx *sql.Tx, workflow *Workflow, schedule *WorkflowSchedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	parameters, err := workflow.PrepareParameters(schedule.Parameters)
+	if err != nil {
+		return err
+	}
+	if _, err := workflow.resolveConcurrencyKey(parameters); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO workflow_schedules (workflow_id, cron_expression, timezone, parameters, missed_run_policy, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+		RETURNING ` + scheduleColumns
+
+	created, err := scanSchedule(tx.QueryRow(
+		query,
+		schedule.WorkflowID,
+		schedule.CronExpression,
+		schedule.Timezone,
+		schedule.Parameters,
+		schedule.MissedRunPolicy,
+		schedule.Enabled,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+	*schedule = *created
+
+	if schedule.Enabled {
+		return wm.materializeSchedule(tx, schedule, time.Now())
+	}
+	return nil
+}
+
+func (wm *WorkflowManager) GetSchedule(workflowID, scheduleID int) (*WorkflowSchedule, error) {
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM workflow_schedules
+		WHERE id = $1 AND workflow_id = $2
+	`
+
+	schedule, err := scanSchedule(wm.db.QueryRow(query, scheduleID, workflowID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+
+	return schedule, nil
+}
+
+func (wm *WorkflowManager) ListSchedules(workflowID int) ([]*WorkflowSchedule, error) {
+	query := `
+		SELECT ` + scheduleColumns + `
+		FROM workflow_schedules
+		WHERE workflow_id = $1
+		ORDER BY id
+	`
+
+	rows, err := wm.db.Query(query, workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := []*WorkflowSchedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schedules: %w", err)
+	}
+
+	return schedules, nil
+}
+
+// UpdateSchedule replaces a schedule definition. Runs that were already materialized
+// for the old definition but have not started are cancelled and re-planned.
+func (wm *WorkflowManager) UpdateSchedule(schedule *WorkflowSchedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	workflow, err := wm.GetWorkflow(schedule.WorkflowID)
+	if err != nil {
+		return err
+	}
+	parameters, err := workflow.PrepareParameters(schedule.Parameters)
+	if err != nil {
+		return err
+	}
+	if _, err := workflow.resolveConcurrencyKey(parameters); err != nil {
+		return err
+	}
+
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE workflow_schedules
+		SET cron_expression = $1, timezone = $2, parameters = $3, missed_run_policy = $4, enabled = $5,
+		    next_run_at = NULL, last_scheduled_at = NULL, updated_at = NOW()
+		WHERE id = $6 AND workflow_id = $7
+		RETURNING ` + scheduleColumns
+
+	updated, err := scanSchedule(tx.QueryRow(
+		query,
+		schedule.CronExpression,
+		schedule.Timezone,
+		schedule.Parameters,
+		schedule.MissedRunPolicy,
+		schedule.Enabled,
+		schedule.ID,
+		schedule.WorkflowID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrScheduleNotFound
+		}
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+	*schedule = *updated
+
+	if err := cancelPendingScheduleRuns(tx, schedule.ID, "Schedule was updated"); err != nil {
+		return err
+	}
+
+	if schedule.Enabled {
+		if err := wm.materializeSchedule(tx, schedule, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit schedule: %w", err)
+	}
+
+	return nil
+}
+
+func (wm *WorkflowManager) DeleteSchedule(workflowID, scheduleID int) error {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := deleteSchedule(tx, workflowID, scheduleID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit schedule deletion: %w", err)
+	}
+
+	return nil
+}
+
+func deleteSchedule(tx *sql.Tx, workflowID, scheduleID int) error {
+	result, err := tx.Exec(`DELETE FROM workflow_schedules WHERE id = $1 AND workflow_id = $2`, scheduleID, workflowID)
+	if err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrScheduleNotFound
+	}
+
+	return cancelPendingScheduleRuns(tx, scheduleID, "Schedule was deleted")
+}
+
+func cancelPendingScheduleRuns(tx *sql.Tx, scheduleID int, reason string) error {
+	query := `
+		UPDATE workflow_executions
+		SET status = $1, error_message = $2, completed_at = NOW(), updated_at = NOW()
+		WHERE schedule_id = $3 AND status = $4
+	`
+
+	if _, err := tx.Exec(query, ExecutionCancelled, reason, scheduleID, ExecutionPending); err != nil {
+		return fmt.Errorf("failed to cancel pending runs of schedule %d: %w", scheduleID, err)
+	}
+	return nil
+}
+
+// MaterializeSchedules makes sure every enabled schedule of an active workflow has its next
+// run queued in workflow_executions. A schedule gets a new run once its previous run has
+// finished, so this also advances schedules after each run. Schedules locked by another
+// instance are skipped.
+func (wm *WorkflowManager) MaterializeSchedules() (int, error) {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT s.id, s.workflow_id, s.cron_expression, s.timezone, s.parameters, s.missed_run_policy, s.enabled,
+		       s.next_run_at, s.last_scheduled_at, s.created_at, s.updated_at
+		FROM workflow_schedules s
+		JOIN workflows w ON w.id = s.workflow_id
+		WHERE s.enabled = TRUE AND w.status = $1
+		ORDER BY s.id
+		FOR UPDATE OF s SKIP LOCKED
+	`
+
+	rows, err := tx.Query(query, StatusActive)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load schedules: %w", err)
+	}
+
+	schedules := []*WorkflowSchedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating schedules: %w", err)
+	}
+
+	now := time.Now()
+	materialized := 0
+	for _, schedule := range schedules {
+		open, err := wm.resolveOpenScheduleRun(tx, schedule, now)
+		if err != nil {
+			return materialized, err
+		}
+		if open {
+			continue
+		}
+
+		if err := wm.materializeSchedule(tx, schedule, now); err != nil {
+			logger.Error("Failed to materialize schedule %d: %v", schedule.ID, err)
+			continue
+		}
+		materialized++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit materialized schedules: %w", err)
+	}
+
+	return materialized, nil
+}
+
+// resolveOpenScheduleRun reports whether the schedule still has an unfinished execution,
+// including one that waits for an approval or a sub-workflow. Overdue pending runs of skip schedules are cancelled as missed.
+func (wm *WorkflowManager) resolveOpenScheduleRun(tx *sql.Tx, schedule *WorkflowSchedule, now time.Time) (bool, error) {
+	query := `
+		SELECT id, status, scheduled_at
+		FROM workflow_executions
+		WHERE schedule_id = $1 AND status = ANY($2)
+		ORDER BY scheduled_at
+		LIMIT 1
+	`
+
+	var id int
+	var status ExecutionStatus
+	var scheduledAt time.Time
+	err := tx.QueryRow(query, schedule.ID, pq.Array(unfinishedStatuses)).Scan(&id, &status, &scheduledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check open runs of schedule %d: %w", schedule.ID, err)
+	}
+
+	if status != ExecutionPending || schedule.MissedRunPolicy != MissedRunSkip || !scheduledAt.Before(now.Add(-missedRunGrace)) {
+		return true, nil
+	}
+
+	cancelQuery := `
+		UPDATE workflow_executions
+		SET status = $1, error_message = $2, completed_at = NOW(), updated_at = NOW()
+		WHERE id = $3 AND status = $4
+	`
+	reason := fmt.Sprintf("Skipped missed run scheduled at %s", scheduledAt.Format(time.RFC3339))
+	if _, err := tx.Exec(cancelQuery, ExecutionCancelled, reason, id, ExecutionPending); err != nil {
+		return false, fmt.Errorf("failed to skip missed run %d: %w", id, err)
+	}
+
+	logger.Warn("Schedule %d: skipped missed run %d scheduled at %s", schedule.ID, id, scheduledAt.Format(time.RFC3339))
+	return false, nil
+}
+
+func (wm *WorkflowManager) materializeSchedule(tx *sql.Tx, schedule *WorkflowSchedule, now time.Time) error {
+	next, err := schedule.nextRun(now)
+	if err != nil {
+		return err
+	}
+
+	// Defaults and the concurrency key are applied per run so that runs pick up changes
+	// to the workflow.
+	workflow := &Workflow{}
+	var parameterSchema []byte
+	var concurrencyKey sql.NullString
+	err = tx.QueryRow(`SELECT parameter_schema, concurrency_key FROM workflows WHERE id = $1`, schedule.WorkflowID).Scan(&parameterSchema, &concurrencyKey)
+	if err != nil {
+		return fmt.Errorf("failed to load workflow %d: %w", schedule.WorkflowID, err)
+	}
+	workflow.ParameterSchema = parameterSchema
+	workflow.ConcurrencyKey = concurrencyKey.String
+	parameters, err := workflow.PrepareParameters(schedule.Parameters)
+	if err != nil {
+		return err
+	}
+	key, err := workflow.resolveConcurrencyKey(parameters)
+	if err != nil {
+		return err
+	}
+
+	insertQuery := `
+		INSERT INTO workflow_executions (workflow_id, status, parameters, scheduled_at, schedule_id, revision, concurrency_key, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, NULLIF(COALESCE(NULLIF(active_revision, 0), revision), 0), $6, NOW(), NOW()
+		FROM workflows
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(insertQuery, schedule.WorkflowID, ExecutionPending, parameters, next.UTC(), schedule.ID, nullString(key)); err != nil {
+		return fmt.Errorf("failed to queue run of schedule %d: %w", schedule.ID, err)
+	}
+
+	updateQuery := `
+		UPDATE workflow_schedules
+		SET next_run_at = $1, last_scheduled_at = $1, updated_at = NOW()
+		WHERE id = $2
+	`
+	if _, err := tx.Exec(updateQuery, next.UTC(), schedule.ID); err != nil {
+		return fmt.Errorf("failed to advance schedule %d: %w", schedule.ID, err)
+	}
+
+	schedule.NextRunAt = next
+	schedule.LastScheduledAt = next
+	logger.Debug("Schedule %d: queued run of workflow %d at %s", schedule.ID, schedule.WorkflowID, next.Format(time.RFC3339))
+	return nil
+}
+
+type Scheduler struct {
+	manager *WorkflowManager
+}
+
+func NewScheduler(manager *WorkflowManager) *Scheduler {
+	return &Scheduler{
+		manager: manager,
+	}
+}
+
+func (s *Scheduler) StartSchedulerLoop(ctx context.Context) {
+	logger.Info("Starting workflow scheduler loop")
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopping workflow scheduler loop")
+			return
+		case <-ticker.C:
+			if _, err := s.manager.MaterializeSchedules(); err != nil {
+				logger.Error("Error materializing workflow schedules: %v", err)
+			}
+		}
+	}
+}
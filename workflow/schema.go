@@ -0,0 +1,400 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is the subset of JSON Schema supported for workflow parameters: type,
+// properties, required, additionalProperties, items, enum, const, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern, minItems, maxItems
+// and default. Other keywords such as title, description and format are kept for UIs but
+// not enforced.
+type Schema struct {
+	Type                 schemaTypes        `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+
+	pattern    *regexp.Regexp
+	additional *Schema
+	noExtra    bool
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "null"].
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = multiple
+	return nil
+}
+
+var schemaTypeNames = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+}
+
+// FieldError is a validation failure of one parameter. Field is the path of the value,
+// such as "targets[0].name", or empty for the parameters as a whole.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ParameterValidationError lists every way in which parameters violate a schema.
+type ParameterValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ParameterValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		if fieldErr.Field == "" {
+			messages[i] = fieldErr.Message
+		} else {
+			messages[i] = fieldErr.Field + ": " + fieldErr.Message
+		}
+	}
+	return "invalid parameters: " + strings.Join(messages, "; ")
+}
+
+// ParseSchema parses and checks a parameter schema. The root schema must describe an
+// object, since execution parameters are always a JSON object.
+func ParseSchema(raw json.RawMessage) (*Schema, error) {
+	schema := &Schema{}
+	if err := json.Unmarshal(raw, schema); err != nil {
+		return nil, fmt.Errorf("invalid parameter schema: %w", err)
+	}
+	if err := schema.compile("parameter schema"); err != nil {
+		return nil, err
+	}
+	if len(schema.Type) > 0 && !reflect.DeepEqual([]string(schema.Type), []string{"object"}) {
+		return nil, fmt.Errorf("parameter schema must have type object")
+	}
+	return schema, nil
+}
+
+func (s *Schema) compile(path string) error {
+	for _, name := range s.Type {
+		if !schemaTypeNames[name] {
+			return fmt.Errorf("%s: unknown type %q", path, name)
+		}
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+		s.pattern = pattern
+	}
+
+	if len(s.AdditionalProperties) > 0 {
+		trimmed := bytes.TrimSpace(s.AdditionalProperties)
+		switch string(trimmed) {
+		case "true":
+		case "false":
+			s.noExtra = true
+		default:
+			s.additional = &Schema{}
+			if err := json.Unmarshal(trimmed, s.additional); err != nil {
+				return fmt.Errorf("%s: additionalProperties must be a boolean or a schema", path)
+			}
+			if err := s.additional.compile(path + ".additionalProperties"); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%s: property %s has no schema", path, name)
+		}
+		if err := property.compile(path + ".properties." + name); err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		if err := s.Items.compile(path + ".items"); err != nil {
+			return err
+		}
+	}
+
+	if s.Default != nil {
+		if errs := s.validate(path+".default", s.Default); len(errs) > 0 {
+			return fmt.Errorf("%s: default does not match the schema: %s", path, errs[0].Message)
+		}
+	}
+
+	return nil
+}
+
+// Prepare validates parameters against the schema and returns them with defaults applied
+// for missing properties.
+func (s *Schema) Prepare(parameters json.RawMessage) (json.RawMessage, error) {
+	params, err := decodeParameters(parameters)
+	if err != nil {
+		return nil, &ParameterValidationError{Errors: []FieldError{{Message: err.Error()}}}
+	}
+
+	withDefaults := s.applyDefaults(params)
+	if errs := s.validate("", withDefaults); len(errs) > 0 {
+		return nil, &ParameterValidationError{Errors: errs}
+	}
+
+	encoded, err := json.Marshal(withDefaults)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode parameters: %w", err)
+	}
+	return encoded, nil
+}
+
+func (s *Schema) applyDefaults(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, property := range s.Properties {
+			if current, ok := v[name]; ok {
+				v[name] = property.applyDefaults(current)
+			} else if property.Default != nil {
+				v[name] = property.applyDefaults(copyJSON(property.Default))
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				v[i] = s.Items.applyDefaults(item)
+			}
+		}
+	}
+	return value
+}
+
+func copyJSON(value interface{}) interface{} {
+	copied, err := normalizeJSON(value)
+	if err != nil {
+		return value
+	}
+	return copied
+}
+
+func (s *Schema) validate(path string, value interface{}) []FieldError {
+	fail := func(format string, args ...interface{}) []FieldError {
+		return []FieldError{{Field: path, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if len(s.Type) > 0 && !s.matchesType(value) {
+		return fail("must be of type %s, got %s", strings.Join(s.Type, " or "), jsonType(value))
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(copyJSON(allowed), value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			encoded, _ := json.Marshal(s.Enum)
+			return fail("must be one of %s", encoded)
+		}
+	}
+
+	if s.Const != nil && !reflect.DeepEqual(copyJSON(s.Const), value) {
+		encoded, _ := json.Marshal(s.Const)
+		return fail("must be %s", encoded)
+	}
+
+	errs := []FieldError{}
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errs = append(errs, fail("must be at least %v", *s.Minimum)...)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			errs = append(errs, fail("must be at most %v", *s.Maximum)...)
+		}
+		if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+			errs = append(errs, fail("must be greater than %v", *s.ExclusiveMinimum)...)
+		}
+		if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+			errs = append(errs, fail("must be less than %v", *s.ExclusiveMaximum)...)
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			errs = append(errs, fail("must be at least %d characters long", *s.MinLength)...)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			errs = append(errs, fail("must be at most %d characters long", *s.MaxLength)...)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			errs = append(errs, fail("must match pattern %s", s.Pattern)...)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			errs = append(errs, fail("must have at least %d items", *s.MinItems)...)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			errs = append(errs, fail("must have at most %d items", *s.MaxItems)...)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, FieldError{Field: joinField(path, name), Message: "is required"})
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			field := joinField(path, name)
+			if property, ok := s.Properties[name]; ok {
+				errs = append(errs, property.validate(field, v[name])...)
+				continue
+			}
+			switch {
+			case s.noExtra:
+				message := "is not an allowed parameter"
+				if suggestion := closestName(name, s.Properties); suggestion != "" {
+					message += fmt.Sprintf(" (did you mean %s?)", suggestion)
+				}
+				errs = append(errs, FieldError{Field: field, Message: message})
+			case s.additional != nil:
+				errs = append(errs, s.additional.validate(field, v[name])...)
+			}
+		}
+	}
+
+	return errs
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	actual := jsonType(value)
+	for _, name := range s.Type {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// closestName suggests a declared property for a misspelt parameter name.
+func closestName(name string, properties map[string]*Schema) string {
+	best, bestDistance := "", 3
+	for candidate := range properties {
+		if distance := editDistance(name, candidate); distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func (w *Workflow) validateParameterSchema() error {
+	if len(w.ParameterSchema) == 0 || string(w.ParameterSchema) == "null" {
+		w.ParameterSchema = nil
+		return nil
+	}
+	_, err := ParseSchema(w.ParameterSchema)
+	return err
+}
+
+// PrepareParameters checks parameters against the workflow's parameter schema and fills
+// in defaults. Without a schema the parameters are returned unchanged. Violations are
+// reported as a *ParameterValidationError.
+func (w *Workflow) PrepareParameters(parameters json.RawMessage) (json.RawMessage, error) {
+	if len(w.ParameterSchema) == 0 {
+		return parameters, nil
+	}
+	schema, err := ParseSchema(w.ParameterSchema)
+	if err != nil {
+		return nil, err
+	}
+	return schema.Prepare(parameters)
+}
@@ -0,0 +1,144 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["site"],
+	"additionalProperties": false,
+	"properties": {
+		"site": {"type": "string", "pattern": "^[a-z0-9-]+$"},
+		"max_age_days": {"type": "integer", "minimum": 1, "maximum": 365, "default": 30},
+		"mode": {"type": "string", "enum": ["report", "delete"], "default": "report"},
+		"targets": {
+			"type": "array",
+			"maxItems": 2,
+			"items": {
+				"type": "object",
+				"required": ["name"],
+				"properties": {"name": {"type": "string", "minLength": 1}, "port": {"type": "integer", "default": 22}}
+			}
+		}
+	}
+}`
+
+func TestParseSchema(t *testing.T) {
+	if _, err := ParseSchema(json.RawMessage(testSchema)); err != nil {
+		t.Fatalf("Expected schema to be valid, got %v", err)
+	}
+
+	tests := []struct {
+		schema string
+		errMsg string
+	}{
+		{`{"type": "array"}`, "must have type object"},
+		{`{"properties": {"a": {"type": "text"}}}`, "unknown type"},
+		{`{"properties": {"a": {"type": "string", "pattern": "("}}}`, "invalid pattern"},
+		{`{"properties": {"a": {"type": "integer", "default": "x"}}}`, "default does not match"},
+		{`{"additionalProperties": 1}`, "additionalProperties"},
+		{`[]`, "invalid parameter schema"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseSchema(json.RawMessage(tt.schema))
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.schema, tt.errMsg, err)
+		}
+	}
+}
+
+func TestSchemaPrepareAppliesDefaults(t *testing.T) {
+	schema, err := ParseSchema(json.RawMessage(testSchema))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	prepared, err := schema.Prepare(json.RawMessage(`{"site": "ams-1", "targets": [{"name": "sw1"}]}`))
+	if err != nil {
+		t.Fatalf("Expected parameters to be valid, got %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(prepared, &got); err != nil {
+		t.Fatalf("Failed to decode prepared parameters: %v", err)
+	}
+	expected := map[string]interface{}{
+		"site":         "ams-1",
+		"max_age_days": float64(30),
+		"mode":         "report",
+		"targets":      []interface{}{map[string]interface{}{"name": "sw1", "port": float64(22)}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestSchemaPrepareReportsFieldErrors(t *testing.T) {
+	schema, err := ParseSchema(json.RawMessage(testSchema))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	params := `{
+		"site": "AMS 1",
+		"max_age_dayz": 10,
+		"max_age_days": 1.5,
+		"mode": "purge",
+		"targets": [{"name": ""}, {"port": "22"}, {"name": "sw3"}]
+	}`
+	_, err = schema.Prepare(json.RawMessage(params))
+
+	var validationErr *ParameterValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ParameterValidationError, got %v", err)
+	}
+
+	expected := []FieldError{
+		{Field: "max_age_days", Message: "must be of type integer, got number"},
+		{Field: "max_age_dayz", Message: "is not an allowed parameter (did you mean max_age_days?)"},
+		{Field: "mode", Message: `must be one of ["report","delete"]`},
+		{Field: "site", Message: "must match pattern ^[a-z0-9-]+$"},
+		{Field: "targets", Message: "must have at most 2 items"},
+		{Field: "targets[0].name", Message: "must be at least 1 characters long"},
+		{Field: "targets[1].name", Message: "is required"},
+		{Field: "targets[1].port", Message: "must be of type integer, got string"},
+	}
+	if !reflect.DeepEqual(validationErr.Errors, expected) {
+		t.Errorf("Expected errors %v, got %v", expected, validationErr.Errors)
+	}
+}
+
+func TestSchemaPrepareRequiresObject(t *testing.T) {
+	schema, err := ParseSchema(json.RawMessage(testSchema))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	_, err = schema.Prepare(json.RawMessage(`null`))
+	var validationErr *ParameterValidationError
+	if !errors.As(err, &validationErr) || validationErr.Errors[0].Field != "site" {
+		t.Errorf("Expected missing site to be reported, got %v", err)
+	}
+
+	_, err = schema.Prepare(json.RawMessage(`[1, 2]`))
+	if !errors.As(err, &validationErr) {
+		t.Errorf("Expected a ParameterValidationError for an array, got %v", err)
+	}
+}
+
+func TestWorkflowPrepareParametersWithoutSchema(t *testing.T) {
+	params := json.RawMessage(`{"anything": true}`)
+	prepared, err := (&Workflow{}).PrepareParameters(params)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(prepared) != string(params) {
+		t.Errorf("Expected parameters to be unchanged, got %s", prepared)
+	}
+}
@@ -0,0 +1,509 @@
+package workflow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+
+	"github.com/holonet/core/logger"
+	"github.com/holonet/core/queue"
+)
+
+// NetboxRequester sends NetBox API requests on behalf of workflow code. It is satisfied
+// by netbox.Gatekeeper, so workflow requests share its rate limiting, queue and cache.
+type NetboxRequester interface {
+	Request(method, endpoint string, body interface{}) ([]byte, error)
+}
+
+// NetboxQueueRequester is a NetboxRequester whose request queue is shared fairly between
+// callers, such as netbox.Gatekeeper. Workflows then queue their requests as their own
+// caller, with the priority class of their execution policy.
+type NetboxQueueRequester interface {
+	NetboxRequester
+	RequestWithOptions(options queue.NetboxRequestOptions, method, endpoint string, body interface{}) ([]byte, error)
+}
+
+// workflowNetbox sends the NetBox requests of one workflow.
+type workflowNetbox struct {
+	requester NetboxQueueRequester
+	options   queue.NetboxRequestOptions
+}
+
+func (n *workflowNetbox) Request(method, endpoint string, body interface{}) ([]byte, error) {
+	return n.requester.RequestWithOptions(n.options, method, endpoint, body)
+}
+
+func (n *workflowNetbox) requestWithKey(key, method, endpoint string, body interface{}) ([]byte, error) {
+	options := n.options
+	options.IdempotencyKey = key
+	return n.requester.RequestWithOptions(options, method, endpoint, body)
+}
+
+// keyedNetboxRequester is a NetboxRequester that can send a request with an idempotency
+// key, so that sending it again returns the outcome of the first request.
+type keyedNetboxRequester interface {
+	requestWithKey(key, method, endpoint string, body interface{}) ([]byte, error)
+}
+
+// netboxFor returns the NetBox client the code of a workflow uses.
+func netboxFor(netbox NetboxRequester, workflow *Workflow) NetboxRequester {
+	requester, ok := netbox.(NetboxQueueRequester)
+	if !ok {
+		return netbox
+	}
+	return &workflowNetbox{
+		requester: requester,
+		options: queue.NetboxRequestOptions{
+			Priority: workflow.NetboxPriority,
+			Caller:   fmt.Sprintf("workflow:%d", workflow.ID),
+		},
+	}
+}
+
+// CacheStore is the key/value store behind holonet.CacheGet and holonet.CacheSet. It is
+// satisfied by cache.CacheClient.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+}
+
+var (
+	ErrNetboxUnavailable = errors.New("NetBox is not configured")
+	ErrCacheUnavailable  = errors.New("cache is not configured")
+
+	// errRunAbandoned is returned to workflow code that keeps running after its run was
+	// cancelled or timed out.
+	errRunAbandoned = errors.New("workflow run was cancelled")
+)
+
+// maxNetboxListPages bounds how many pages holonet.NetboxList follows.
+const maxNetboxListPages = 100
+
+// maxNetboxKeyLength is the length of the idempotency_key column of netbox_requests.
+const maxNetboxKeyLength = 255
+
+// SetNetbox makes NetBox available to workflow code. Until it is called, NetBox calls
+// from workflows fail with ErrNetboxUnavailable.
+func (rt *Runtime) SetNetbox(netbox NetboxRequester) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.netbox = netbox
+}
+
+// SetSecrets makes secrets available to workflow code. Until it is called,
+// holonet.Secret fails with ErrSecretsUnavailable.
+func (rt *Runtime) SetSecrets(secrets SecretResolver) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.secrets = secrets
+}
+
+// SetCache makes the cache available to workflow code.
+func (rt *Runtime) SetCache(cache CacheStore) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.cache = cache
+}
+
+func (e *Executor) SetNetbox(netbox NetboxRequester) {
+	e.runtime.SetNetbox(netbox)
+}
+
+func (e *Executor) SetCache(cache CacheStore) {
+	e.runtime.SetCache(cache)
+}
+
+func (e *Executor) SetSecrets(secrets SecretResolver) {
+	e.runtime.SetSecrets(secrets)
+}
+
+// netboxRequester returns the NetBox client set with SetNetbox, or nil.
+func (rt *Runtime) netboxRequester() NetboxRequester {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.netbox
+}
+
+// newHost returns the host API of an execution. Dry runs get a recorder in place of
+// NetBox, so that their writes end up in the plan.
+func (rt *Runtime) newHost(ctx context.Context, workflow *Workflow, execution *WorkflowExecution, params map[string]interface{}) *hostAPI {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	h := &hostAPI{
+		ctx:         ctx,
+		workflowID:  workflow.ID,
+		executionID: execution.ID,
+		params:      params,
+		netbox:      netboxFor(rt.netbox, workflow),
+		cache:       rt.cache,
+		secrets:     rt.secrets,
+		logs:        rt.logs,
+		netboxScope: "main",
+	}
+	if execution.Mode == ModeDryRun {
+		h.plan = newPlanRecorder(h.netbox)
+		h.netbox = h.plan
+	}
+	return h
+}
+
+// hostAPI backs the "holonet" package that is importable from workflow code.
+type hostAPI struct {
+	ctx         context.Context
+	workflowID  int
+	executionID int
+	params      map[string]interface{}
+	netbox      NetboxRequester
+	cache       CacheStore
+	secrets     SecretResolver
+	logs        *executionLogs
+	plan        *planRecorder
+	// abandoned is set once the run is cancelled or timed out, after which host calls fail.
+	abandoned atomic.Bool
+
+	mu     sync.Mutex
+	output interface{}
+	// netboxScope names the step whose code is running and netboxCreates counts the NetBox
+	// objects it created, which identifies each create when the execution runs again.
+	netboxScope   string
+	netboxCreates int
+}
+
+// active returns an error once the run is cancelled, timed out or abandoned.
+func (h *hostAPI) active() error {
+	if h.abandoned.Load() {
+		return errRunAbandoned
+	}
+	return h.ctx.Err()
+}
+
+func (h *hostAPI) exports() interp.Exports {
+	return interp.Exports{
+		"holonet/holonet": {
+			"Params": reflect.ValueOf(func() map[string]interface{} {
+				params := make(map[string]interface{}, len(h.params))
+				for k, v := range h.params {
+					params[k] = v
+				}
+				return params
+			}),
+			"Param": reflect.ValueOf(func(name string) interface{} {
+				return h.params[name]
+			}),
+			"ParamString": reflect.ValueOf(h.paramString),
+			"ParamInt":    reflect.ValueOf(h.paramInt),
+			"ParamBool":   reflect.ValueOf(h.paramBool),
+			"SetResult":   reflect.ValueOf(h.setResult),
+
+			"ExecutionID": reflect.ValueOf(func() int { return h.executionID }),
+			"WorkflowID":  reflect.ValueOf(func() int { return h.workflowID }),
+
+			"LogInfo":  reflect.ValueOf(h.logFunc(logger.Info, LogInfo)),
+			"LogWarn":  reflect.ValueOf(h.logFunc(logger.Warn, LogWarn)),
+			"LogError": reflect.ValueOf(h.logFunc(logger.Error, LogError)),
+
+			"NetboxGet":    reflect.ValueOf(h.netboxGet),
+			"NetboxList":   reflect.ValueOf(h.netboxList),
+			"NetboxCreate": reflect.ValueOf(h.netboxCreate),
+			"NetboxUpdate": reflect.ValueOf(h.netboxUpdate),
+			"NetboxDelete": reflect.ValueOf(h.netboxDelete),
+
+			"CacheGet": reflect.ValueOf(h.cacheGet),
+			"CacheSet": reflect.ValueOf(h.cacheSet),
+
+			"Secret": reflect.ValueOf(h.secret),
+		},
+	}
+}
+
+func (h *hostAPI) setResult(v interface{}) {
+	if h.active() != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.output = v
+}
+
+func (h *hostAPI) result() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.output
+}
+
+func (h *hostAPI) paramString(name string) string {
+	switch v := h.params[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (h *hostAPI) paramInt(name string) (int, error) {
+	switch v := h.params[name].(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("parameter %s is not an integer: %v", name, v)
+		}
+		return int(v), nil
+	case string:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("parameter %s is not an integer: %q", name, v)
+		}
+		return n, nil
+	case nil:
+		return 0, fmt.Errorf("parameter %s is not set", name)
+	default:
+		return 0, fmt.Errorf("parameter %s is not an integer: %v", name, v)
+	}
+}
+
+func (h *hostAPI) paramBool(name string) bool {
+	switch v := h.params[name].(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// logFunc writes to the server log and to the execution log.
+func (h *hostAPI) logFunc(log func(format string, v ...interface{}), stream LogStream) func(format string, args ...interface{}) {
+	return func(format string, args ...interface{}) {
+		if h.active() != nil {
+			return
+		}
+		message := h.logs.redact(h.executionID, fmt.Sprintf(format, args...))
+		log("[workflow %d execution %d] %s", h.workflowID, h.executionID, message)
+		h.logs.append(h.executionID, stream, message)
+	}
+}
+
+func (h *hostAPI) netboxRequest(method, endpoint string, body interface{}) (interface{}, error) {
+	if h.netbox == nil {
+		return nil, ErrNetboxUnavailable
+	}
+	if err := h.active(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var err error
+	if keyed, ok := h.netbox.(keyedNetboxRequester); ok && method == http.MethodPost && h.executionID != 0 {
+		// A rerun of the execution gets the outcome of the first create instead of
+		// creating the object again.
+		data, err = keyed.requestWithKey(h.nextNetboxKey(), method, endpoint, body)
+	} else {
+		data, err = h.netbox.Request(method, endpoint, body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("NetBox %s %s failed: %w", method, endpoint, err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode NetBox response: %w", err)
+	}
+	return decoded, nil
+}
+
+// enterScope makes the NetBox creates that follow count as those of scope, such as a
+// step of the workflow.
+func (h *hostAPI) enterScope(scope string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.netboxScope = scope
+	h.netboxCreates = 0
+}
+
+// nextNetboxKey returns the idempotency key of the next NetBox create of the running
+// scope. It is the same every time the execution runs, as long as the scope creates
+// its objects in the same order.
+func (h *hostAPI) nextNetboxKey() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.netboxCreates++
+	return netboxKey(h.executionID, h.netboxScope, h.netboxCreates)
+}
+
+// netboxKey is the idempotency key of the n-th NetBox create of scope in an execution.
+// Scopes too long for the key are hashed.
+func netboxKey(executionID int, scope string, n int) string {
+	key := fmt.Sprintf("execution:%d:%s:%d", executionID, scope, n)
+	if len(key) <= maxNetboxKeyLength {
+		return key
+	}
+	sum := sha256.Sum256([]byte(scope))
+	return fmt.Sprintf("execution:%d:%s:%d", executionID, hex.EncodeToString(sum[:]), n)
+}
+
+func (h *hostAPI) netboxObject(method, endpoint string, body interface{}) (map[string]interface{}, error) {
+	decoded, err := h.netboxRequest(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected NetBox response to %s %s", method, endpoint)
+	}
+	return object, nil
+}
+
+// netboxGet fetches a single object or any other JSON document, such as "status/".
+func (h *hostAPI) netboxGet(endpoint string, query map[string]interface{}) (map[string]interface{}, error) {
+	return h.netboxObject(http.MethodGet, netboxEndpoint(endpoint, query), nil)
+}
+
+// netboxList returns the results of all pages of a list endpoint.
+func (h *hostAPI) netboxList(endpoint string, query map[string]interface{}) ([]interface{}, error) {
+	results := []interface{}{}
+	next := netboxEndpoint(endpoint, query)
+
+	for page := 0; next != ""; page++ {
+		if page == maxNetboxListPages {
+			return nil, fmt.Errorf("NetBox list %s has more than %d pages", endpoint, maxNetboxListPages)
+		}
+
+		object, err := h.netboxObject(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		items, _ := object["results"].([]interface{})
+		results = append(results, items...)
+
+		next = ""
+		if nextURL, ok := object["next"].(string); ok && nextURL != "" {
+			// next is an absolute URL, while the gatekeeper expects a path below /api/.
+			if _, path, found := strings.Cut(nextURL, "/api/"); found {
+				next = path
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (h *hostAPI) netboxCreate(endpoint string, body map[string]interface{}) (map[string]interface{}, error) {
+	return h.netboxObject(http.MethodPost, netboxEndpoint(endpoint, nil), body)
+}
+
+func (h *hostAPI) netboxUpdate(endpoint string, id int, body map[string]interface{}) (map[string]interface{}, error) {
+	return h.netboxObject(http.MethodPatch, netboxObjectEndpoint(endpoint, id), body)
+}
+
+func (h *hostAPI) netboxDelete(endpoint string, id int) error {
+	_, err := h.netboxRequest(http.MethodDelete, netboxObjectEndpoint(endpoint, id), nil)
+	return err
+}
+
+// netboxEndpoint normalizes an endpoint such as "/api/dcim/devices" to the
+// "dcim/devices/" form the gatekeeper expects and appends the query.
+func netboxEndpoint(endpoint string, query map[string]interface{}) string {
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "/"), "api/")
+	path, rawQuery, _ := strings.Cut(endpoint, "?")
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	values, _ := url.ParseQuery(rawQuery)
+	for key, value := range query {
+		if list, ok := value.([]interface{}); ok {
+			for _, item := range list {
+				values.Add(key, fmt.Sprint(item))
+			}
+			continue
+		}
+		values.Set(key, fmt.Sprint(value))
+	}
+
+	if len(values) == 0 {
+		return path
+	}
+	return path + "?" + values.Encode()
+}
+
+func netboxObjectEndpoint(endpoint string, id int) string {
+	return netboxEndpoint(strings.TrimSuffix(netboxEndpoint(endpoint, nil), "/")+"/"+strconv.Itoa(id), nil)
+}
+
+func (h *hostAPI) cacheKey(key string) string {
+	return fmt.Sprintf("holonet:workflow:%d:%s", h.workflowID, key)
+}
+
+// cacheGet returns the JSON-decoded value stored under key by the same workflow.
+func (h *hostAPI) cacheGet(key string) (interface{}, bool, error) {
+	if h.cache == nil {
+		return nil, false, ErrCacheUnavailable
+	}
+	if err := h.active(); err != nil {
+		return nil, false, err
+	}
+
+	data, found, err := h.cache.Get(h.ctx, h.cacheKey(key))
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached value: %w", err)
+	}
+	return value, true, nil
+}
+
+// cacheSet stores value as JSON under key. Keys are scoped to the workflow, and a
+// ttlSeconds of zero keeps the value until it is overwritten.
+func (h *hostAPI) cacheSet(key string, value interface{}, ttlSeconds int) error {
+	if h.cache == nil {
+		return ErrCacheUnavailable
+	}
+	if err := h.active(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %w", err)
+	}
+	return h.cache.Set(h.ctx, h.cacheKey(key), string(data), time.Duration(ttlSeconds)*time.Second)
+}
+
+// secret returns the value of a secret the workflow is allowed to read. The value is
+// masked in the execution's logs, result and step outputs from then on.
+func (h *hostAPI) secret(name string) (string, error) {
+	if h.secrets == nil {
+		return "", ErrSecretsUnavailable
+	}
+	if err := h.active(); err != nil {
+		return "", err
+	}
+	value, err := h.secrets.Resolve(h.workflowID, name)
+	if err != nil {
+		return "", err
+	}
+	h.logs.addSecret(h.executionID, value)
+	h.logs.append(h.executionID, LogSystem, "Read secret "+name)
+	return value, nil
+}
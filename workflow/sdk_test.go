@@ -0,0 +1,206 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/holonet/core/queue"
+)
+
+type fakeNetbox struct {
+	requests  []string
+	responses map[string]string
+}
+
+func (f *fakeNetbox) Request(method, endpoint string, body interface{}) ([]byte, error) {
+	f.requests = append(f.requests, method+" "+endpoint)
+	response, ok := f.responses[method+" "+endpoint]
+	if !ok {
+		return nil, errors.New("API request failed with status 404")
+	}
+	return []byte(response), nil
+}
+
+// fakeQueueNetbox records the options of the requests it gets.
+type fakeQueueNetbox struct {
+	fakeNetbox
+	options []queue.NetboxRequestOptions
+}
+
+func (f *fakeQueueNetbox) RequestWithOptions(options queue.NetboxRequestOptions, method, endpoint string, body interface{}) ([]byte, error) {
+	f.options = append(f.options, options)
+	return f.Request(method, endpoint, body)
+}
+
+type fakeCache map[string]string
+
+func (f fakeCache) Get(ctx context.Context, key string) (string, bool, error) {
+	value, ok := f[key]
+	return value, ok, nil
+}
+
+func (f fakeCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	f[key] = value
+	return nil
+}
+
+func TestNetboxEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		query    map[string]interface{}
+		expected string
+	}{
+		{"dcim/devices", nil, "dcim/devices/"},
+		{"/api/dcim/devices/", nil, "dcim/devices/"},
+		{"ipam/prefixes/", map[string]interface{}{"site": "ams1", "limit": 50}, "ipam/prefixes/?limit=50&site=ams1"},
+		{"dcim/devices/?role=leaf", map[string]interface{}{"tag": []interface{}{"a", "b"}}, "dcim/devices/?role=leaf&tag=a&tag=b"},
+	}
+
+	for _, tt := range tests {
+		if endpoint := netboxEndpoint(tt.endpoint, tt.query); endpoint != tt.expected {
+			t.Errorf("Expected %q for %q, got %q", tt.expected, tt.endpoint, endpoint)
+		}
+	}
+
+	if endpoint := netboxObjectEndpoint("/api/dcim/interfaces/", 12); endpoint != "dcim/interfaces/12/" {
+		t.Errorf("Expected dcim/interfaces/12/, got %q", endpoint)
+	}
+}
+
+func TestHostAPINetboxAndCache(t *testing.T) {
+	netbox := &fakeNetbox{responses: map[string]string{
+		"GET dcim/devices/?site=ams1":                  `{"count": 3, "next": "https://netbox.example.com/api/dcim/devices/?limit=2&offset=2&site=ams1", "results": [{"name": "a"}, {"name": "b"}]}`,
+		"GET dcim/devices/?limit=2&offset=2&site=ams1": `{"count": 3, "next": null, "results": [{"name": "c"}]}`,
+		"POST ipam/ip-addresses/":                      `{"id": 7, "address": "10.0.0.7/24"}`,
+	}}
+	cache := fakeCache{}
+
+	rt := NewRuntime()
+	rt.SetNetbox(netbox)
+	rt.SetCache(cache)
+
+	wf := &Workflow{ID: 4, Name: "sdk", Code: `
+package main
+
+import "holonet"
+
+func Run(params map[string]interface{}) (interface{}, error) {
+	devices, err := holonet.NetboxList("dcim/devices", map[string]interface{}{"site": holonet.ParamString("site")})
+	if err != nil {
+		return nil, err
+	}
+	ip, err := holonet.NetboxCreate("ipam/ip-addresses", map[string]interface{}{"address": "10.0.0.7/24"})
+	if err != nil {
+		return nil, err
+	}
+	if err := holonet.CacheSet("last_ip", ip["address"], 60); err != nil {
+		return nil, err
+	}
+	cached, found, err := holonet.CacheGet("last_ip")
+	if err != nil || !found {
+		return nil, err
+	}
+	holonet.LogInfo("allocated %v", cached)
+	return map[string]interface{}{"devices": len(devices), "ip": cached, "execution": holonet.ExecutionID()}, nil
+}
+`}
+
+	output, err := rt.RunExecution(context.Background(), wf, &WorkflowExecution{ID: 9, WorkflowID: 4, Parameters: []byte(`{"site": "ams1"}`)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result := output.Output.(map[string]interface{})
+	if result["devices"] != 3 || result["ip"] != "10.0.0.7/24" || result["execution"] != 9 {
+		t.Errorf("Unexpected output: %v", result)
+	}
+	if cache["holonet:workflow:4:last_ip"] != `"10.0.0.7/24"` {
+		t.Errorf("Expected cached value scoped to the workflow, got %v", cache)
+	}
+}
+
+func TestHostAPIWithoutNetbox(t *testing.T) {
+	rt := NewRuntime()
+	wf := &Workflow{Name: "sdk", Code: `
+package main
+
+import "holonet"
+
+func Run(params map[string]interface{}) (interface{}, error) {
+	_, err := holonet.NetboxGet("status", nil)
+	return nil, err
+}
+`}
+
+	_, err := rt.Run(context.Background(), wf, nil)
+	if err == nil || !strings.Contains(err.Error(), "NetBox is not configured") {
+		t.Errorf("Expected NetBox unavailable error, got %v", err)
+	}
+}
+
+func TestNetboxFor(t *testing.T) {
+	workflow := &Workflow{ID: 12}
+	workflow.NetboxPriority = queue.NetboxPriorityBulk
+
+	if netboxFor(nil, workflow) != nil {
+		t.Errorf("Expected no NetBox client without NetBox")
+	}
+
+	plain := &fakeNetbox{}
+	if netboxFor(plain, workflow) != plain {
+		t.Errorf("Expected a client without a queue to be used as is")
+	}
+
+	queued := &fakeQueueNetbox{fakeNetbox: fakeNetbox{responses: map[string]string{"GET status/": "{}"}}}
+	if _, err := netboxFor(queued, workflow).Request("GET", "status/", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(queued.options) != 1 {
+		t.Fatalf("Expected 1 request with options, got %d", len(queued.options))
+	}
+	if options := queued.options[0]; options.Caller != "workflow:12" || options.Priority != queue.NetboxPriorityBulk {
+		t.Errorf("Expected caller workflow:12 with priority bulk, got %s with %s", options.Caller, options.Priority)
+	}
+}
+
+func TestNetboxCreateIdempotencyKeys(t *testing.T) {
+	workflow := &Workflow{ID: 12}
+	queued := &fakeQueueNetbox{fakeNetbox: fakeNetbox{responses: map[string]string{
+		"GET dcim/sites/":  `{"results": []}`,
+		"POST dcim/sites/": `{"id": 1}`,
+	}}}
+
+	run := func() []string {
+		queued.options = nil
+		h := &hostAPI{ctx: context.Background(), workflowID: 12, executionID: 5, netbox: netboxFor(queued, workflow), netboxScope: "main"}
+		h.enterScope("step:sites")
+		h.netboxList("dcim/sites", nil)
+		h.netboxCreate("dcim/sites", map[string]interface{}{"name": "a"})
+		h.netboxCreate("dcim/sites", map[string]interface{}{"name": "b"})
+		h.enterScope("step:more")
+		h.netboxCreate("dcim/sites", map[string]interface{}{"name": "c"})
+
+		keys := []string{}
+		for _, options := range queued.options {
+			keys = append(keys, options.IdempotencyKey)
+		}
+		return keys
+	}
+
+	keys := run()
+	expected := []string{"", "execution:5:step:sites:1", "execution:5:step:sites:2", "execution:5:step:more:1"}
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected keys %v, got %v", expected, keys)
+	}
+	if again := run(); strings.Join(again, ",") != strings.Join(keys, ",") {
+		t.Errorf("Expected a rerun to use the same keys %v, got %v", keys, again)
+	}
+
+	long := netboxKey(5, "step:"+strings.Repeat("x", 300), 1)
+	if len(long) > maxNetboxKeyLength {
+		t.Errorf("Expected a key of at most %d characters, got %d", maxNetboxKeyLength, len(long))
+	}
+}
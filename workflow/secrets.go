@@ -0,0 +1,428 @@
+package workflow
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/logger"
+)
+
+// redactedValue replaces secret values in everything an execution stores.
+const redactedValue = "[REDACTED]"
+
+var (
+	ErrSecretNotFound     = errors.New("secret not found")
+	ErrSecretExists       = errors.New("a secret with this name already exists")
+	ErrSecretNotAllowed   = errors.New("workflow is not allowed to read this secret")
+	ErrSecretsDisabled    = errors.New("secrets are disabled, set SECRETS_KEY to enable them")
+	ErrSecretsUnavailable = errors.New("secrets are not configured")
+)
+
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]{0,254}$`)
+
+// SecretResolver returns the value of a secret to the code of a workflow. It is satisfied
+// by SecretStore.
+type SecretResolver interface {
+	Resolve(workflowID int, name string) (string, error)
+}
+
+// Secret is a named credential that workflow code reads with holonet.Secret. Its value is
+// stored encrypted and never returned by the API; only the workflows listed in
+// WorkflowIDs can read it.
+type Secret struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	WorkflowIDs []int     `json:"workflow_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (s *Secret) Validate() error {
+	if !secretNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid secret name %q: use letters, digits, '_', '.' and '-', starting with a letter or '_'", s.Name)
+	}
+	for _, id := range s.WorkflowIDs {
+		if id <= 0 {
+			return fmt.Errorf("invalid workflow ID %d", id)
+		}
+	}
+	return nil
+}
+
+// SecretStore keeps secrets encrypted with AES-256-GCM under a key derived from
+// SECRETS_KEY. Without a key, secrets can neither be stored nor read.
+type SecretStore struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+// NewSecretStore reads the key from the file named by SECRETS_KEY_FILE, or else from
+// SECRETS_KEY, which is then removed from the environment of the process.
+func NewSecretStore(manager *WorkflowManager) *SecretStore {
+	key, err := secretsKey()
+	if err != nil {
+		logger.Error("Failed to read secrets key, workflow secrets are disabled: %v", err)
+	} else if key == "" {
+		logger.Warn("SECRETS_KEY is not set, workflow secrets are disabled")
+	}
+	return newSecretStore(manager.db, key)
+}
+
+func secretsKey() (string, error) {
+	key := os.Getenv("SECRETS_KEY")
+	if err := os.Unsetenv("SECRETS_KEY"); err != nil {
+		return "", fmt.Errorf("failed to unset SECRETS_KEY: %w", err)
+	}
+	if path := os.Getenv("SECRETS_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read SECRETS_KEY_FILE: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return key, nil
+}
+
+func newSecretStore(db *sql.DB, key string) *SecretStore {
+	store := &SecretStore{db: db}
+	if key == "" {
+		return store
+	}
+	hashedKey := sha256.Sum256([]byte(key))
+	block, _ := aes.NewCipher(hashedKey[:])
+	store.aead, _ = cipher.NewGCM(block)
+	return store
+}
+
+func (s *SecretStore) encrypt(value string) (string, error) {
+	if s.aead == nil {
+		return "", ErrSecretsDisabled
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *SecretStore) decrypt(encrypted string) (string, error) {
+	if s.aead == nil {
+		return "", ErrSecretsDisabled
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret: %w", err)
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("failed to decrypt secret: value is too short")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	value, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret, was SECRETS_KEY changed?: %w", err)
+	}
+	return string(value), nil
+}
+
+const secretColumns = `id, name, description, workflow_ids, created_at, updated_at`
+
+func (s *SecretStore) CreateSecret(secret *Secret, value string) error {
+	if err := secret.Validate(); err != nil {
+		return err
+	}
+	encrypted, err := s.encrypt(value)
+	if err != nil {
+		return err
+	}
+	workflowIDs, err := json.Marshal(normalizeWorkflowIDs(secret.WorkflowIDs))
+	if err != nil {
+		return fmt.Errorf("failed to encode workflow IDs: %w", err)
+	}
+
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM workflow_secrets WHERE name = $1)`, secret.Name).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check secret name: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrSecretExists, secret.Name)
+	}
+
+	query := `
+		INSERT INTO workflow_secrets (name, description, value, workflow_ids, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		RETURNING ` + secretColumns
+
+	created, err := scanSecret(s.db.QueryRow(query, secret.Name, nullString(secret.Description), encrypted, workflowIDs))
+	if err != nil {
+		return fmt.Errorf("failed to create secret: %w", err)
+	}
+	*secret = *created
+
+	logger.Info("Created secret %s for workflow(s) %v", secret.Name, secret.WorkflowIDs)
+	return nil
+}
+
+// UpdateSecret changes the description and workflows of a secret, and its value unless
+// value is nil. Secrets cannot be renamed, since workflow code refers to them by name.
+func (s *SecretStore) UpdateSecret(secret *Secret, value *string) error {
+	if err := secret.Validate(); err != nil {
+		return err
+	}
+	workflowIDs, err := json.Marshal(normalizeWorkflowIDs(secret.WorkflowIDs))
+	if err != nil {
+		return fmt.Errorf("failed to encode workflow IDs: %w", err)
+	}
+
+	var encrypted sql.NullString
+	if value != nil {
+		if encrypted.String, err = s.encrypt(*value); err != nil {
+			return err
+		}
+		encrypted.Valid = true
+	}
+
+	query := `
+		UPDATE workflow_secrets
+		SET description = $1, workflow_ids = $2, value = COALESCE($3, value), updated_at = NOW()
+		WHERE id = $4
+		RETURNING ` + secretColumns
+
+	updated, err := scanSecret(s.db.QueryRow(query, nullString(secret.Description), workflowIDs, encrypted, secret.ID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSecretNotFound
+		}
+		return fmt.Errorf("failed to update secret: %w", err)
+	}
+	*secret = *updated
+
+	logger.Info("Updated secret %s", secret.Name)
+	return nil
+}
+
+func (s *SecretStore) GetSecret(id int) (*Secret, error) {
+	query := `SELECT ` + secretColumns + ` FROM workflow_secrets WHERE id = $1`
+	secret, err := scanSecret(s.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSecretNotFound
+		}
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+	return secret, nil
+}
+
+func (s *SecretStore) ListSecrets() ([]*Secret, error) {
+	rows, err := s.db.Query(`SELECT ` + secretColumns + ` FROM workflow_secrets ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	defer rows.Close()
+
+	secrets := []*Secret{}
+	for rows.Next() {
+		secret, err := scanSecret(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, rows.Err()
+}
+
+func (s *SecretStore) DeleteSecret(id int) error {
+	result, err := s.db.Exec(`DELETE FROM workflow_secrets WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSecretNotFound
+	}
+	return nil
+}
+
+// Resolve decrypts the secret name for the code of a workflow, which must be one of the
+// secret's workflows.
+func (s *SecretStore) Resolve(workflowID int, name string) (string, error) {
+	var encrypted string
+	var workflowIDs []byte
+	query := `SELECT value, workflow_ids FROM workflow_secrets WHERE name = $1`
+	if err := s.db.QueryRow(query, name).Scan(&encrypted, &workflowIDs); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+		}
+		return "", fmt.Errorf("failed to get secret: %w", err)
+	}
+
+	var allowed []int
+	if err := json.Unmarshal(workflowIDs, &allowed); err != nil {
+		return "", fmt.Errorf("failed to decode workflow IDs: %w", err)
+	}
+	if !containsInt(allowed, workflowID) {
+		logger.Warn("Workflow %d was denied access to secret %s", workflowID, name)
+		return "", fmt.Errorf("%w: %s", ErrSecretNotAllowed, name)
+	}
+
+	return s.decrypt(encrypted)
+}
+
+func scanSecret(row rowScanner) (*Secret, error) {
+	secret := &Secret{}
+	var description sql.NullString
+	var workflowIDs []byte
+	err := row.Scan(&secret.ID, &secret.Name, &description, &workflowIDs, &secret.CreatedAt, &secret.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	secret.Description = description.String
+	secret.WorkflowIDs = []int{}
+	if len(workflowIDs) > 0 {
+		if err := json.Unmarshal(workflowIDs, &secret.WorkflowIDs); err != nil {
+			return nil, fmt.Errorf("failed to decode workflow IDs: %w", err)
+		}
+	}
+	return secret, nil
+}
+
+// normalizeWorkflowIDs returns ids sorted and without duplicates.
+func normalizeWorkflowIDs(ids []int) []int {
+	result := []int{}
+	for _, id := range ids {
+		if !containsInt(result, id) {
+			result = append(result, id)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// addSecret makes the log and everything else the executor stores for an execution mask
+// value from now on.
+func (l *executionLogs) addSecret(executionID int, value string) {
+	if l == nil || value == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !containsString(l.secrets[executionID], value) {
+		l.secrets[executionID] = append(l.secrets[executionID], value)
+		// Longer values first, so that a secret containing another is masked whole.
+		sort.Slice(l.secrets[executionID], func(i, j int) bool {
+			return len(l.secrets[executionID][i]) > len(l.secrets[executionID][j])
+		})
+	}
+}
+
+// redact masks the secrets an execution has read in s.
+func (l *executionLogs) redact(executionID int, s string) string {
+	if l == nil {
+		return s
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return redactValues(l.secrets[executionID], s)
+}
+
+// redactJSON masks the secrets an execution has read in the strings of a JSON document.
+// Numbers and other values are left as they are.
+func (l *executionLogs) redactJSON(executionID int, data json.RawMessage) json.RawMessage {
+	if l == nil || len(data) == 0 {
+		return data
+	}
+	l.mu.Lock()
+	values := l.secrets[executionID]
+	l.mu.Unlock()
+	if len(values) == 0 {
+		return data
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return data
+	}
+	redacted, err := json.Marshal(redactTree(values, decoded))
+	if err != nil {
+		return data
+	}
+	return redacted
+}
+
+// redactError masks the secrets an execution has read in the message of err, keeping
+// err itself for errors.Is.
+func (l *executionLogs) redactError(executionID int, err error) error {
+	if err == nil {
+		return nil
+	}
+	message := l.redact(executionID, err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, message: message}
+}
+
+type redactedError struct {
+	err     error
+	message string
+}
+
+func (e *redactedError) Error() string { return e.message }
+func (e *redactedError) Unwrap() error { return e.err }
+
+func redactValues(values []string, s string) string {
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, redactedValue)
+	}
+	return s
+}
+
+func redactTree(values []string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return redactValues(values, v)
+	case []interface{}:
+		for i := range v {
+			v[i] = redactTree(values, v[i])
+		}
+		return v
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			redacted[redactValues(values, key)] = redactTree(values, value)
+		}
+		return redacted
+	default:
+		return v
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretEncryption(t *testing.T) {
+	store := newSecretStore(nil, "test-key")
+
+	encrypted, err := store.encrypt("s3cret")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Contains(encrypted, "s3cret") {
+		t.Errorf("Expected value to be encrypted, got %s", encrypted)
+	}
+	again, _ := store.encrypt("s3cret")
+	if again == encrypted {
+		t.Error("Expected a fresh nonce for every encryption")
+	}
+
+	value, err := store.decrypt(encrypted)
+	if err != nil || value != "s3cret" {
+		t.Errorf("Expected s3cret, got %q (%v)", value, err)
+	}
+
+	if _, err := newSecretStore(nil, "other-key").decrypt(encrypted); err == nil {
+		t.Error("Expected error when decrypting with another key")
+	}
+	if _, err := newSecretStore(nil, "").encrypt("s3cret"); !errors.Is(err, ErrSecretsDisabled) {
+		t.Errorf("Expected ErrSecretsDisabled without a key, got %v", err)
+	}
+}
+
+func TestSecretsKey(t *testing.T) {
+	t.Setenv("SECRETS_KEY", "from-env")
+	t.Setenv("SECRETS_KEY_FILE", "")
+
+	key, err := secretsKey()
+	if err != nil || key != "from-env" {
+		t.Errorf("Expected key from SECRETS_KEY, got '%s' (%v)", key, err)
+	}
+	if _, ok := os.LookupEnv("SECRETS_KEY"); ok {
+		t.Error("Expected SECRETS_KEY to be removed from the environment")
+	}
+
+	path := filepath.Join(t.TempDir(), "secrets_key")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRETS_KEY", "from-env")
+	t.Setenv("SECRETS_KEY_FILE", path)
+	key, err = secretsKey()
+	if err != nil || key != "from-file" {
+		t.Errorf("Expected key from SECRETS_KEY_FILE, got '%s' (%v)", key, err)
+	}
+	if _, ok := os.LookupEnv("SECRETS_KEY"); ok {
+		t.Error("Expected SECRETS_KEY to be removed from the environment")
+	}
+
+	t.Setenv("SECRETS_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := secretsKey(); err == nil {
+		t.Error("Expected an error for a missing SECRETS_KEY_FILE")
+	}
+}
+
+func TestSecretValidate(t *testing.T) {
+	tests := []struct {
+		secret Secret
+		valid  bool
+	}{
+		{Secret{Name: "switch_password"}, true},
+		{Secret{Name: "vendor.api-token", WorkflowIDs: []int{1, 2}}, true},
+		{Secret{Name: ""}, false},
+		{Secret{Name: "1password"}, false},
+		{Secret{Name: "has space"}, false},
+		{Secret{Name: "token", WorkflowIDs: []int{0}}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.secret.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("Expected %q to be valid, got %v", tt.secret.Name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Expected %q with workflows %v to be invalid", tt.secret.Name, tt.secret.WorkflowIDs)
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	logs := newExecutionLogs(nil)
+	logs.addSecret(7, "hunter2")
+	logs.addSecret(7, "hunter2-admin")
+
+	if got := logs.redact(7, "login with hunter2-admin and hunter2"); got != "login with [REDACTED] and [REDACTED]" {
+		t.Errorf("Expected both secrets to be masked, got %q", got)
+	}
+	if got := logs.redact(8, "hunter2"); got != "hunter2" {
+		t.Errorf("Expected other executions to be unaffected, got %q", got)
+	}
+
+	redacted := logs.redactJSON(7, []byte(`{"output":{"password":"hunter2","port":22,"hunter2":["x hunter2"]}}`))
+	if strings.Contains(string(redacted), "hunter2") || !strings.Contains(string(redacted), `"port":22`) {
+		t.Errorf("Expected strings to be masked and numbers kept, got %s", redacted)
+	}
+
+	err := logs.redactError(7, fmt.Errorf("%w: bad password hunter2", ErrApprovalRejected))
+	if err.Error() != "approval rejected: bad password [REDACTED]" {
+		t.Errorf("Expected error message to be masked, got %q", err)
+	}
+	if !errors.Is(err, ErrApprovalRejected) {
+		t.Error("Expected redacted error to wrap the original error")
+	}
+
+	logs.finish(7)
+	if got := logs.redact(7, "hunter2"); got != "hunter2" {
+		t.Errorf("Expected secrets to be released when the execution finishes, got %q", got)
+	}
+}
+
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) Resolve(workflowID int, name string) (string, error) {
+	if workflowID != 1 {
+		return "", ErrSecretNotAllowed
+	}
+	value, ok := f[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func TestHostSecret(t *testing.T) {
+	h := &hostAPI{ctx: context.Background(), workflowID: 1, secrets: fakeSecrets{"token": "abc123"}, logs: newExecutionLogs(nil)}
+
+	value, err := h.secret("token")
+	if err != nil || value != "abc123" {
+		t.Fatalf("Expected abc123, got %q (%v)", value, err)
+	}
+	if got := h.logs.redact(h.executionID, "Bearer abc123"); got != "Bearer [REDACTED]" {
+		t.Errorf("Expected read secrets to be masked, got %q", got)
+	}
+
+	if _, err := h.secret("missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+	h.workflowID = 2
+	if _, err := h.secret("token"); !errors.Is(err, ErrSecretNotAllowed) {
+		t.Errorf("Expected ErrSecretNotAllowed, got %v", err)
+	}
+	if _, err := (&hostAPI{}).secret("token"); !errors.Is(err, ErrSecretsUnavailable) {
+		t.Errorf("Expected ErrSecretsUnavailable, got %v", err)
+	}
+}
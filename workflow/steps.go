@@ -0,0 +1,448 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Step is one node of a multi-step workflow. Function names a top-level function of the
+// workflow code with the signature func(map[string]interface{}) (interface{}, error); it
+// is called with the resolved Inputs once every step in DependsOn has completed.
+//
+// Input values and When may reference workflow parameters and the output of earlier
+// steps with ${params.<name>} and ${steps.<step>.output[.<field>...]}. A step whose When
+// condition is false is skipped, and so is every step depending on it.
+//
+// Compensate optionally names a function with the same signature that undoes the step.
+// It is called with {"input": ..., "output": ...} of the step when the execution fails
+// for good or is cancelled after the step completed.
+//
+// A step with Approval instead of Function waits for a manual sign-off; its inputs are
+// shown to the approvers. A step with Workflow instead of Function runs another workflow
+// with its inputs as parameters.
+type Step struct {
+	Name       string                 `json:"name"`
+	Function   string                 `json:"function"`
+	DependsOn  []string               `json:"depends_on,omitempty"`
+	Inputs     map[string]interface{} `json:"inputs,omitempty"`
+	When       string                 `json:"when,omitempty"`
+	Compensate string                 `json:"compensate,omitempty"`
+	Approval   *ApprovalGate          `json:"approval,omitempty"`
+	Workflow   *SubWorkflow           `json:"workflow,omitempty"`
+}
+
+var (
+	stepNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	refPattern      = regexp.MustCompile(`\$\{([^}]*)\}`)
+)
+
+// ValidateSteps checks a step definition on its own: unique names, known dependencies,
+// no cycles, parseable conditions and references only to parameters or to steps the
+// referencing step depends on, directly or transitively.
+func ValidateSteps(steps []Step) error {
+	byName := make(map[string]*Step, len(steps))
+	for i := range steps {
+		step := &steps[i]
+		if !stepNamePattern.MatchString(step.Name) {
+			return fmt.Errorf("invalid step name %q (use letters, digits, '_' and '-')", step.Name)
+		}
+		if _, exists := byName[step.Name]; exists {
+			return fmt.Errorf("duplicate step name %q", step.Name)
+		}
+		if step.Approval != nil && step.Workflow != nil {
+			return fmt.Errorf("step %q cannot be both an approval and a sub-workflow", step.Name)
+		}
+		if step.Workflow != nil {
+			if step.Function != "" || step.Compensate != "" {
+				return fmt.Errorf("sub-workflow step %q cannot have a function or compensate", step.Name)
+			}
+			if err := step.Workflow.validate(); err != nil {
+				return fmt.Errorf("step %q: %w", step.Name, err)
+			}
+		} else if step.Approval != nil {
+			if step.Function != "" || step.Compensate != "" {
+				return fmt.Errorf("approval step %q cannot have a function or compensate", step.Name)
+			}
+			if err := step.Approval.validate(); err != nil {
+				return fmt.Errorf("step %q: %w", step.Name, err)
+			}
+		} else if step.Function == "" {
+			return fmt.Errorf("step %q has no function", step.Name)
+		}
+		byName[step.Name] = step
+	}
+
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if dep == step.Name {
+				return fmt.Errorf("step %q depends on itself", step.Name)
+			}
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", step.Name, dep)
+			}
+		}
+	}
+
+	if _, err := orderSteps(steps); err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		ancestors := stepAncestors(step.Name, byName)
+
+		refs := collectRefs(step.Inputs)
+		if step.When != "" {
+			if _, err := parseCondition(step.When); err != nil {
+				return fmt.Errorf("step %q: %w", step.Name, err)
+			}
+			refs = append(refs, refPattern.FindAllStringSubmatch(step.When, -1)...)
+		}
+
+		for _, ref := range refs {
+			path, err := parseRef(ref[1])
+			if err != nil {
+				return fmt.Errorf("step %q: %w", step.Name, err)
+			}
+			if path[0] == "steps" && !ancestors[path[1]] {
+				return fmt.Errorf("step %q references step %q without depending on it", step.Name, path[1])
+			}
+		}
+	}
+
+	return nil
+}
+
+// orderSteps returns steps in an order where every step comes after its dependencies,
+// keeping the definition order among independent steps.
+func orderSteps(steps []Step) ([]Step, error) {
+	done := make(map[string]bool, len(steps))
+	ordered := make([]Step, 0, len(steps))
+
+	for len(ordered) < len(steps) {
+		progress := false
+		for _, step := range steps {
+			if done[step.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range step.DependsOn {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[step.Name] = true
+				ordered = append(ordered, step)
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, errors.New("step dependencies contain a cycle")
+		}
+	}
+
+	return ordered, nil
+}
+
+func stepAncestors(name string, byName map[string]*Step) map[string]bool {
+	ancestors := map[string]bool{}
+	queue := append([]string{}, byName[name].DependsOn...)
+	for len(queue) > 0 {
+		dep := queue[0]
+		queue = queue[1:]
+		if ancestors[dep] {
+			continue
+		}
+		ancestors[dep] = true
+		if step, ok := byName[dep]; ok {
+			queue = append(queue, step.DependsOn...)
+		}
+	}
+	return ancestors
+}
+
+func collectRefs(value interface{}) [][]string {
+	switch v := value.(type) {
+	case string:
+		return refPattern.FindAllStringSubmatch(v, -1)
+	case map[string]interface{}:
+		refs := [][]string{}
+		for _, item := range v {
+			refs = append(refs, collectRefs(item)...)
+		}
+		return refs
+	case []interface{}:
+		refs := [][]string{}
+		for _, item := range v {
+			refs = append(refs, collectRefs(item)...)
+		}
+		return refs
+	}
+	return nil
+}
+
+// parseRef splits a reference such as "steps.allocate_ip.output.address" into its path.
+func parseRef(ref string) ([]string, error) {
+	path := strings.Split(strings.TrimSpace(ref), ".")
+	switch {
+	case path[0] == "params" && len(path) >= 2:
+		return path, nil
+	case path[0] == "steps" && len(path) >= 3 && path[2] == "output":
+		return path, nil
+	}
+	return nil, fmt.Errorf("invalid reference ${%s} (use ${params.<name>} or ${steps.<step>.output...})", ref)
+}
+
+// ErrOutputRedacted is returned for a reference to a secret that was masked in the stored
+// output of a step, which happens when an execution resumes after the step completed.
+var ErrOutputRedacted = errors.New("step output had a secret, which was redacted when it was stored")
+
+// stepScope holds the values references are resolved against. redacted names the steps
+// whose outputs were loaded from storage with secrets masked.
+type stepScope struct {
+	params   map[string]interface{}
+	outputs  map[string]interface{}
+	redacted map[string]bool
+}
+
+func (s stepScope) lookup(ref string) (interface{}, bool, error) {
+	path, err := parseRef(ref)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var value interface{}
+	var rest []string
+	if path[0] == "params" {
+		value, rest = s.params, path[1:]
+	} else {
+		output, ok := s.outputs[path[1]]
+		if !ok {
+			return nil, false, nil
+		}
+		value, rest = output, path[3:]
+	}
+
+	value, ok := walkPath(value, rest)
+	if ok && path[0] == "steps" && s.redacted[path[1]] {
+		if encoded, _ := json.Marshal(value); strings.Contains(string(encoded), redactedValue) {
+			return nil, false, fmt.Errorf("%w: ${%s}", ErrOutputRedacted, ref)
+		}
+	}
+	return value, ok, nil
+}
+
+// walkPath follows object keys and array indexes into a decoded JSON value.
+func walkPath(value interface{}, keys []string) (interface{}, bool) {
+	for _, key := range keys {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			value = item
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// resolve substitutes references in an input value. A string that consists of a single
+// reference takes the referenced value as is; references embedded in longer strings are
+// formatted into the string.
+func (s stepScope) resolve(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if match := refPattern.FindStringSubmatchIndex(v); match != nil && match[0] == 0 && match[1] == len(v) {
+			resolved, ok, err := s.lookup(v[match[2]:match[3]])
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("unresolved reference %s", v)
+			}
+			return resolved, nil
+		}
+
+		var resolveErr error
+		result := refPattern.ReplaceAllStringFunc(v, func(ref string) string {
+			resolved, ok, err := s.lookup(ref[2 : len(ref)-1])
+			if err == nil && !ok {
+				err = fmt.Errorf("unresolved reference %s", ref)
+			}
+			if err != nil {
+				resolveErr = err
+				return ""
+			}
+			if str, isString := resolved.(string); isString {
+				return str
+			}
+			encoded, _ := json.Marshal(resolved)
+			return string(encoded)
+		})
+		return result, resolveErr
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			value, err := s.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = value
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, item := range v {
+			value, err := s.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = value
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+func (s stepScope) resolveInputs(inputs map[string]interface{}) (map[string]interface{}, error) {
+	resolved, err := s.resolve(map[string]interface{}(inputs))
+	if err != nil {
+		return nil, err
+	}
+	return resolved.(map[string]interface{}), nil
+}
+
+// condition is a parsed When expression: an operand, optionally negated with "!", or two
+// operands compared with "==" or "!=". Operands are references or JSON literals.
+type condition struct {
+	negate bool
+	left   string
+	op     string
+	right  string
+}
+
+func parseCondition(expr string) (*condition, error) {
+	expr = strings.TrimSpace(expr)
+	cond := &condition{}
+	if strings.HasPrefix(expr, "!") && !strings.HasPrefix(expr, "!=") {
+		cond.negate = true
+		expr = strings.TrimSpace(expr[1:])
+	}
+
+	for _, op := range []string{"==", "!="} {
+		if left, right, found := strings.Cut(expr, op); found {
+			if cond.negate {
+				return nil, fmt.Errorf("invalid condition %q: '!' cannot be combined with %s", expr, op)
+			}
+			cond.left, cond.op, cond.right = strings.TrimSpace(left), op, strings.TrimSpace(right)
+			break
+		}
+	}
+	if cond.op == "" {
+		cond.left = expr
+	}
+
+	if cond.left == "" || (cond.op != "" && cond.right == "") {
+		return nil, fmt.Errorf("invalid condition %q", expr)
+	}
+
+	for _, operand := range []string{cond.left, cond.right} {
+		if operand == "" {
+			continue
+		}
+		if _, err := parseOperand(operand, stepScope{}); err != nil {
+			return nil, fmt.Errorf("invalid condition %q: %w", expr, err)
+		}
+	}
+
+	return cond, nil
+}
+
+func parseOperand(operand string, scope stepScope) (interface{}, error) {
+	if strings.HasPrefix(operand, "${") && strings.HasSuffix(operand, "}") {
+		value, _, err := scope.lookup(operand[2 : len(operand)-1])
+		return value, err
+	}
+
+	var literal interface{}
+	if err := json.Unmarshal([]byte(operand), &literal); err != nil {
+		return nil, fmt.Errorf("operand %s is neither a reference nor a JSON literal", operand)
+	}
+	return literal, nil
+}
+
+// evaluateCondition reports whether a When expression holds. Missing references evaluate
+// to null.
+func evaluateCondition(expr string, scope stepScope) (bool, error) {
+	cond, err := parseCondition(expr)
+	if err != nil {
+		return false, err
+	}
+
+	left, err := parseOperand(cond.left, scope)
+	if err != nil {
+		return false, err
+	}
+
+	if cond.op == "" {
+		return truthy(left) != cond.negate, nil
+	}
+
+	right, err := parseOperand(cond.right, scope)
+	if err != nil {
+		return false, err
+	}
+
+	equal := reflect.DeepEqual(left, right)
+	if cond.op == "==" {
+		return equal, nil
+	}
+	return !equal, nil
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// normalizeJSON converts a value returned by workflow code to its JSON representation so
+// that references see the same types whether an output is fresh or loaded from storage.
+func normalizeJSON(value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(encoded, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
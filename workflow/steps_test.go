@@ -0,0 +1,163 @@
+package workflow
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testSteps() []Step {
+	return []Step{
+		{Name: "notify", Function: "Notify", DependsOn: []string{"assign_vlan"},
+			Inputs: map[string]interface{}{"message": "Interface ${steps.create_interface.output.name} ready"}},
+		{Name: "allocate_ip", Function: "AllocateIP", Inputs: map[string]interface{}{"prefix": "${params.prefix}"}},
+		{Name: "create_interface", Function: "CreateInterface", DependsOn: []string{"allocate_ip"},
+			Inputs: map[string]interface{}{"address": "${steps.allocate_ip.output.address}"}},
+		{Name: "assign_vlan", Function: "AssignVLAN", DependsOn: []string{"create_interface"},
+			When: "${params.vlan} != null"},
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	if err := ValidateSteps(testSteps()); err != nil {
+		t.Fatalf("Expected steps to be valid, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func([]Step) []Step
+		errMsg string
+	}{
+		{"duplicate", func(s []Step) []Step { s[1].Name = "notify"; return s }, "duplicate step name"},
+		{"unknown dependency", func(s []Step) []Step { s[0].DependsOn = []string{"missing"}; return s }, "unknown step"},
+		{"cycle", func(s []Step) []Step { s[1].DependsOn = []string{"notify"}; return s }, "cycle"},
+		{"reference without dependency", func(s []Step) []Step { s[2].DependsOn = nil; return s }, "without depending"},
+		{"bad reference", func(s []Step) []Step { s[1].Inputs["prefix"] = "${prefix}"; return s }, "invalid reference"},
+		{"bad condition", func(s []Step) []Step { s[3].When = "${params.vlan} =="; return s }, "invalid condition"},
+		{"missing function", func(s []Step) []Step { s[0].Function = ""; return s }, "has no function"},
+		{"approval with function", func(s []Step) []Step { s[3].Approval = &ApprovalGate{}; return s }, "cannot have a function"},
+		{"bad approver", func(s []Step) []Step {
+			s[3].Function, s[3].Approval = "", &ApprovalGate{Approvers: []int{0}}
+			return s
+		}, "invalid approver"},
+		{"sub-workflow with function", func(s []Step) []Step { s[3].Workflow = &SubWorkflow{Name: "allocate-ip"}; return s }, "cannot have a function"},
+		{"sub-workflow without name", func(s []Step) []Step {
+			s[3].Function, s[3].Workflow = "", &SubWorkflow{}
+			return s
+		}, "sub-workflow name is required"},
+	}
+
+	for _, tt := range tests {
+		err := ValidateSteps(tt.mutate(testSteps()))
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.errMsg, err)
+		}
+	}
+}
+
+func TestOrderSteps(t *testing.T) {
+	ordered, err := orderSteps(testSteps())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	names := []string{}
+	for _, step := range ordered {
+		names = append(names, step.Name)
+	}
+	expected := []string{"allocate_ip", "create_interface", "assign_vlan", "notify"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected order %v, got %v", expected, names)
+	}
+}
+
+func TestResolveInputs(t *testing.T) {
+	scope := stepScope{
+		params: map[string]interface{}{"prefix": "10.0.0.0/24", "count": float64(2)},
+		outputs: map[string]interface{}{
+			"allocate_ip": map[string]interface{}{"address": "10.0.0.5/24", "tags": []interface{}{"a", "b"}},
+		},
+	}
+
+	inputs, err := scope.resolveInputs(map[string]interface{}{
+		"address": "${steps.allocate_ip.output.address}",
+		"count":   "${params.count}",
+		"label":   "ip ${steps.allocate_ip.output.address} x${params.count}",
+		"nested":  []interface{}{"${steps.allocate_ip.output.tags.1}"},
+		"static":  true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"address": "10.0.0.5/24",
+		"count":   float64(2),
+		"label":   "ip 10.0.0.5/24 x2",
+		"nested":  []interface{}{"b"},
+		"static":  true,
+	}
+	if !reflect.DeepEqual(inputs, expected) {
+		t.Errorf("Expected %v, got %v", expected, inputs)
+	}
+
+	if _, err := scope.resolveInputs(map[string]interface{}{"x": "${params.missing}"}); err == nil {
+		t.Error("Expected error for unresolved reference")
+	}
+}
+
+func TestResolveRedactedOutput(t *testing.T) {
+	scope := stepScope{
+		outputs: map[string]interface{}{
+			"login": map[string]interface{}{"user": "netops", "password": redactedValue},
+		},
+		redacted: map[string]bool{"login": true},
+	}
+
+	inputs, err := scope.resolveInputs(map[string]interface{}{"user": "${steps.login.output.user}"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if inputs["user"] != "netops" {
+		t.Errorf("Expected netops, got %v", inputs["user"])
+	}
+
+	for _, ref := range []string{"${steps.login.output.password}", "${steps.login.output}", "pw ${steps.login.output.password}"} {
+		if _, err := scope.resolveInputs(map[string]interface{}{"x": ref}); !errors.Is(err, ErrOutputRedacted) {
+			t.Errorf("Expected ErrOutputRedacted for %s, got %v", ref, err)
+		}
+	}
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	scope := stepScope{
+		params:  map[string]interface{}{"vlan": float64(100), "notify": false},
+		outputs: map[string]interface{}{"check": map[string]interface{}{"status": "ok"}},
+	}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{"${params.vlan}", true},
+		{"${params.notify}", false},
+		{"!${params.notify}", true},
+		{"${params.missing}", false},
+		{"${params.vlan} == 100", true},
+		{"${params.vlan} != 100", false},
+		{`${steps.check.output.status} == "ok"`, true},
+		{"${params.missing} == null", true},
+	}
+
+	for _, tt := range tests {
+		result, err := evaluateCondition(tt.expr, scope)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tt.expr, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("Expected %q to be %v, got %v", tt.expr, tt.expected, result)
+		}
+	}
+}
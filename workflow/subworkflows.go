@@ -0,0 +1,385 @@
+package workflow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/holonet/core/logger"
+	"github.com/lib/pq"
+)
+
+const (
+	StepWaitingWorkflow StepStatus = "waiting_workflow"
+
+	// maxWorkflowDepth bounds how deeply sub-workflows nest, counting the top-level
+	// execution, so that a workflow that runs itself does not run forever.
+	maxWorkflowDepth = 5
+)
+
+// errAwaitingWorkflow stops a run at a sub-workflow step whose execution has not finished.
+var errAwaitingWorkflow = errors.New("waiting for sub-workflow")
+
+// unfinishedStatuses are the statuses of executions that may still change.
+var unfinishedStatuses = []string{
+	string(ExecutionPending),
+	string(ExecutionRunning),
+	string(ExecutionWaitingApproval),
+	string(ExecutionWaitingWorkflow),
+}
+
+// SubWorkflow turns a step into an execution of another workflow, found by name. The
+// step's resolved inputs are its parameters and its output is the workflow's output.
+// Revision pins a revision; without it the workflow's active revision runs. The calling
+// execution pauses in ExecutionWaitingWorkflow until the sub-workflow has finished.
+type SubWorkflow struct {
+	Name     string `json:"name"`
+	Revision int    `json:"revision,omitempty"`
+}
+
+func (s *SubWorkflow) validate() error {
+	if s.Name == "" {
+		return errors.New("sub-workflow name is required")
+	}
+	if s.Revision < 0 {
+		return errors.New("sub-workflow revision must not be negative")
+	}
+	return nil
+}
+
+// ExecutionNode is an execution with the sub-workflow executions it started.
+type ExecutionNode struct {
+	*WorkflowExecution
+	Children []*ExecutionNode `json:"children"`
+}
+
+// buildExecutionTree arranges executions by parent below the one with rootID.
+func buildExecutionTree(executions []*WorkflowExecution, rootID int) *ExecutionNode {
+	nodes := make(map[int]*ExecutionNode, len(executions))
+	for _, execution := range executions {
+		nodes[execution.ID] = &ExecutionNode{WorkflowExecution: execution, Children: []*ExecutionNode{}}
+	}
+	for _, execution := range executions {
+		if parent, ok := nodes[execution.ParentExecutionID]; ok && execution.ID != rootID {
+			parent.Children = append(parent.Children, nodes[execution.ID])
+		}
+	}
+	return nodes[rootID]
+}
+
+// GetExecutionTree returns the tree of sub-workflow executions that an execution belongs
+// to, starting at its top-level execution.
+func (wm *WorkflowManager) GetExecutionTree(id int) (*ExecutionNode, error) {
+	rootQuery := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_execution_id FROM workflow_executions WHERE id = $1
+			UNION ALL
+			SELECT e.id, e.parent_execution_id
+			FROM workflow_executions e
+			JOIN ancestors a ON e.id = a.parent_execution_id
+		)
+		SELECT id FROM ancestors WHERE parent_execution_id IS NULL
+	`
+
+	var rootID int
+	if err := wm.db.QueryRow(rootQuery, id).Scan(&rootID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrExecutionNotFound
+		}
+		return nil, fmt.Errorf("failed to find top-level execution: %w", err)
+	}
+
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT id FROM workflow_executions WHERE id = $1
+			UNION ALL
+			SELECT e.id
+			FROM workflow_executions e
+			JOIN tree t ON e.parent_execution_id = t.id
+		)
+		SELECT ` + executionColumns + `
+		FROM workflow_executions
+		WHERE id IN (SELECT id FROM tree)
+		ORDER BY id
+	`
+
+	rows, err := wm.db.Query(query, rootID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get execution tree: %w", err)
+	}
+	defer rows.Close()
+
+	executions := []*WorkflowExecution{}
+	for rows.Next() {
+		execution, err := scanExecution(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan execution: %w", err)
+		}
+		executions = append(executions, execution)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating executions: %w", err)
+	}
+
+	return buildExecutionTree(executions, rootID), nil
+}
+
+// unfinishedDescendants returns the IDs of the sub-workflow executions below an
+// execution that have not finished, parents first.
+func (wm *WorkflowManager) unfinishedDescendants(id int) ([]int, error) {
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT id, status, 0 AS depth FROM workflow_executions WHERE parent_execution_id = $1
+			UNION ALL
+			SELECT e.id, e.status, t.depth + 1
+			FROM workflow_executions e
+			JOIN tree t ON e.parent_execution_id = t.id
+		)
+		SELECT id FROM tree WHERE status = ANY($2) ORDER BY depth, id
+	`
+
+	rows, err := wm.db.Query(query, id, pq.Array(unfinishedStatuses))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sub-workflow executions: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var childID int
+		if err := rows.Scan(&childID); err != nil {
+			return nil, fmt.Errorf("failed to scan execution ID: %w", err)
+		}
+		ids = append(ids, childID)
+	}
+	return ids, rows.Err()
+}
+
+// childForStep returns the latest execution a sub-workflow step started, if any.
+func (wm *WorkflowManager) childForStep(parentID int, step string) (*WorkflowExecution, error) {
+	query := `
+		SELECT ` + executionColumns + `
+		FROM workflow_executions
+		WHERE parent_execution_id = $1 AND parent_step = $2
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	execution, err := scanExecution(wm.db.QueryRow(query, parentID, step))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sub-workflow execution of step %s: %w", step, err)
+	}
+	return execution, nil
+}
+
+// startChildExecution queues the execution of a sub-workflow step.
+func (wm *WorkflowManager) startChildExecution(parent *WorkflowExecution, step Step, input json.RawMessage) (*WorkflowExecution, error) {
+	workflowID, err := wm.findWorkflowByName(step.Workflow.Name)
+	if err != nil {
+		return nil, err
+	}
+	if workflowID == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrWorkflowNotFound, step.Workflow.Name)
+	}
+	workflow, err := wm.GetWorkflow(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if workflow.Status != StatusActive {
+		return nil, fmt.Errorf("workflow %q is not active", workflow.Name)
+	}
+
+	revision := workflow.RunRevision()
+	if step.Workflow.Revision != 0 {
+		if _, err := wm.GetRevision(workflow.ID, step.Workflow.Revision); err != nil {
+			return nil, fmt.Errorf("workflow %q: %w", workflow.Name, err)
+		}
+		revision = step.Workflow.Revision
+	}
+
+	depthQuery := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_execution_id FROM workflow_executions WHERE id = $1
+			UNION ALL
+			SELECT e.id, e.parent_execution_id
+			FROM workflow_executions e
+			JOIN ancestors a ON e.id = a.parent_execution_id
+		)
+		SELECT COUNT(*) FROM ancestors
+	`
+	var depth int
+	if err := wm.db.QueryRow(depthQuery, parent.ID).Scan(&depth); err != nil {
+		return nil, fmt.Errorf("failed to get sub-workflow depth: %w", err)
+	}
+	if depth >= maxWorkflowDepth {
+		return nil, fmt.Errorf("sub-workflows are nested more than %d levels deep", maxWorkflowDepth)
+	}
+
+	parameters, err := workflow.PrepareParameters(input)
+	if err != nil {
+		return nil, err
+	}
+	concurrencyKey, err := workflow.resolveConcurrencyKey(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	execution := &WorkflowExecution{
+		WorkflowID:        workflow.ID,
+		Status:            ExecutionPending,
+		Parameters:        parameters,
+		ScheduledAt:       time.Now(),
+		Revision:          revision,
+		ConcurrencyKey:    concurrencyKey,
+		Mode:              ModeRun,
+		ParentExecutionID: parent.ID,
+		ParentStep:        step.Name,
+		ParentAttempt:     parent.Attempt,
+	}
+
+	query := `
+		INSERT INTO workflow_executions (workflow_id, status, parameters, scheduled_at, revision, concurrency_key, mode,
+		                                 parent_execution_id, parent_step, parent_attempt, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW())
+		RETURNING id, attempt, created_at, updated_at
+	`
+
+	err = wm.db.QueryRow(
+		query,
+		execution.WorkflowID,
+		execution.Status,
+		execution.Parameters,
+		execution.ScheduledAt,
+		nullInt(execution.Revision),
+		nullString(execution.ConcurrencyKey),
+		execution.Mode,
+		execution.ParentExecutionID,
+		execution.ParentStep,
+		execution.ParentAttempt,
+	).Scan(&execution.ID, &execution.Attempt, &execution.CreatedAt, &execution.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sub-workflow: %w", err)
+	}
+
+	logger.Info("Execution %d started workflow %d (execution %d) for step %s", parent.ID, workflow.ID, execution.ID, step.Name)
+	return execution, nil
+}
+
+// suspendForWorkflow releases an execution that stopped at a sub-workflow step. If the
+// sub-workflow already finished in the meantime, the execution is requeued right away.
+func (wm *WorkflowManager) suspendForWorkflow(execution *WorkflowExecution, workerID string) error {
+	query := `
+		UPDATE workflow_executions
+		SET status = CASE WHEN EXISTS (
+		        SELECT 1 FROM workflow_executions WHERE parent_execution_id = $1 AND status = ANY($2)
+		    ) THEN $3 ELSE $4 END,
+		    result = $5, scheduled_at = NOW(), locked_by = NULL, lease_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND locked_by = $6
+		RETURNING status, updated_at
+	`
+
+	err := wm.db.QueryRow(
+		query,
+		execution.ID,
+		pq.Array(unfinishedStatuses),
+		ExecutionWaitingWorkflow,
+		ExecutionPending,
+		execution.Result,
+		workerID,
+	).Scan(&execution.Status, &execution.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrLeaseLost
+		}
+		return fmt.Errorf("failed to suspend execution: %w", err)
+	}
+
+	execution.LockedBy = ""
+	execution.LeaseExpiresAt = time.Time{}
+	return nil
+}
+
+// wakeParent requeues the execution waiting for a sub-workflow that just finished.
+func wakeParent(tx *sql.Tx, execution *WorkflowExecution) error {
+	if execution.ParentExecutionID == 0 {
+		return nil
+	}
+	query := `
+		UPDATE workflow_executions
+		SET status = $1, scheduled_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND status = $3
+	`
+	if _, err := tx.Exec(query, ExecutionPending, execution.ParentExecutionID, ExecutionWaitingWorkflow); err != nil {
+		return fmt.Errorf("failed to requeue execution %d: %w", execution.ParentExecutionID, err)
+	}
+	return nil
+}
+
+// WakeWaitingParents requeues executions waiting for sub-workflows that have all
+// finished, such as ones that were cancelled before they started.
+func (wm *WorkflowManager) WakeWaitingParents() (int, error) {
+	query := `
+		UPDATE workflow_executions p
+		SET status = $1, scheduled_at = NOW(), updated_at = NOW()
+		WHERE p.status = $2 AND NOT EXISTS (
+			SELECT 1 FROM workflow_executions c WHERE c.parent_execution_id = p.id AND c.status = ANY($3)
+		)
+	`
+
+	result, err := wm.db.Exec(query, ExecutionPending, ExecutionWaitingWorkflow, pq.Array(unfinishedStatuses))
+	if err != nil {
+		return 0, fmt.Errorf("failed to wake waiting executions: %w", err)
+	}
+
+	woken, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count woken executions: %w", err)
+	}
+	return int(woken), nil
+}
+
+// runWorkflowStep starts the sub-workflow of a step, or takes its result once it has
+// finished. It returns errAwaitingWorkflow while the sub-workflow runs. A sub-workflow
+// that failed in an earlier attempt of the calling execution is started again.
+func (e *Executor) runWorkflowStep(step Step, state *ExecutionStep, execution *WorkflowExecution) (interface{}, error) {
+	if execution.Mode == ModeDryRun {
+		return nil, errors.New("sub-workflow steps cannot run in a dry run")
+	}
+
+	child, err := e.manager.childForStep(execution.ID, step.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if child == nil || (child.Status.IsFinal() && child.Status != ExecutionCompleted && child.ParentAttempt != execution.Attempt) {
+		if child, err = e.manager.startChildExecution(execution, step, state.Input); err != nil {
+			return nil, err
+		}
+		e.logf(execution.ID, "Step %s started workflow %q (execution %d)", step.Name, step.Workflow.Name, child.ID)
+	}
+
+	switch child.Status {
+	case ExecutionCompleted:
+		var result RunOutput
+		if len(child.Result) > 0 {
+			if err := json.Unmarshal(child.Result, &result); err != nil {
+				return nil, fmt.Errorf("failed to decode result of execution %d: %w", child.ID, err)
+			}
+		}
+		e.logf(execution.ID, "Workflow %q (execution %d) of step %s completed", step.Workflow.Name, child.ID, step.Name)
+		return result.Output, nil
+	case ExecutionFailed, ExecutionCancelled:
+		return nil, fmt.Errorf("workflow %q (execution %d) %s: %s", step.Workflow.Name, child.ID, child.Status, child.ErrorMessage)
+	default:
+		state.Status = StepWaitingWorkflow
+		if err := e.manager.saveExecutionStep(state); err != nil {
+			return nil, err
+		}
+		return nil, errAwaitingWorkflow
+	}
+}
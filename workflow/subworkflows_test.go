@@ -0,0 +1,41 @@
+package workflow
+
+import "testing"
+
+func TestValidateWorkflowWithSubWorkflowStep(t *testing.T) {
+	wf := &Workflow{
+		Code: "package main\n\nfunc Configure(in map[string]interface{}) (interface{}, error) { return nil, nil }\n",
+		Steps: []Step{
+			{Name: "allocate_ip", Inputs: map[string]interface{}{"prefix": "${params.prefix}"},
+				Workflow: &SubWorkflow{Name: "allocate-ip", Revision: 3}},
+			{Name: "configure", Function: "Configure", DependsOn: []string{"allocate_ip"},
+				Inputs: map[string]interface{}{"address": "${steps.allocate_ip.output.address}"}},
+		},
+	}
+	if err := ValidateWorkflow(wf); err != nil {
+		t.Errorf("Expected workflow with sub-workflow step to be valid, got %v", err)
+	}
+}
+
+func TestBuildExecutionTree(t *testing.T) {
+	executions := []*WorkflowExecution{
+		{ID: 1},
+		{ID: 2, ParentExecutionID: 1, ParentStep: "allocate_ip"},
+		{ID: 3, ParentExecutionID: 2, ParentStep: "reserve"},
+		{ID: 4, ParentExecutionID: 1, ParentStep: "notify"},
+	}
+
+	tree := buildExecutionTree(executions, 1)
+	if tree == nil || tree.ID != 1 {
+		t.Fatalf("Expected root execution 1, got %+v", tree)
+	}
+	if len(tree.Children) != 2 || tree.Children[0].ID != 2 || tree.Children[1].ID != 4 {
+		t.Fatalf("Expected children 2 and 4, got %+v", tree.Children)
+	}
+	if len(tree.Children[0].Children) != 1 || tree.Children[0].Children[0].ID != 3 {
+		t.Errorf("Expected execution 3 below execution 2, got %+v", tree.Children[0].Children)
+	}
+	if len(tree.Children[1].Children) != 0 {
+		t.Errorf("Expected execution 4 to have no children, got %+v", tree.Children[1].Children)
+	}
+}
@@ -0,0 +1,455 @@
+package workflow
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/holonet/core/logger"
+)
+
+var (
+	ErrTriggerNotFound   = errors.New("trigger not found")
+	ErrDuplicateDelivery = errors.New("webhook delivery was already processed")
+	ErrInvalidWebhook    = errors.New("invalid webhook payload")
+)
+
+// webhookDedupWindow is how long deliveries are remembered for deduplication. NetBox
+// retries failed deliveries within minutes, so a day leaves plenty of room.
+const webhookDedupWindow = 24 * time.Hour
+
+var triggerActions = map[string]bool{"created": true, "updated": true, "deleted": true}
+
+// WorkflowTrigger starts a workflow when NetBox reports a matching event. ObjectType is
+// either the model ("device") or app label and model ("dcim.device"). Actions limits the
+// trigger to some of created, updated and deleted; empty means all. Filter maps dotted
+// paths into the event, such as "data.status.value", to the value they must have, or to
+// a list of allowed values.
+type WorkflowTrigger struct {
+	ID         int                    `json:"id"`
+	WorkflowID int                    `json:"workflow_id"`
+	Name       string                 `json:"name"`
+	ObjectType string                 `json:"object_type"`
+	Actions    []string               `json:"actions"`
+	Filter     map[string]interface{} `json:"filter,omitempty"`
+	Enabled    bool                   `json:"enabled"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+}
+
+func (t *WorkflowTrigger) Validate() error {
+	t.ObjectType = strings.ToLower(strings.TrimSpace(t.ObjectType))
+	if t.ObjectType == "" {
+		return errors.New("object_type is required")
+	}
+	if t.Actions == nil {
+		t.Actions = []string{}
+	}
+	for _, action := range t.Actions {
+		if !triggerActions[action] {
+			return fmt.Errorf("invalid action %q: must be created, updated or deleted", action)
+		}
+	}
+	for path := range t.Filter {
+		if strings.TrimSpace(path) == "" || strings.Contains(path, "..") {
+			return fmt.Errorf("invalid filter path %q", path)
+		}
+	}
+	return nil
+}
+
+// WebhookEvent is the body of a NetBox webhook.
+type WebhookEvent struct {
+	Event     string                 `json:"event"`
+	Timestamp string                 `json:"timestamp"`
+	Model     string                 `json:"model"`
+	Username  string                 `json:"username"`
+	RequestID string                 `json:"request_id"`
+	Data      map[string]interface{} `json:"data"`
+	Snapshots map[string]interface{} `json:"snapshots"`
+}
+
+// ObjectType returns "<app>.<model>", taking the app label from the object's API URL, or
+// just the model if the URL is missing.
+func (ev *WebhookEvent) ObjectType() string {
+	model := strings.ToLower(ev.Model)
+	url, _ := ev.Data["url"].(string)
+	if _, path, found := strings.Cut(url, "/api/"); found {
+		if app, _, found := strings.Cut(path, "/"); found && app != "" {
+			return app + "." + model
+		}
+	}
+	return model
+}
+
+// dedupKey identifies a delivery. Redeliveries of an event carry the same request ID,
+// object and timestamp; without a request ID the body itself is the identity.
+func (ev *WebhookEvent) dedupKey(body []byte) string {
+	var identity []byte
+	if ev.RequestID != "" {
+		identity = []byte(strings.Join([]string{ev.RequestID, ev.Event, ev.ObjectType(), fmt.Sprint(ev.Data["id"]), ev.Timestamp}, "|"))
+	} else {
+		identity = body
+	}
+	sum := sha256.Sum256(identity)
+	return hex.EncodeToString(sum[:])
+}
+
+// parameters returns what triggered executions receive as their parameters.
+func (ev *WebhookEvent) parameters(triggerID int) map[string]interface{} {
+	return map[string]interface{}{
+		"trigger_id":  triggerID,
+		"event":       ev.Event,
+		"object_type": ev.ObjectType(),
+		"model":       ev.Model,
+		"username":    ev.Username,
+		"request_id":  ev.RequestID,
+		"timestamp":   ev.Timestamp,
+		"data":        ev.Data,
+		"snapshots":   ev.Snapshots,
+	}
+}
+
+// Matches reports whether an event should start the trigger's workflow.
+func (t *WorkflowTrigger) Matches(ev *WebhookEvent) bool {
+	if t.ObjectType != ev.ObjectType() && t.ObjectType != strings.ToLower(ev.Model) {
+		return false
+	}
+
+	if len(t.Actions) > 0 {
+		found := false
+		for _, action := range t.Actions {
+			if action == ev.Event {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(t.Filter) == 0 {
+		return true
+	}
+
+	event, err := normalizeJSON(ev)
+	if err != nil {
+		return false
+	}
+	for path, expected := range t.Filter {
+		actual, ok := walkPath(event, strings.Split(path, "."))
+		if !ok || !filterMatches(expected, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+func filterMatches(expected, actual interface{}) bool {
+	expected = copyJSON(expected)
+	if options, ok := expected.([]interface{}); ok {
+		for _, option := range options {
+			if reflect.DeepEqual(option, actual) {
+				return true
+			}
+		}
+		return false
+	}
+	return reflect.DeepEqual(expected, actual)
+}
+
+// WebhookDelivery records a processed webhook and the executions it started.
+type WebhookDelivery struct {
+	ID           int       `json:"id"`
+	Event        string    `json:"event"`
+	ObjectType   string    `json:"object_type"`
+	ExecutionIDs []int     `json:"execution_ids"`
+	Errors       []string  `json:"errors,omitempty"`
+	ReceivedAt   time.Time `json:"received_at"`
+}
+
+// HandleWebhookEvent schedules an execution of every active workflow with an enabled
+// trigger matching the event. A delivery that was already processed returns
+// ErrDuplicateDelivery. Workflows whose parameters are rejected, e.g. by their schema,
+// are reported in the delivery's Errors and do not keep other triggers from running.
+// The delivery is recorded in the same transaction as its executions, so a delivery
+// that fails can be sent again.
+func (wm *WorkflowManager) HandleWebhookEvent(body []byte) (*WebhookDelivery, error) {
+	ev := &WebhookEvent{}
+	if err := json.Unmarshal(body, ev); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	if ev.Event == "" || ev.Model == "" {
+		return nil, fmt.Errorf("%w: event and model are required", ErrInvalidWebhook)
+	}
+
+	delivery := &WebhookDelivery{Event: ev.Event, ObjectType: ev.ObjectType(), ExecutionIDs: []int{}}
+
+	if _, err := wm.db.Exec(`DELETE FROM webhook_deliveries WHERE received_at < NOW() - make_interval(secs => $1)`, webhookDedupWindow.Seconds()); err != nil {
+		logger.Warn("Failed to prune webhook deliveries: %v", err)
+	}
+
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// A concurrent delivery of the same event waits here until this one is committed.
+	insertQuery := `
+		INSERT INTO webhook_deliveries (dedup_key, event, object_type, received_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (dedup_key) DO NOTHING
+		RETURNING id, received_at
+	`
+	err = tx.QueryRow(insertQuery, ev.dedupKey(body), delivery.Event, delivery.ObjectType).Scan(&delivery.ID, &delivery.ReceivedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDuplicateDelivery
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+
+	triggers, err := wm.listTriggers(`t.enabled = TRUE AND w.status = $1`, StatusActive)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, trigger := range triggers {
+		if !trigger.Matches(ev) {
+			continue
+		}
+
+		parameters, err := json.Marshal(ev.parameters(trigger.ID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event parameters: %w", err)
+		}
+
+		execution, err := wm.prepareExecution(trigger.WorkflowID, parameters, time.Now(), ModeRun)
+		if err != nil {
+			logger.Error("Trigger %d failed to schedule workflow %d: %v", trigger.ID, trigger.WorkflowID, err)
+			delivery.Errors = append(delivery.Errors, fmt.Sprintf("trigger %d: %v", trigger.ID, err))
+			continue
+		}
+		if err := insertExecution(tx, execution); err != nil {
+			return nil, err
+		}
+		delivery.ExecutionIDs = append(delivery.ExecutionIDs, execution.ID)
+		logger.Info("Trigger %d started execution %d of workflow %d for %s %s", trigger.ID, execution.ID, trigger.WorkflowID, delivery.ObjectType, ev.Event)
+	}
+
+	executionIDs, err := json.Marshal(delivery.ExecutionIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode execution IDs: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE webhook_deliveries SET execution_ids = $1 WHERE id = $2`, executionIDs, delivery.ID); err != nil {
+		return nil, fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit webhook delivery: %w", err)
+	}
+
+	return delivery, nil
+}
+
+const triggerColumns = `t.id, t.workflow_id, t.name, t.object_type, t.actions, t.filter, t.enabled, t.created_at, t.updated_at`
+
+func scanTrigger(row rowScanner) (*WorkflowTrigger, error) {
+	trigger := &WorkflowTrigger{}
+	var name sql.NullString
+	var actions, filter []byte
+
+	err := row.Scan(
+		&trigger.ID,
+		&trigger.WorkflowID,
+		&name,
+		&trigger.ObjectType,
+		&actions,
+		&filter,
+		&trigger.Enabled,
+		&trigger.CreatedAt,
+		&trigger.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	trigger.Name = name.String
+	trigger.Actions = []string{}
+	if len(actions) > 0 {
+		if err := json.Unmarshal(actions, &trigger.Actions); err != nil {
+			return nil, fmt.Errorf("failed to decode trigger actions: %w", err)
+		}
+	}
+	if len(filter) > 0 && string(filter) != "null" {
+		if err := json.Unmarshal(filter, &trigger.Filter); err != nil {
+			return nil, fmt.Errorf("failed to decode trigger filter: %w", err)
+		}
+	}
+	return trigger, nil
+}
+
+func (wm *WorkflowManager) listTriggers(condition string, args ...interface{}) ([]*WorkflowTrigger, error) {
+	query := `
+		SELECT ` + triggerColumns + `
+		FROM workflow_triggers t
+		JOIN workflows w ON w.id = t.workflow_id
+		WHERE ` + condition + `
+		ORDER BY t.id
+	`
+
+	rows, err := wm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list triggers: %w", err)
+	}
+	defer rows.Close()
+
+	triggers := []*WorkflowTrigger{}
+	for rows.Next() {
+		trigger, err := scanTrigger(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan trigger: %w", err)
+		}
+		triggers = append(triggers, trigger)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating triggers: %w", err)
+	}
+
+	return triggers, nil
+}
+
+func (wm *WorkflowManager) ListTriggers(workflowID int) ([]*WorkflowTrigger, error) {
+	return wm.listTriggers(`t.workflow_id = $1`, workflowID)
+}
+
+func (wm *WorkflowManager) GetTrigger(workflowID, triggerID int) (*WorkflowTrigger, error) {
+	triggers, err := wm.listTriggers(`t.workflow_id = $1 AND t.id = $2`, workflowID, triggerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(triggers) == 0 {
+		return nil, ErrTriggerNotFound
+	}
+	return triggers[0], nil
+}
+
+func encodeTrigger(trigger *WorkflowTrigger) (actions, filter []byte, err error) {
+	if actions, err = json.Marshal(trigger.Actions); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode trigger actions: %w", err)
+	}
+	if len(trigger.Filter) > 0 {
+		if filter, err = json.Marshal(trigger.Filter); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode trigger filter: %w", err)
+		}
+	}
+	return actions, filter, nil
+}
+
+func (wm *WorkflowManager) CreateTrigger(trigger *WorkflowTrigger) error {
+	if err := trigger.Validate(); err != nil {
+		return err
+	}
+	if _, err := wm.GetWorkflow(trigger.WorkflowID); err != nil {
+		return err
+	}
+
+	if err := createTrigger(wm.db, trigger); err != nil {
+		return err
+	}
+
+	logger.Info("Created trigger %d for workflow %d on %s", trigger.ID, trigger.WorkflowID, trigger.ObjectType)
+	return nil
+}
+
+func createTrigger(db rowQuerier, trigger *WorkflowTrigger) error {
+	if err := trigger.Validate(); err != nil {
+		return err
+	}
+
+	actions, filter, err := encodeTrigger(trigger)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO workflow_triggers (workflow_id, name, object_type, actions, filter, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+		RETURNING id, created_at, updated_at
+	`
+
+	err = db.QueryRow(
+		query,
+		trigger.WorkflowID,
+		trigger.Name,
+		trigger.ObjectType,
+		actions,
+		filter,
+		trigger.Enabled,
+	).Scan(&trigger.ID, &trigger.CreatedAt, &trigger.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create trigger: %w", err)
+	}
+	return nil
+}
+
+func (wm *WorkflowManager) UpdateTrigger(trigger *WorkflowTrigger) error {
+	if err := trigger.Validate(); err != nil {
+		return err
+	}
+
+	actions, filter, err := encodeTrigger(trigger)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE workflow_triggers
+		SET name = $1, object_type = $2, actions = $3, filter = $4, enabled = $5, updated_at = NOW()
+		WHERE id = $6 AND workflow_id = $7
+		RETURNING created_at, updated_at
+	`
+
+	err = wm.db.QueryRow(
+		query,
+		trigger.Name,
+		trigger.ObjectType,
+		actions,
+		filter,
+		trigger.Enabled,
+		trigger.ID,
+		trigger.WorkflowID,
+	).Scan(&trigger.CreatedAt, &trigger.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTriggerNotFound
+		}
+		return fmt.Errorf("failed to update trigger: %w", err)
+	}
+
+	return nil
+}
+
+func (wm *WorkflowManager) DeleteTrigger(workflowID, triggerID int) error {
+	return deleteTrigger(wm.db, workflowID, triggerID)
+}
+
+func deleteTrigger(db execer, workflowID, triggerID int) error {
+	result, err := db.Exec(`DELETE FROM workflow_triggers WHERE id = $1 AND workflow_id = $2`, triggerID, workflowID)
+	if err != nil {
+		return fmt.Errorf("failed to delete trigger: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrTriggerNotFound
+	}
+	return nil
+}
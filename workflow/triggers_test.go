@@ -0,0 +1,114 @@
+package workflow
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const deviceUpdatedEvent = `{
+	"event": "updated",
+	"timestamp": "2026-10-17T09:12:44.501+00:00",
+	"model": "device",
+	"username": "alice",
+	"request_id": "4b3c1f4e-0b8a-4a52-9a1e-8f3f0f2f6b11",
+	"data": {
+		"id": 12,
+		"url": "https://netbox.example.com/api/dcim/devices/12/",
+		"name": "edge-01",
+		"status": {"value": "active", "label": "Active"},
+		"site": {"id": 3, "slug": "ams1"}
+	},
+	"snapshots": {"prechange": null, "postchange": null}
+}`
+
+func parseTestEvent(t *testing.T, body string) *WebhookEvent {
+	t.Helper()
+	ev := &WebhookEvent{}
+	if err := json.Unmarshal([]byte(body), ev); err != nil {
+		t.Fatalf("Failed to parse event: %v", err)
+	}
+	return ev
+}
+
+func TestWebhookEventObjectType(t *testing.T) {
+	ev := parseTestEvent(t, deviceUpdatedEvent)
+	if got := ev.ObjectType(); got != "dcim.device" {
+		t.Errorf("Expected object type dcim.device, got %q", got)
+	}
+
+	ev.Data["url"] = nil
+	if got := ev.ObjectType(); got != "device" {
+		t.Errorf("Expected object type device without a URL, got %q", got)
+	}
+}
+
+func TestTriggerMatches(t *testing.T) {
+	ev := parseTestEvent(t, deviceUpdatedEvent)
+
+	tests := []struct {
+		name    string
+		trigger WorkflowTrigger
+		want    bool
+	}{
+		{"app and model", WorkflowTrigger{ObjectType: "dcim.device"}, true},
+		{"model only", WorkflowTrigger{ObjectType: "device"}, true},
+		{"other model", WorkflowTrigger{ObjectType: "ipam.prefix"}, false},
+		{"other app", WorkflowTrigger{ObjectType: "virtualization.device"}, false},
+		{"matching action", WorkflowTrigger{ObjectType: "device", Actions: []string{"created", "updated"}}, true},
+		{"other action", WorkflowTrigger{ObjectType: "device", Actions: []string{"deleted"}}, false},
+		{"nested filter", WorkflowTrigger{ObjectType: "device", Filter: map[string]interface{}{"data.status.value": "active"}}, true},
+		{"numeric filter", WorkflowTrigger{ObjectType: "device", Filter: map[string]interface{}{"data.site.id": 3}}, true},
+		{"filter list", WorkflowTrigger{ObjectType: "device", Filter: map[string]interface{}{"data.site.slug": []interface{}{"ams1", "fra1"}}}, true},
+		{"filter mismatch", WorkflowTrigger{ObjectType: "device", Filter: map[string]interface{}{"data.status.value": "offline"}}, false},
+		{"filter missing field", WorkflowTrigger{ObjectType: "device", Filter: map[string]interface{}{"data.tenant.slug": "acme"}}, false},
+		{"top-level filter", WorkflowTrigger{ObjectType: "device", Filter: map[string]interface{}{"username": "alice"}}, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.trigger.Matches(ev); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestTriggerValidate(t *testing.T) {
+	trigger := &WorkflowTrigger{ObjectType: " DCIM.Device "}
+	if err := trigger.Validate(); err != nil {
+		t.Fatalf("Expected trigger to be valid, got %v", err)
+	}
+	if trigger.ObjectType != "dcim.device" {
+		t.Errorf("Expected object type to be normalized, got %q", trigger.ObjectType)
+	}
+	if trigger.Actions == nil {
+		t.Error("Expected actions to default to an empty list")
+	}
+
+	invalid := []*WorkflowTrigger{
+		{},
+		{ObjectType: "device", Actions: []string{"changed"}},
+		{ObjectType: "device", Filter: map[string]interface{}{"data..id": 1}},
+	}
+	for _, trigger := range invalid {
+		if err := trigger.Validate(); err == nil {
+			t.Errorf("Expected trigger %+v to be invalid", trigger)
+		}
+	}
+}
+
+func TestWebhookDedupKey(t *testing.T) {
+	ev := parseTestEvent(t, deviceUpdatedEvent)
+	first := ev.dedupKey([]byte(deviceUpdatedEvent))
+	if again := ev.dedupKey([]byte(deviceUpdatedEvent + "\n")); again != first {
+		t.Error("Expected a redelivery with the same request ID to have the same key")
+	}
+
+	ev.Timestamp = "2026-10-17T09:15:02.118+00:00"
+	if other := ev.dedupKey([]byte(deviceUpdatedEvent)); other == first {
+		t.Error("Expected a later event on the same object to have a different key")
+	}
+
+	ev.RequestID = ""
+	if ev.dedupKey([]byte("a")) == ev.dedupKey([]byte("b")) {
+		t.Error("Expected keys of events without request ID to depend on the body")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/holonet/core/logger"
+	"github.com/holonet/core/queue"
 )
 
 type WorkflowStatus string
@@ -27,38 +28,68 @@ const (
 	ExecutionCompleted ExecutionStatus = "completed"
 	ExecutionFailed    ExecutionStatus = "failed"
 	ExecutionCancelled ExecutionStatus = "cancelled"
+	// ExecutionWaitingApproval executions are paused at an approval step; see ApprovalGate.
+	ExecutionWaitingApproval ExecutionStatus = "waiting_approval"
+	// ExecutionWaitingWorkflow executions are paused at a sub-workflow step; see SubWorkflow.
+	ExecutionWaitingWorkflow ExecutionStatus = "waiting_workflow"
+	// ExecutionLost is only used for attempts whose worker stopped renewing its lease.
+	ExecutionLost ExecutionStatus = "lost"
 )
 
+var ErrWorkflowNotFound = errors.New("workflow not found")
+
 type Workflow struct {
 	ID          int            `json:"id"`
 	Name        string         `json:"name"`
 	Description string         `json:"description"`
 	Code        string         `json:"code"`
 	Status      WorkflowStatus `json:"status"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	// Revision is the latest revision of Code; ActiveRevision is the one new executions run.
+	Revision       int `json:"revision"`
+	ActiveRevision int `json:"active_revision"`
+	// Steps turns the workflow into a DAG of functions of Code; see Step.
+	Steps []Step `json:"steps,omitempty"`
+	// ParameterSchema is an optional JSON Schema that execution parameters must satisfy.
+	ParameterSchema json.RawMessage `json:"parameter_schema,omitempty"`
+	ExecutionPolicy
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type WorkflowExecution struct {
-	ID           int             `json:"id"`
-	WorkflowID   int             `json:"workflow_id"`
-	Status       ExecutionStatus `json:"status"`
-	Parameters   json.RawMessage `json:"parameters"`
-	Result       json.RawMessage `json:"result"`
-	ErrorMessage string          `json:"error_message"`
-	ScheduledAt  time.Time       `json:"scheduled_at"`
-	StartedAt    time.Time       `json:"started_at"`
-	CompletedAt  time.Time       `json:"completed_at"`
-	CreatedAt    time.Time       `json:"created_at"`
-	UpdatedAt    time.Time       `json:"updated_at"`
+	ID                int             `json:"id"`
+	WorkflowID        int             `json:"workflow_id"`
+	Status            ExecutionStatus `json:"status"`
+	Parameters        json.RawMessage `json:"parameters"`
+	Result            json.RawMessage `json:"result"`
+	ErrorMessage      string          `json:"error_message"`
+	ScheduledAt       time.Time       `json:"scheduled_at"`
+	StartedAt         time.Time       `json:"started_at"`
+	CompletedAt       time.Time       `json:"completed_at"`
+	LockedBy          string          `json:"locked_by,omitempty"`
+	LeaseExpiresAt    time.Time       `json:"lease_expires_at"`
+	ScheduleID        int             `json:"schedule_id,omitempty"`
+	CancelRequestedAt time.Time       `json:"cancel_requested_at"`
+	Attempt           int             `json:"attempt"`
+	Revision          int             `json:"revision,omitempty"`
+	Compensations     []Compensation  `json:"compensations,omitempty"`
+	ConcurrencyKey    string          `json:"concurrency_key,omitempty"`
+	Mode              ExecutionMode   `json:"mode"`
+	PlanExecutionID   int             `json:"plan_execution_id,omitempty"`
+	ParentExecutionID int             `json:"parent_execution_id,omitempty"`
+	ParentStep        string          `json:"parent_step,omitempty"`
+	ParentAttempt     int             `json:"parent_attempt,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
 }
 
 type WorkflowManager struct {
-	db *sql.DB
+	db   *sql.DB
+	logs *executionLogs
 }
 
 func NewWorkflowManager(db *sql.DB) *WorkflowManager {
-	return &WorkflowManager{db: db}
+	return &WorkflowManager{db: db, logs: newExecutionLogs(db)}
 }
 
 func (wm *WorkflowManager) CreateWorkflow(name, description, code string) (*Workflow, error) {
@@ -66,51 +97,97 @@ func (wm *WorkflowManager) CreateWorkflow(name, description, code string) (*Work
 		Name:        name,
 		Description: description,
 		Code:        code,
-		Status:      StatusDraft,
+	}
+	if err := wm.InsertWorkflow(workflow); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// InsertWorkflow stores a new draft workflow as revision 1. Zero execution policy fields
+// are set to their defaults.
+func (wm *WorkflowManager) InsertWorkflow(workflow *Workflow) error {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertWorkflow(tx, workflow); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func insertWorkflow(tx *sql.Tx, workflow *Workflow) error {
+	workflow.Status = StatusDraft
+	workflow.Revision = 1
+	workflow.ActiveRevision = 1
+	workflow.ExecutionPolicy.applyDefaults()
+	if err := workflow.ExecutionPolicy.Validate(); err != nil {
+		return err
+	}
+	if err := workflow.validateParameterSchema(); err != nil {
+		return err
+	}
+
+	steps, err := marshalSteps(workflow.Steps)
+	if err != nil {
+		return err
 	}
 
 	query := `
-		INSERT INTO workflows (name, description, code, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		INSERT INTO workflows (name, description, code, steps, parameter_schema, status, revision, active_revision,
+		                       max_runtime_seconds, max_attempts, backoff_strategy, backoff_seconds, max_concurrency,
+		                       concurrency_key, retention_days, retention_action, netbox_priority, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, NOW(), NOW())
 		RETURNING id, created_at, updated_at
 	`
 
-	err := wm.db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		workflow.Name,
 		workflow.Description,
 		workflow.Code,
+		steps,
+		nullJSON(workflow.ParameterSchema),
 		workflow.Status,
+		workflow.Revision,
+		workflow.ActiveRevision,
+		workflow.MaxRuntimeSeconds,
+		workflow.MaxAttempts,
+		workflow.BackoffStrategy,
+		workflow.BackoffSeconds,
+		workflow.MaxConcurrency,
+		nullString(workflow.ConcurrencyKey),
+		workflow.RetentionDays,
+		nullString(string(workflow.RetentionAction)),
+		nullString(string(workflow.NetboxPriority)),
 	).Scan(&workflow.ID, &workflow.CreatedAt, &workflow.UpdatedAt)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create workflow: %w", err)
+		return fmt.Errorf("failed to create workflow: %w", err)
 	}
 
-	return workflow, nil
+	return insertRevision(tx, workflow.ID, workflow.Revision, workflow.Code, steps)
 }
 
 func (wm *WorkflowManager) GetWorkflow(id int) (*Workflow, error) {
 	query := `
-		SELECT id, name, description, code, status, created_at, updated_at
+		SELECT ` + workflowColumns + `
 		FROM workflows
 		WHERE id = $1
 	`
 
-	workflow := &Workflow{}
-	err := wm.db.QueryRow(query, id).Scan(
-		&workflow.ID,
-		&workflow.Name,
-		&workflow.Description,
-		&workflow.Code,
-		&workflow.Status,
-		&workflow.CreatedAt,
-		&workflow.UpdatedAt,
-	)
-
+	workflow, err := scanWorkflow(wm.db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("workflow not found: %d", id)
+			return nil, fmt.Errorf("%w: %d", ErrWorkflowNotFound, id)
 		}
 		return nil, fmt.Errorf("failed to get workflow: %w", err)
 	}
@@ -118,33 +195,113 @@ func (wm *WorkflowManager) GetWorkflow(id int) (*Workflow, error) {
 	return workflow, nil
 }
 
+// UpdateWorkflow saves workflow. A change to Code or Steps is stored as a new revision,
+// which also becomes the active revision. A status change must be allowed by
+// CheckTransition, an active workflow must compile and deactivating a workflow cancels
+// its pending executions. Archived workflows cannot be updated.
 func (wm *WorkflowManager) UpdateWorkflow(workflow *Workflow) error {
+	tx, err := wm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cancelled, err := updateWorkflow(tx, workflow)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if cancelled > 0 {
+		logger.Info("Cancelled %d pending execution(s) of deactivated workflow %d", cancelled, workflow.ID)
+	}
+	return nil
+}
+
+// updateWorkflow saves workflow within tx and returns the number of pending executions
+// it cancelled.
+func updateWorkflow(tx *sql.Tx, workflow *Workflow) (int, error) {
+	if err := workflow.ExecutionPolicy.Validate(); err != nil {
+		return 0, err
+	}
+	if err := workflow.validateParameterSchema(); err != nil {
+		return 0, err
+	}
+
+	steps, err := marshalSteps(workflow.Steps)
+	if err != nil {
+		return 0, err
+	}
+
+	status, err := lockWorkflowStatus(tx, workflow.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := recordCodeRevision(tx, workflow, steps); err != nil {
+		return 0, err
+	}
+	// An active workflow must compile the code its executions run, which is that of the
+	// promoted revision when the code did not change.
+	runnable, err := runnableWorkflow(tx, workflow)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkStatusChange(status, runnable); err != nil {
+		return 0, err
+	}
+	cancelled := 0
+	if status == StatusActive && workflow.Status == StatusInactive {
+		cancelled, err = cancelPendingExecutions(tx, workflow.ID, "Workflow deactivated")
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	query := `
 		UPDATE workflows
-		SET name = $1, description = $2, code = $3, status = $4, updated_at = NOW()
-		WHERE id = $5
+		SET name = $1, description = $2, code = $3, steps = $4, parameter_schema = $5, status = $6, revision = $7,
+		    active_revision = $8, max_runtime_seconds = $9, max_attempts = $10, backoff_strategy = $11,
+		    backoff_seconds = $12, max_concurrency = $13, concurrency_key = $14, retention_days = $15,
+		    retention_action = $16, netbox_priority = $17, updated_at = NOW()
+		WHERE id = $18
 		RETURNING updated_at
 	`
 
-	err := wm.db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		workflow.Name,
 		workflow.Description,
 		workflow.Code,
+		steps,
+		nullJSON(workflow.ParameterSchema),
 		workflow.Status,
+		workflow.Revision,
+		workflow.ActiveRevision,
+		workflow.MaxRuntimeSeconds,
+		workflow.MaxAttempts,
+		workflow.BackoffStrategy,
+		workflow.BackoffSeconds,
+		workflow.MaxConcurrency,
+		nullString(workflow.ConcurrencyKey),
+		workflow.RetentionDays,
+		nullString(string(workflow.RetentionAction)),
+		nullString(string(workflow.NetboxPriority)),
 		workflow.ID,
 	).Scan(&workflow.UpdatedAt)
 
 	if err != nil {
-		return fmt.Errorf("failed to update workflow: %w", err)
+		return 0, fmt.Errorf("failed to update workflow: %w", err)
 	}
 
-	return nil
+	return cancelled, nil
 }
 
 func (wm *WorkflowManager) ListWorkflows() ([]*Workflow, error) {
 	query := `
-		SELECT id, name, description, code, status, created_at, updated_at
+		SELECT ` + workflowColumns + `
 		FROM workflows
 		ORDER BY id
 	`
@@ -157,16 +314,7 @@ func (wm *WorkflowManager) ListWorkflows() ([]*Workflow, error) {
 
 	workflows := []*Workflow{}
 	for rows.Next() {
-		workflow := &Workflow{}
-		err := rows.Scan(
-			&workflow.ID,
-			&workflow.Name,
-			&workflow.Description,
-			&workflow.Code,
-			&workflow.Status,
-			&workflow.CreatedAt,
-			&workflow.UpdatedAt,
-		)
+		workflow, err := scanWorkflow(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan workflow: %w", err)
 		}
@@ -181,6 +329,23 @@ func (wm *WorkflowManager) ListWorkflows() ([]*Workflow, error) {
 }
 
 func (wm *WorkflowManager) ScheduleWorkflow(workflowID int, parameters json.RawMessage, scheduledAt time.Time) (*WorkflowExecution, error) {
+	return wm.scheduleExecution(workflowID, parameters, scheduledAt, ModeRun)
+}
+
+func (wm *WorkflowManager) scheduleExecution(workflowID int, parameters json.RawMessage, scheduledAt time.Time, mode ExecutionMode) (*WorkflowExecution, error) {
+	execution, err := wm.prepareExecution(workflowID, parameters, scheduledAt, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertExecution(wm.db, execution); err != nil {
+		return nil, err
+	}
+	return execution, nil
+}
+
+// prepareExecution returns a pending execution of a workflow with its parameters checked
+// against the workflow's schema, ready to be inserted.
+func (wm *WorkflowManager) prepareExecution(workflowID int, parameters json.RawMessage, scheduledAt time.Time, mode ExecutionMode) (*WorkflowExecution, error) {
 	workflow, err := wm.GetWorkflow(workflowID)
 	if err != nil {
 		return nil, err
@@ -190,38 +355,66 @@ func (wm *WorkflowManager) ScheduleWorkflow(workflowID int, parameters json.RawM
 		return nil, errors.New("cannot schedule inactive workflow")
 	}
 
-	execution := &WorkflowExecution{
-		WorkflowID:  workflowID,
-		Status:      ExecutionPending,
-		Parameters:  parameters,
-		ScheduledAt: scheduledAt,
+	parameters, err = workflow.PrepareParameters(parameters)
+	if err != nil {
+		return nil, err
 	}
+	concurrencyKey, err := workflow.resolveConcurrencyKey(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WorkflowExecution{
+		WorkflowID:     workflowID,
+		Status:         ExecutionPending,
+		Parameters:     parameters,
+		ScheduledAt:    scheduledAt,
+		Revision:       workflow.RunRevision(),
+		ConcurrencyKey: concurrencyKey,
+		Mode:           mode,
+	}, nil
+}
+
+// rowQuerier is satisfied by *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
 
+// execer is satisfied by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertExecution(db rowQuerier, execution *WorkflowExecution) error {
 	query := `
-		INSERT INTO workflow_executions (workflow_id, status, parameters, scheduled_at, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		INSERT INTO workflow_executions (workflow_id, status, parameters, scheduled_at, revision, concurrency_key, mode,
+		                                 created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
 		RETURNING id, created_at, updated_at
 	`
 
-	err = wm.db.QueryRow(
+	err := db.QueryRow(
 		query,
 		execution.WorkflowID,
 		execution.Status,
 		execution.Parameters,
 		execution.ScheduledAt,
+		nullInt(execution.Revision),
+		nullString(execution.ConcurrencyKey),
+		execution.Mode,
 	).Scan(&execution.ID, &execution.CreatedAt, &execution.UpdatedAt)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to schedule workflow: %w", err)
+		return fmt.Errorf("failed to schedule workflow: %w", err)
 	}
 
-	logger.Info("Scheduled workflow %d for execution at %s", workflowID, scheduledAt)
-	return execution, nil
+	logger.Info("Scheduled workflow %d for execution at %s", execution.WorkflowID, execution.ScheduledAt)
+	return nil
 }
 
 func (wm *WorkflowManager) GetPendingExecutions() ([]*WorkflowExecution, error) {
 	query := `
-		SELECT id, workflow_id, status, parameters, result, error_message, scheduled_at, started_at, completed_at, created_at, updated_at
+		SELECT ` + executionColumns + `
 		FROM workflow_executions
 		WHERE status = $1 AND scheduled_at <= NOW()
 		ORDER BY scheduled_at
@@ -235,20 +428,7 @@ func (wm *WorkflowManager) GetPendingExecutions() ([]*WorkflowExecution, error)
 
 	executions := []*WorkflowExecution{}
 	for rows.Next() {
-		execution := &WorkflowExecution{}
-		err := rows.Scan(
-			&execution.ID,
-			&execution.WorkflowID,
-			&execution.Status,
-			&execution.Parameters,
-			&execution.Result,
-			&execution.ErrorMessage,
-			&execution.ScheduledAt,
-			&execution.StartedAt,
-			&execution.CompletedAt,
-			&execution.CreatedAt,
-			&execution.UpdatedAt,
-		)
+		execution, err := scanExecution(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan execution: %w", err)
 		}
@@ -275,8 +455,8 @@ func (wm *WorkflowManager) UpdateExecution(execution *WorkflowExecution) error {
 		execution.Status,
 		execution.Result,
 		execution.ErrorMessage,
-		execution.StartedAt,
-		execution.CompletedAt,
+		nullTime(execution.StartedAt),
+		nullTime(execution.CompletedAt),
 		execution.ID,
 	).Scan(&execution.UpdatedAt)
 
@@ -287,6 +467,145 @@ func (wm *WorkflowManager) UpdateExecution(execution *WorkflowExecution) error {
 	return nil
 }
 
+const workflowColumns = `id, name, description, code, steps, parameter_schema, status, revision, active_revision, max_runtime_seconds, max_attempts, backoff_strategy,
+		backoff_seconds, max_concurrency, concurrency_key, retention_days, retention_action, netbox_priority, created_at, updated_at`
+
+func scanWorkflow(row rowScanner) (*Workflow, error) {
+	workflow := &Workflow{}
+	var description, concurrencyKey, retentionAction, netboxPriority sql.NullString
+	var steps, parameterSchema []byte
+
+	err := row.Scan(
+		&workflow.ID,
+		&workflow.Name,
+		&description,
+		&workflow.Code,
+		&steps,
+		&parameterSchema,
+		&workflow.Status,
+		&workflow.Revision,
+		&workflow.ActiveRevision,
+		&workflow.MaxRuntimeSeconds,
+		&workflow.MaxAttempts,
+		&workflow.BackoffStrategy,
+		&workflow.BackoffSeconds,
+		&workflow.MaxConcurrency,
+		&concurrencyKey,
+		&workflow.RetentionDays,
+		&retentionAction,
+		&netboxPriority,
+		&workflow.CreatedAt,
+		&workflow.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	workflow.Description = description.String
+	workflow.ConcurrencyKey = concurrencyKey.String
+	workflow.RetentionAction = RetentionAction(retentionAction.String)
+	workflow.NetboxPriority = queue.NetboxPriority(netboxPriority.String)
+	if len(parameterSchema) > 0 {
+		workflow.ParameterSchema = json.RawMessage(parameterSchema)
+	}
+	if workflow.Steps, err = unmarshalSteps(steps); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+const executionColumns = `id, workflow_id, status, parameters, result, error_message, scheduled_at, started_at, completed_at,
+		locked_by, lease_expires_at, schedule_id, cancel_requested_at, attempt, revision, compensations, concurrency_key, mode,
+		plan_execution_id, parent_execution_id, parent_step, parent_attempt, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanExecution(row rowScanner) (*WorkflowExecution, error) {
+	execution := &WorkflowExecution{}
+	var parameters, result, compensations []byte
+	var errorMessage, lockedBy, concurrencyKey, parentStep sql.NullString
+	var startedAt, completedAt, leaseExpiresAt, cancelRequestedAt sql.NullTime
+	var scheduleID, revision, planExecutionID, parentExecutionID, parentAttempt sql.NullInt64
+
+	err := row.Scan(
+		&execution.ID,
+		&execution.WorkflowID,
+		&execution.Status,
+		&parameters,
+		&result,
+		&errorMessage,
+		&execution.ScheduledAt,
+		&startedAt,
+		&completedAt,
+		&lockedBy,
+		&leaseExpiresAt,
+		&scheduleID,
+		&cancelRequestedAt,
+		&execution.Attempt,
+		&revision,
+		&compensations,
+		&concurrencyKey,
+		&execution.Mode,
+		&planExecutionID,
+		&parentExecutionID,
+		&parentStep,
+		&parentAttempt,
+		&execution.CreatedAt,
+		&execution.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parameters) > 0 {
+		execution.Parameters = json.RawMessage(parameters)
+	}
+	if len(result) > 0 {
+		execution.Result = json.RawMessage(result)
+	}
+	execution.ErrorMessage = errorMessage.String
+	execution.StartedAt = startedAt.Time
+	execution.CompletedAt = completedAt.Time
+	execution.LockedBy = lockedBy.String
+	execution.LeaseExpiresAt = leaseExpiresAt.Time
+	execution.ScheduleID = int(scheduleID.Int64)
+	execution.CancelRequestedAt = cancelRequestedAt.Time
+	execution.Revision = int(revision.Int64)
+	execution.ConcurrencyKey = concurrencyKey.String
+	execution.PlanExecutionID = int(planExecutionID.Int64)
+	execution.ParentExecutionID = int(parentExecutionID.Int64)
+	execution.ParentStep = parentStep.String
+	execution.ParentAttempt = int(parentAttempt.Int64)
+	if len(compensations) > 0 {
+		if err := json.Unmarshal(compensations, &execution.Compensations); err != nil {
+			return nil, fmt.Errorf("failed to decode compensations: %w", err)
+		}
+	}
+
+	return execution, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return []byte(data)
+}
+
 func Init(db *sql.DB) error {
 	logger.Info("Initializing workflow system")
 	logger.Info("Workflow system initialized successfully")